@@ -16,6 +16,13 @@ const (
 	interfaceAdvanced = "advanced"
 )
 
+const (
+	sinkBell    = "bell"
+	sinkCommand = "command"
+	sinkLog     = "log"
+	sinkWebhook = "webhook"
+)
+
 const (
 	configurationName         = "clici.toml"
 	flagForBuildingConfigFile = "make-default-config"
@@ -28,15 +35,40 @@ var options struct {
 		Username string
 		Password string
 		Jobs     []string
+		Refresh  duration
 	}
 	Application struct {
 		Mock    bool
 		Refresh duration
 		DoLog   bool
 	}
+	Remote struct {
+		Location  string
+		Token     string
+		Heartbeat duration
+	}
 	Interface struct {
 		Mode         string
 		AvoidUnicode bool
+		Me           []string
+		Sort         string
+		Group        string
+		BrokenFirst  bool
+	}
+	History struct {
+		Path string
+	}
+	Notification []struct {
+		Sink        string
+		Command     string
+		Arguments   []string
+		Path        string
+		Jobs        []string
+		Transitions []string
+		QuietHours  string
+		URLs        []string
+		Body        string
+		Retries     *int
 	}
 	CommandLine struct {
 		showVersion *bool
@@ -53,7 +85,9 @@ func (d *duration) UnmarshalText(text []byte) error {
 	return err
 }
 
-func init() {
+// loadConfiguration parses the command line and reads the configuration file. It is not done in init,
+// so that the package can be tested without a configuration file
+func loadConfiguration() {
 	options.CommandLine.showVersion = flag.Bool("version", false, "Get application version")
 	buildConfFile := flag.Bool(flagForBuildingConfigFile, false, "Create default configuration file besides executable")
 	flag.Parse()
@@ -24,18 +24,24 @@ type JenkinsAPIRoot struct {
 // Controller is a class that is a backend per-server notification source.
 // It is able to communicate changes detected in state of the Jenkins server back to the View.
 type Controller struct {
-	View  view.View
-	APIs  []JenkinsAPIRoot
-	state model.State
+	View view.View
+	APIs []JenkinsAPIRoot
+	// BuildObserver, if set, is told about each finished build seen while refreshing, together with its causes
+	BuildObserver func(api jenkins.API, server string, job string, status *jenkins.JobStatus, causes []string)
+	state         model.State
+	// endpointErrors keeps the last error of each endpoint (by index in APIs) that did not recover yet
+	endpointErrors map[int]error
 }
 
-// RefreshNodeInformation will start Jenkins API visiting and send updates to the view
+// RefreshNodeInformation will start Jenkins API visiting only for the given jobs and send updates to the view.
+// States of other jobs already known to the controller are left intact
 // FIXME: it is not clear enough to know job names - you need server name as well
 func (controller *Controller) RefreshNodeInformation(knownJobs []string) {
 	log.Println("Controller: RefreshNodeInformation")
 	state := &controller.state
 	state.Error = nil
 	for _, endpoint := range controller.APIs {
+		endpoint.Jobs = knownJobs
 		resultFromJenkins, err := endpoint.API.GetKnownJobs()
 		if err != nil {
 			log.Printf("Error state for partial update: %v", err)
@@ -66,35 +72,150 @@ func (controller *Controller) RefreshNodeInformation(knownJobs []string) {
 	controller.updateView()
 }
 
-// RefreshAllNodeInformation is going to visit all the servers and all the jobs
-// and update the state
-func (controller *Controller) RefreshAllNodeInformation() {
-	log.Println("Controller: RefreshAllNodeInformation")
+// ForgetJobs removes states of the jobs not accepted by keep, for example jobs nobody is interested in anymore.
+// It gives back the number of states left
+func (controller *Controller) ForgetJobs(keep func(jobName string) bool) int {
+	state := &controller.state
+	kept := state.JobStates[:0]
+	for _, jobState := range state.JobStates {
+		if keep(jobState.JobName) {
+			kept = append(kept, jobState)
+		}
+	}
+	state.JobStates = kept
+	return len(kept)
+}
+
+// ApplyRemoteUpdate merges job states pushed by the Clici server into the known states.
+// If snapshot is set, states replace everything known so far
+func (controller *Controller) ApplyRemoteUpdate(jobStates []model.JobState, snapshot bool) {
+	log.Println("Controller: ApplyRemoteUpdate")
 	state := &controller.state
 	state.Error = nil
-	state.JobStates = make([]model.JobState, 0)
-	for _, endpoint := range controller.APIs {
-		resultFromJenkins, err := endpoint.API.GetKnownJobs()
-		if err != nil {
-			log.Printf("Error state: %v", err)
-			state.Error = err
-			state.JobStates = make([]model.JobState, 0)
-			break
-		} else {
-			jobStates, err := controller.explainProperStates(&endpoint, resultFromJenkins)
-			if err != nil {
-				state.Error = err
-				state.JobStates = make([]model.JobState, 0)
+	if snapshot {
+		state.JobStates = make([]model.JobState, 0)
+	}
+	now := time.Now()
+	for _, remoteState := range jobStates {
+		explainRemoteTime(&remoteState, now)
+		found := false
+		for i, modelState := range state.JobStates {
+			if modelState.Server == remoteState.Server && modelState.JobName == remoteState.JobName {
+				state.JobStates[i] = remoteState
+				found = true
 				break
 			}
-			for _, jobState := range jobStates {
-				state.JobStates = append(state.JobStates, *jobState)
+		}
+		if !found {
+			state.JobStates = append(state.JobStates, remoteState)
+		}
+	}
+	controller.updateView()
+}
+
+// ConnectionLost marks known job states as stale since connection towards Clici server was lost
+func (controller *Controller) ConnectionLost(since time.Time, err error) {
+	log.Println("Controller: ConnectionLost")
+	state := &controller.state
+	state.DisconnectedSince = since
+	if len(state.JobStates) == 0 {
+		state.Error = fmt.Errorf("Could not connect to Clici server: %v", err)
+	}
+	controller.updateView()
+}
+
+// ConnectionRestored removes the stale marker after reconnecting to the Clici server
+func (controller *Controller) ConnectionRestored() {
+	log.Println("Controller: ConnectionRestored")
+	controller.state.DisconnectedSince = time.Time{}
+	controller.state.Error = nil
+	if len(controller.state.JobStates) != 0 {
+		// otherwise the snapshot that follows will refresh the view
+		controller.updateView()
+	}
+}
+
+// FetchEndpoint visits only a single endpoint (given by its index in APIs), asking for the given jobs (names or globs).
+// State is not touched and APIs are safe for concurrent use (their caches are locked), so it is safe to call it
+// outside of the goroutine which uses the controller otherwise; results are expected to be given back via ApplyEndpointRefresh
+func (controller *Controller) FetchEndpoint(id int, jobs []string) (refreshed []model.JobState, err error) {
+	endpoint := controller.APIs[id]
+	log.Printf("Controller: FetchEndpoint %v", endpoint.Server)
+	endpoint.Jobs = jobs
+	resultFromJenkins, err := endpoint.API.GetKnownJobs()
+	if err != nil {
+		return
+	}
+	jobStates, err := controller.explainProperStates(&endpoint, resultFromJenkins)
+	if err != nil {
+		return
+	}
+	for _, jobState := range jobStates {
+		refreshed = append(refreshed, *jobState)
+	}
+	return
+}
+
+// RefreshStarted marks that the given number of endpoints is going to be refreshed
+func (controller *Controller) RefreshStarted(total int) {
+	controller.state.Refresh = model.RefreshProgress{Total: total}
+	controller.updateView()
+}
+
+// RefreshFinished marks that there is no refresh in progress anymore, either because all endpoints were visited
+// or because it got cancelled
+func (controller *Controller) RefreshFinished() {
+	controller.state.Refresh = model.RefreshProgress{}
+	controller.updateView()
+}
+
+// ApplyEndpointRefresh merges result of FetchEndpoint into the state: refreshed states replace the ones already known,
+// states of other jobs are left intact. In case of an error it is kept as state error until the endpoint recovers
+func (controller *Controller) ApplyEndpointRefresh(id int, refreshed []model.JobState, err error) {
+	log.Println("Controller: ApplyEndpointRefresh")
+	if err != nil {
+		log.Printf("Error state for %v: %v", controller.APIs[id].Server, err)
+		refreshed = nil
+	}
+	controller.setEndpointError(id, err)
+	state := &controller.state
+	for _, endpointState := range refreshed {
+		found := false
+		for i, modelState := range state.JobStates {
+			if modelState.Server == endpointState.Server && modelState.JobName == endpointState.JobName {
+				state.JobStates[i] = endpointState
+				found = true
+				break
 			}
 		}
+		if !found {
+			state.JobStates = append(state.JobStates, endpointState)
+		}
+	}
+	if state.Refresh.Done < state.Refresh.Total {
+		state.Refresh.Done++
 	}
 	controller.updateView()
 }
 
+func (controller *Controller) setEndpointError(id int, err error) {
+	if controller.endpointErrors == nil {
+		controller.endpointErrors = make(map[int]error)
+	}
+	if err != nil {
+		controller.endpointErrors[id] = err
+	} else {
+		delete(controller.endpointErrors, id)
+	}
+	controller.state.Error = nil
+	for i := range controller.APIs {
+		if endpointErr, ok := controller.endpointErrors[i]; ok {
+			controller.state.Error = endpointErr
+			break
+		}
+	}
+}
+
 func (controller *Controller) updateView() {
 	if controller.View != nil {
 		controller.View.PresentState(&controller.state)
@@ -109,17 +230,21 @@ func (controller *Controller) explainProperStates(jenkinsAPIRoot *JenkinsAPIRoot
 				JobName:       item.Name,
 				Server:        jenkinsAPIRoot.Server,
 				PreviousState: model.BuildStatusFromColor(item.Color),
+				Queued:        item.InQueue,
 			})
 		}
 	} else {
+		alreadyAdded := make(map[string]bool)
 		for _, jobWeCareAbout := range jenkinsAPIRoot.Jobs {
 			for _, item := range jenkinsAnswer.JobBuildStatus {
-				if jobWeCareAbout == item.Name {
+				if matchesJob(jobWeCareAbout, item.Name) && !alreadyAdded[item.Name] {
+					alreadyAdded[item.Name] = true
 					jobStates = append(jobStates, &model.JobState{
 						Group:         jenkinsAPIRoot.Group,
 						JobName:       item.Name,
 						Server:        jenkinsAPIRoot.Server,
 						PreviousState: model.BuildStatusFromColor(item.Color),
+						Queued:        item.InQueue,
 					})
 				}
 			}
@@ -130,10 +255,19 @@ func (controller *Controller) explainProperStates(jenkinsAPIRoot *JenkinsAPIRoot
 		iterState := jobStates[ind]
 		status, err2 := jenkinsAPIRoot.API.GetCurrentStatus(iterState.JobName)
 		if err2 == nil {
-			iterState.CausesFriendly = joinInCSV(jenkinsAPIRoot.API.Causes(status))
+			causes := jenkinsAPIRoot.API.Causes(status)
+			if controller.BuildObserver != nil && !status.Building {
+				controller.BuildObserver(jenkinsAPIRoot.API, jenkinsAPIRoot.Server, iterState.JobName, status, causes)
+			}
+			iterState.CausesFriendly = joinInCSV(causes)
 			iterState.CulpritsFriendly = joinInCSV(jenkinsAPIRoot.API.CausesOfPreviousFailures(iterState.JobName))
 			iterState.Building = status.Building
-			iterState.Time = controller.explainTime(*status)
+			iterState.Started = time.Unix(0, status.Timestamp*int64(time.Millisecond))
+			iterState.Duration = time.Duration(status.Duration) * time.Millisecond
+			if status.Building {
+				iterState.Duration = time.Duration(status.EstimatedDuration) * time.Millisecond
+			}
+			iterState.Time = explainTime(iterState, time.Now())
 		} else {
 			iterState.Error = err2
 		}
@@ -144,29 +278,71 @@ func (controller *Controller) explainProperStates(jenkinsAPIRoot *JenkinsAPIRoot
 	return
 }
 
-func (controller *Controller) explainTime(status jenkins.JobStatus) string {
-	secLeft := status.EstimatedDuration/1000 - (time.Now().UnixNano()/1000/1000-status.Timestamp)/1000
-	if status.Building {
+// explainTime tells how much longer the build is expected to run, or how long ago it has finished
+func explainTime(jobState *model.JobState, now time.Time) string {
+	secLeft := int64(jobState.Started.Add(jobState.Duration).Sub(now) / time.Second)
+	if jobState.Building {
 		if secLeft >= 0 {
 			return fmt.Sprintf("%v min more", secLeft/60)
 		}
 		return fmt.Sprintf("%v min longer than expected", -secLeft/60)
 	}
-	return humanize.Time(time.Now().Add(time.Duration(secLeft) * time.Second))
+	return humanize.Time(now.Add(time.Duration(secLeft) * time.Second))
+}
+
+// explainRemoteTime replaces the time text of a state pushed by the Clici server with one relative to now.
+// Servers which don't send the start of the build are trusted with the text
+func explainRemoteTime(jobState *model.JobState, now time.Time) {
+	if !jobState.Started.IsZero() {
+		jobState.Time = explainTime(jobState, now)
+	}
+}
+
+// ExplainTimes refreshes relative times of the states pushed by the Clici server, since they are not
+// pushed again only because time has passed
+func (controller *Controller) ExplainTimes() {
+	now := time.Now()
+	for i := range controller.state.JobStates {
+		explainRemoteTime(&controller.state.JobStates[i], now)
+	}
+	controller.updateView()
+}
+
+// Action calls Jenkins (directly or through the Clici server) on behalf of the user. It can take long, so it is meant
+// to be called outside of the goroutine which uses the controller; result is expected to be given back via ApplyAction
+type Action func() ActionResult
+
+// ActionResult is what an Action got from Jenkins
+type ActionResult struct {
+	// URL is opened in the browser, if set
+	URL string
+	// FailedTests are not nil when action asked for them, even if no test failed
+	FailedTests []model.TestCase
+	Err         error
 }
 
-// VisitCurrentJob will open the browser and direct you to the url where last build for a certain job will be shown
-func (controller *Controller) VisitCurrentJob(id int) {
-	if api, ok := controller.apiForState(id); ok {
-		controller.visitURL(api.GetLastBuildURLForJob(controller.state.JobStates[id].JobName))
+// VisitCurrentJob gives the action which will open the browser and direct you to the url where last build for a certain job will be shown
+func (controller *Controller) VisitCurrentJob(id int) (action Action, ok bool) {
+	api, ok := controller.apiForState(id)
+	if !ok {
+		return
 	}
+	jobName := controller.state.JobStates[id].JobName
+	return func() ActionResult {
+		return ActionResult{URL: api.GetLastBuildURLForJob(jobName)}
+	}, true
 }
 
-// VisitPreviousJob will open the browser and direct you to the url where last completed build for a certain job will be shown
-func (controller *Controller) VisitPreviousJob(id int) {
-	if api, ok := controller.apiForState(id); ok {
-		controller.visitURL(api.GetLastCompletedBuildURLForJob(controller.state.JobStates[id].JobName))
+// VisitPreviousJob gives the action which will open the browser and direct you to the url where last completed build for a certain job will be shown
+func (controller *Controller) VisitPreviousJob(id int) (action Action, ok bool) {
+	api, ok := controller.apiForState(id)
+	if !ok {
+		return
 	}
+	jobName := controller.state.JobStates[id].JobName
+	return func() ActionResult {
+		return ActionResult{URL: api.GetLastCompletedBuildURLForJob(jobName)}
+	}, true
 }
 
 func (controller *Controller) apiForState(id int) (jenkins.API, bool) {
@@ -189,23 +365,32 @@ func (controller *Controller) visitURL(url string) {
 	}
 }
 
-// ShowTests will ask Jenkins for failed tests in last execution of a certain job and update view with that info
-func (controller *Controller) ShowTests(id int) {
+// ShowTests gives the action which will ask Jenkins for failed tests in last execution of a certain job
+func (controller *Controller) ShowTests(id int) (action Action, ok bool) {
 	log.Println("Controller: ShowTests")
-	if api, ok := controller.apiForState(id); ok {
-		failedTests, err := api.GetFailedTestList(controller.state.JobStates[id].JobName)
+	api, ok := controller.apiForState(id)
+	if !ok {
+		return
+	}
+	jobName := controller.state.JobStates[id].JobName
+	return func() ActionResult {
+		failedTests, err := api.GetFailedTestList(jobName)
 		if err != nil {
-			log.Printf("Error state: %v", err)
-			controller.state.Error = err
-		} else {
-			testNames := make([]string, len(failedTests))
-			for i, failedTest := range failedTests {
-				testNames[i] = fmt.Sprintf("%s %s", failedTest.ClassName, failedTest.Name)
+			return ActionResult{Err: err}
+		}
+		testCases := make([]model.TestCase, len(failedTests))
+		for i, failedTest := range failedTests {
+			testCases[i] = model.TestCase{
+				ClassName:   failedTest.ClassName,
+				Name:        failedTest.Name,
+				Status:      failedTest.Status,
+				StackTrace:  failedTest.ErrorStackTrace,
+				Age:         failedTest.Age,
+				FailedSince: failedTest.FailedSince,
 			}
-			controller.state.FailedTests = testNames
 		}
-		controller.updateView()
-	}
+		return ActionResult{FailedTests: testCases}
+	}, true
 }
 
 // ShowHelp will update view with a flag to show help
@@ -221,17 +406,35 @@ func (controller *Controller) RemoveModals() {
 	controller.state.ShowHelp = false
 	controller.state.Error = nil
 	controller.state.FailedTests = nil
+	controller.state.Details = nil
 	controller.updateView()
 }
 
-func (controller *Controller) RunJob(id int) {
+// RunJob gives the action which will start a certain job
+func (controller *Controller) RunJob(id int) (action Action, ok bool) {
 	log.Println("Controller: RunJob")
-	if api, ok := controller.apiForState(id); ok {
-		err := api.RunJob(controller.state.JobStates[id].JobName)
-		if err != nil {
-			log.Printf("Error state: %v", err)
-			controller.state.Error = err
-		}
-		controller.updateView()
+	api, ok := controller.apiForState(id)
+	if !ok {
+		return
+	}
+	jobName := controller.state.JobStates[id].JobName
+	return func() ActionResult {
+		return ActionResult{Err: api.RunJob(jobName)}
+	}, true
+}
+
+// ApplyAction shows the result of an action executed in background
+func (controller *Controller) ApplyAction(result ActionResult) {
+	log.Println("Controller: ApplyAction")
+	if result.Err != nil {
+		log.Printf("Error state: %v", result.Err)
+		controller.state.Error = result.Err
+	}
+	if result.FailedTests != nil {
+		controller.state.FailedTests = result.FailedTests
 	}
+	if result.URL != "" {
+		controller.visitURL(result.URL)
+	}
+	controller.updateView()
 }
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+	"github.com/milanaleksic/clici/model"
+)
+
+const (
+	// detailLogLines is how many lines from the end of the build log are shown in job details
+	detailLogLines = 10
+	// maxUpstreamDepth limits how far upstream builds are followed when explaining causes of a build
+	maxUpstreamDepth = 5
+)
+
+// DetailsRequest is what is needed to fetch details of a job outside of the goroutine which uses the controller
+type DetailsRequest struct {
+	JobName string
+	Server  string
+	api     jenkins.API
+}
+
+// RequestDetails prepares fetching details of the last build of a certain job, if the job is known
+func (controller *Controller) RequestDetails(id int) (request DetailsRequest, ok bool) {
+	log.Println("Controller: RequestDetails")
+	api, ok := controller.apiForState(id)
+	if !ok {
+		return
+	}
+	jobState := controller.state.JobStates[id]
+	return DetailsRequest{JobName: jobState.JobName, Server: jobState.Server, api: api}, true
+}
+
+// FetchDetails asks Jenkins for everything about the last build of the requested job. Following upstream builds,
+// failed tests and the log take several requests, so it is meant to be called outside of the goroutine which uses
+// the controller; state is not touched and results are expected to be given back via ApplyDetails
+func FetchDetails(request DetailsRequest) (*model.JobDetails, error) {
+	api := request.api
+	status, err := api.GetCurrentStatus(request.JobName)
+	if err != nil {
+		return nil, err
+	}
+	details := &model.JobDetails{
+		JobName:           request.JobName,
+		Server:            request.Server,
+		BuildID:           status.ID,
+		Result:            status.Result,
+		Building:          status.Building,
+		Started:           time.Unix(0, status.Timestamp*int64(time.Millisecond)),
+		Duration:          time.Duration(status.Duration) * time.Millisecond,
+		EstimatedDuration: time.Duration(status.EstimatedDuration) * time.Millisecond,
+		Causes:            causesChain(api, status, 0),
+		Culprits:          culpritsOf(status),
+		Authors:           authorsOf(status),
+		FailedTestCount:   failedTestCount(api, request.JobName, status),
+	}
+	if status.Building {
+		details.Result = "BUILDING"
+		details.Duration = time.Since(details.Started)
+	}
+	buildID := status.ID
+	if buildID == "" {
+		buildID = "lastBuild"
+	}
+	if details.LogLines, err = api.GetLastLogLines(request.JobName, buildID, detailLogLines); err != nil {
+		details.LogLines = []string{fmt.Sprintf("Could not fetch the log: %v", err)}
+	}
+	return details, nil
+}
+
+// ApplyDetails shows details fetched by FetchDetails, unless the job is not known anymore
+func (controller *Controller) ApplyDetails(details *model.JobDetails, err error) {
+	log.Println("Controller: ApplyDetails")
+	if err != nil {
+		log.Printf("Error state: %v", err)
+		controller.state.Error = err
+		controller.updateView()
+		return
+	}
+	for i, jobState := range controller.state.JobStates {
+		if jobState.Server == details.Server && jobState.JobName == details.JobName {
+			// jobs could have been replaced while details were fetched
+			details.Job = i
+			controller.state.Details = details
+			controller.updateView()
+			return
+		}
+	}
+	log.Printf("Job %v on %v is not known anymore, details are not shown", details.JobName, details.Server)
+}
+
+// causesChain describes causes of the build, following upstream builds which caused it
+func causesChain(api jenkins.API, status *jenkins.JobStatus, depth int) (chain []string) {
+	for _, action := range status.Actions {
+		for _, cause := range action.Causes {
+			description := cause.ShortDescription
+			if description == "" && cause.UserID != "" {
+				description = fmt.Sprintf("Started by %v", cause.UserID)
+			}
+			if description != "" {
+				chain = append(chain, description)
+			}
+			if cause.UpstreamProject == "" || depth >= maxUpstreamDepth {
+				continue
+			}
+			upstream, err := api.GetStatusForJob(cause.UpstreamProject, strconv.Itoa(cause.UpstreamBuild))
+			if err != nil {
+				log.Printf("Could not follow upstream build %v #%v: %v", cause.UpstreamProject, cause.UpstreamBuild, err)
+				continue
+			}
+			for _, upstreamCause := range causesChain(api, upstream, depth+1) {
+				chain = append(chain, "  "+upstreamCause)
+			}
+		}
+	}
+	return
+}
+
+func culpritsOf(status *jenkins.JobStatus) []string {
+	set := make(map[string]bool)
+	for _, culprit := range status.Culprits {
+		set[culprit.FullName] = true
+	}
+	return sortedKeys(set)
+}
+
+func authorsOf(status *jenkins.JobStatus) []string {
+	set := make(map[string]bool)
+	for _, changeSet := range status.ChangeSets {
+		for _, item := range changeSet.Items {
+			set[item.Author.FullName] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+func failedTestCount(api jenkins.API, job string, status *jenkins.JobStatus) int {
+	switch {
+	case status.Building || status.ID == "":
+		return -1
+	case status.Result == "SUCCESS":
+		return 0
+	}
+	failedTests, err := api.GetFailedTestListFor(job, status.ID)
+	if err != nil {
+		log.Printf("Could not fetch failed tests of %v #%v: %v", job, status.ID, err)
+		return -1
+	}
+	return len(failedTests)
+}
+
+func sortedKeys(set map[string]bool) (keys []string) {
+	for key := range set {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
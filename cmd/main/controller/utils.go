@@ -1,10 +1,21 @@
 package controller
 
 import (
+	"path"
 	"sort"
 	"strings"
 )
 
+// matchesJob checks if a job name is the one we care about, where the job we care about
+// can also be a glob like "deploy-*" (as understood by path.Match)
+func matchesJob(jobWeCareAbout string, jobName string) bool {
+	if jobWeCareAbout == jobName {
+		return true
+	}
+	matched, err := path.Match(jobWeCareAbout, jobName)
+	return err == nil && matched
+}
+
 func joinInCSV(m []string) string {
 	sep := ", "
 	if len(m) == 0 {
@@ -6,50 +6,291 @@ import (
 
 	"github.com/milanaleksic/clici/cmd/main/controller"
 	"github.com/milanaleksic/clici/cmd/main/view"
+	"github.com/milanaleksic/clici/cmd/server/client"
+	"github.com/milanaleksic/clici/model"
 )
 
+// relativeTimeRefresh is how often relative times of builds pushed by the Clici server are brought up to date
+const relativeTimeRefresh = time.Minute
+
 type dispatcher struct {
 	feedbackChannel chan view.Command
 	controller      *controller.Controller
+	// remote is set when job states are pushed by the Clici server instead of polling Jenkins
+	remote *client.Client
+	// when polling Jenkins, schedule decides what should be refreshed when the timer fires
+	schedule *pollSchedule
+	timer    *time.Timer
+	// results of the refresh in progress come from the background worker, channel is closed when it is done
+	results   <-chan refreshResult
+	cancel    chan struct{}
+	cancelled bool
+	// details and failed tests of a job are fetched in background as well, modalRequested tells which request
+	// is the latest one, so that those which came too late (after closing them or asking for other ones) are ignored
+	details        chan detailsResult
+	modalRequested int
+	// actions (like running a job) are executed in background too, since they can wait long on the Clici server
+	actions chan actionResult
+}
+
+type detailsResult struct {
+	request int
+	details *model.JobDetails
+	err     error
+}
+
+// actionResult is the result of an action, request is set for actions showing a modal
+type actionResult struct {
+	controller.ActionResult
+	request int
+}
+
+// refreshTask is a single endpoint the background worker should visit
+type refreshTask struct {
+	endpoint  *endpointSchedule
+	jobs      []string
+	discovery bool
+}
+
+type refreshResult struct {
+	refreshTask
+	states []model.JobState
+	err    error
 }
 
 func (dispatcher *dispatcher) mainLoop() {
-	ticker := time.NewTicker(options.Application.Refresh.Duration)
-	defer ticker.Stop()
-	firstRun := make(chan bool, 1)
-	firstRun <- true
+	var refresh <-chan time.Time
+	var remoteEvents <-chan client.Event
+	var relativeTimes <-chan time.Time
+	dispatcher.details = make(chan detailsResult)
+	dispatcher.actions = make(chan actionResult)
+	if dispatcher.remote != nil {
+		go dispatcher.remote.Run()
+		defer dispatcher.remote.Close()
+		remoteEvents = dispatcher.remote.Events()
+		ticker := time.NewTicker(relativeTimeRefresh)
+		defer ticker.Stop()
+		relativeTimes = ticker.C
+	} else {
+		dispatcher.schedule = newPollSchedule(dispatcher.controller.APIs, refreshOfServer)
+		dispatcher.timer = time.NewTimer(0)
+		defer dispatcher.timer.Stop()
+		refresh = dispatcher.timer.C
+	}
+	defer dispatcher.cancelRefresh()
 	for {
 		select {
 		case x := <-dispatcher.feedbackChannel:
 			if shouldExit := dispatcher.dispatch(x); shouldExit {
 				return
 			}
-		case <-ticker.C:
-			dispatcher.controller.RefreshAllNodeInformation()
-		case <-firstRun:
-			dispatcher.controller.RefreshAllNodeInformation()
+		case <-refresh:
+			dispatcher.startRefresh()
+		case result, ok := <-dispatcher.results:
+			if ok {
+				dispatcher.applyRefresh(result)
+			} else {
+				dispatcher.finishRefresh()
+			}
+		case result := <-dispatcher.details:
+			dispatcher.applyDetails(result)
+		case result := <-dispatcher.actions:
+			dispatcher.applyAction(result)
+		case event := <-remoteEvents:
+			dispatcher.processRemoteEvent(event)
+		case <-relativeTimes:
+			dispatcher.controller.ExplainTimes()
+		}
+	}
+}
+
+// startRefresh hands over endpoints which are due according to the schedule to a background worker,
+// so that commands are still processed while Jenkins servers are being visited
+func (dispatcher *dispatcher) startRefresh() {
+	if dispatcher.results != nil {
+		log.Println("Refresh is already in progress")
+		return
+	}
+	now := time.Now()
+	var tasks []refreshTask
+	for _, endpoint := range dispatcher.schedule.endpoints {
+		jobs, discovery := endpoint.due(now)
+		if discovery || len(jobs) != 0 {
+			tasks = append(tasks, refreshTask{endpoint: endpoint, jobs: jobs, discovery: discovery})
+		}
+	}
+	if len(tasks) == 0 {
+		dispatcher.rescheduleRefresh()
+		return
+	}
+	results := make(chan refreshResult)
+	dispatcher.results = results
+	dispatcher.cancel = make(chan struct{})
+	dispatcher.cancelled = false
+	dispatcher.controller.RefreshStarted(len(tasks))
+	go dispatcher.refreshInBackground(tasks, results, dispatcher.cancel)
+}
+
+func (dispatcher *dispatcher) refreshInBackground(tasks []refreshTask, results chan<- refreshResult, cancel <-chan struct{}) {
+	defer close(results)
+	for _, task := range tasks {
+		select {
+		case <-cancel:
+			return
+		default:
+		}
+		states, err := dispatcher.controller.FetchEndpoint(task.endpoint.id, task.jobs)
+		select {
+		case results <- refreshResult{refreshTask: task, states: states, err: err}:
+		case <-cancel:
+			return
+		}
+	}
+}
+
+func (dispatcher *dispatcher) applyRefresh(result refreshResult) {
+	if dispatcher.cancelled {
+		return
+	}
+	result.endpoint.refreshed(time.Now(), result.discovery, result.states, result.err)
+	dispatcher.controller.ApplyEndpointRefresh(result.endpoint.id, result.states, result.err)
+}
+
+func (dispatcher *dispatcher) finishRefresh() {
+	dispatcher.results = nil
+	dispatcher.controller.RefreshFinished()
+	if dispatcher.cancelled {
+		// endpoints that were skipped are still due, wait for the usual interval instead of starting again immediately
+		dispatcher.timer.Reset(options.Application.Refresh.Duration)
+	} else {
+		dispatcher.rescheduleRefresh()
+	}
+}
+
+func (dispatcher *dispatcher) rescheduleRefresh() {
+	if len(dispatcher.schedule.endpoints) != 0 {
+		dispatcher.timer.Reset(time.Until(dispatcher.schedule.next()))
+	}
+}
+
+// cancelRefresh asks the background worker to stop. Request towards Jenkins that is already sent is not interrupted,
+// but its result is ignored
+func (dispatcher *dispatcher) cancelRefresh() {
+	if dispatcher.results == nil || dispatcher.cancelled {
+		return
+	}
+	log.Println("Cancelling refresh in progress")
+	dispatcher.cancelled = true
+	close(dispatcher.cancel)
+}
+
+// forceRefresh makes all endpoints due and starts refreshing them, unless refresh is already in progress
+func (dispatcher *dispatcher) forceRefresh() {
+	if dispatcher.schedule == nil || dispatcher.results != nil {
+		return
+	}
+	dispatcher.schedule.force()
+	if !dispatcher.timer.Stop() {
+		select {
+		case <-dispatcher.timer.C:
+		default:
+		}
+	}
+	dispatcher.startRefresh()
+}
+
+// showDetails fetches details of the job in background, they are shown once they arrive
+func (dispatcher *dispatcher) showDetails(job int) {
+	request, ok := dispatcher.controller.RequestDetails(job)
+	if !ok {
+		return
+	}
+	dispatcher.modalRequested++
+	go func(id int) {
+		details, err := controller.FetchDetails(request)
+		dispatcher.details <- detailsResult{request: id, details: details, err: err}
+	}(dispatcher.modalRequested)
+}
+
+func (dispatcher *dispatcher) applyDetails(result detailsResult) {
+	if result.request != dispatcher.modalRequested {
+		log.Println("Ignoring details which are not wanted anymore")
+		return
+	}
+	dispatcher.controller.ApplyDetails(result.details, result.err)
+}
+
+// startAction executes the action in background, so that commands and pushed states are still processed meanwhile
+func (dispatcher *dispatcher) startAction(action controller.Action, ok bool, showsModal bool) {
+	if !ok {
+		return
+	}
+	request := 0
+	if showsModal {
+		dispatcher.modalRequested++
+		request = dispatcher.modalRequested
+	}
+	go func() {
+		dispatcher.actions <- actionResult{ActionResult: action(), request: request}
+	}()
+}
+
+func (dispatcher *dispatcher) applyAction(result actionResult) {
+	if result.request != 0 && result.request != dispatcher.modalRequested {
+		log.Println("Ignoring action result which is not wanted anymore")
+		return
+	}
+	dispatcher.controller.ApplyAction(result.ActionResult)
+}
+
+func (dispatcher *dispatcher) processRemoteEvent(event client.Event) {
+	if status := event.Status; status != nil {
+		if status.Connected {
+			dispatcher.controller.ConnectionRestored()
+		} else {
+			dispatcher.controller.ConnectionLost(status.Since, status.Err)
+		}
+	}
+	if update := event.Update; update != nil {
+		jobStates := make([]model.JobState, len(update.States))
+		for i, state := range update.States {
+			jobStates[i] = client.ToModel(state)
+			jobStates[i].Group = groupOfServer(jobStates[i].Server)
 		}
+		dispatcher.controller.ApplyRemoteUpdate(jobStates, update.Snapshot)
 	}
 }
 
 func (dispatcher *dispatcher) dispatch(x view.Command) bool {
-	log.Printf("Dispatcher received command: %q\n", x)
+	log.Printf("Dispatcher received command: %+v\n", x)
 	switch x.Group {
 	case view.CmdShutdownGroup:
 		log.Println("Bye!")
 		return true
 	case view.CmdCloseGroup:
+		// details and tests still being fetched are not wanted anymore
+		dispatcher.modalRequested++
 		dispatcher.controller.RemoveModals()
 	case view.CmdShowHelpGroup:
 		dispatcher.controller.ShowHelp()
 	case view.CmdOpenCurrentJobGroup:
-		dispatcher.controller.VisitCurrentJob(x.Job)
+		action, ok := dispatcher.controller.VisitCurrentJob(x.Job)
+		dispatcher.startAction(action, ok, false)
 	case view.CmdOpenPreviousJobGroup:
-		dispatcher.controller.VisitPreviousJob(x.Job)
+		action, ok := dispatcher.controller.VisitPreviousJob(x.Job)
+		dispatcher.startAction(action, ok, false)
 	case view.CmdTestsForJobGroup:
-		dispatcher.controller.ShowTests(x.Job)
+		action, ok := dispatcher.controller.ShowTests(x.Job)
+		dispatcher.startAction(action, ok, true)
 	case view.CmdRunJob:
-		dispatcher.controller.RunJob(x.Job)
+		action, ok := dispatcher.controller.RunJob(x.Job)
+		dispatcher.startAction(action, ok, false)
+	case view.CmdShowDetailsGroup:
+		dispatcher.showDetails(x.Job)
+	case view.CmdRefreshGroup:
+		dispatcher.forceRefresh()
+	case view.CmdCancelRefreshGroup:
+		dispatcher.cancelRefresh()
 	}
 	return false
 }
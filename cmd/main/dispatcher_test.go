@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/main/controller"
+	"github.com/milanaleksic/clici/cmd/main/view"
+	"github.com/milanaleksic/clici/cmd/server/client"
+	"github.com/milanaleksic/clici/jenkins"
+	"github.com/milanaleksic/clici/model"
+)
+
+// slowAPI doesn't start a job until it is released. Other calls are not expected
+type slowAPI struct {
+	jenkins.API
+	release chan struct{}
+}
+
+func (api *slowAPI) RunJob(job string) error {
+	<-api.release
+	return nil
+}
+
+func TestCommandsAreProcessedWhileActionIsPending(t *testing.T) {
+	api := &slowAPI{release: make(chan struct{})}
+	states := make(chan model.State, 100)
+	ctrl := &controller.Controller{
+		View: view.CallbackAsView(func(state *model.State) { states <- *state }),
+		APIs: []controller.JenkinsAPIRoot{{API: api, Server: "jenkins1"}},
+	}
+	ctrl.ApplyRemoteUpdate([]model.JobState{{Server: "jenkins1", JobName: "job1"}}, true)
+	feedback := make(chan view.Command)
+	d := &dispatcher{
+		feedbackChannel: feedback,
+		controller:      ctrl,
+		// server is never reached, it only keeps the dispatcher from polling Jenkins
+		remote: client.New(client.Config{Location: "ws://127.0.0.1:1/", MinBackoff: time.Minute}),
+	}
+	finished := make(chan struct{})
+	go func() {
+		d.mainLoop()
+		close(finished)
+	}()
+
+	command := func(group string) {
+		select {
+		case feedback <- view.Command{Group: group}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dispatcher is busy, command %v was not taken", group)
+		}
+	}
+	command(view.CmdRunJob)
+	command(view.CmdShowHelpGroup)
+	for shown := false; !shown; {
+		select {
+		case state := <-states:
+			shown = state.ShowHelp
+		case <-time.After(5 * time.Second):
+			t.Fatal("help was not shown")
+		}
+	}
+	close(api.release)
+	command(view.CmdShutdownGroup)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher did not stop")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Package history records every finished build seen while refreshing into a local file, one JSON document
+per line, and computes statistics over them, like failure rate and mean time to recovery.
+*/
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+)
+
+// Build is a single finished build of a job
+type Build struct {
+	Server      string    `json:"server"`
+	Job         string    `json:"job"`
+	Number      string    `json:"number"`
+	Result      string    `json:"result"`
+	Started     time.Time `json:"started"`
+	DurationMs  int64     `json:"durationMs"`
+	Causes      []string  `json:"causes,omitempty"`
+	FailedTests []string  `json:"failedTests,omitempty"`
+}
+
+// Duration is how long the build was running
+func (build Build) Duration() time.Duration {
+	return time.Duration(build.DurationMs) * time.Millisecond
+}
+
+// Failed tells if the build failed, including builds which are unstable because of failed tests
+func (build Build) Failed() bool {
+	return build.Result == "FAILURE" || build.Result == "UNSTABLE"
+}
+
+// Succeeded tells if the build was successful
+func (build Build) Succeeded() bool {
+	return build.Result == "SUCCESS"
+}
+
+type buildKey struct {
+	server string
+	job    string
+	number string
+}
+
+// Store appends builds to a file, recording each build only once
+type Store struct {
+	sync.Mutex
+	file  *os.File
+	known map[buildKey]bool
+}
+
+// Open reads builds already recorded in the file (creating it if needed) and prepares it for appending
+func Open(path string) (*Store, error) {
+	builds, err := Load(path, time.Time{})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{
+		file:  file,
+		known: make(map[buildKey]bool, len(builds)),
+	}
+	for _, build := range builds {
+		store.known[buildKey{server: build.Server, job: build.Job, number: build.Number}] = true
+	}
+	return store, nil
+}
+
+// Close closes the file
+func (store *Store) Close() error {
+	return store.file.Close()
+}
+
+func (store *Store) knows(key buildKey) bool {
+	store.Lock()
+	defer store.Unlock()
+	return store.known[key]
+}
+
+// Record appends the build, unless it is already recorded
+func (store *Store) Record(build Build) error {
+	store.Lock()
+	defer store.Unlock()
+	key := buildKey{server: build.Server, job: build.Job, number: build.Number}
+	if store.known[key] {
+		return nil
+	}
+	line, err := json.Marshal(build)
+	if err != nil {
+		return err
+	}
+	if _, err = store.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	store.known[key] = true
+	return nil
+}
+
+// Observe records a finished build seen by the controller, asking Jenkins for failed tests if the build failed.
+// It can be used as controller.Controller BuildObserver. Only the last build of a job is seen at each refresh,
+// so builds which started and finished between two refreshes are not recorded and statistics are only
+// as complete as the refresh interval allows
+func (store *Store) Observe(api jenkins.API, server string, job string, status *jenkins.JobStatus, causes []string) {
+	if status.ID == "" || status.Building || store.knows(buildKey{server: server, job: job, number: status.ID}) {
+		return
+	}
+	build := Build{
+		Server:     server,
+		Job:        job,
+		Number:     status.ID,
+		Result:     status.Result,
+		Started:    time.Unix(0, status.Timestamp*int64(time.Millisecond)),
+		DurationMs: status.Duration,
+		Causes:     causes,
+	}
+	if build.Failed() {
+		testCases, err := api.GetFailedTestListFor(job, status.ID)
+		if err != nil {
+			log.Printf("Could not get failed tests of build %v of job %v: %v", status.ID, job, err)
+		}
+		for _, testCase := range testCases {
+			build.FailedTests = append(build.FailedTests, fmt.Sprintf("%v.%v", testCase.ClassName, testCase.Name))
+		}
+	}
+	if err := store.Record(build); err != nil {
+		log.Printf("Could not record build %v of job %v: %v", status.ID, job, err)
+	}
+}
+
+// Load reads all builds started after the given moment
+func Load(path string, since time.Time) (builds []Build, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		build := Build{}
+		if err := json.Unmarshal(scanner.Bytes(), &build); err != nil {
+			// a line cut in half by a crash should not make the whole history unreadable
+			log.Printf("Skipping line %d of %v which can't be parsed: %v", lineNumber, path, err)
+			continue
+		}
+		if build.Started.After(since) {
+			builds = append(builds, build)
+		}
+	}
+	return builds, scanner.Err()
+}
@@ -0,0 +1,92 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clici")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "history.jsonl")
+
+	started := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	old := Build{Server: "jenkins1", Job: "job1", Number: "1", Result: "SUCCESS", Started: started.Add(-48 * time.Hour), DurationMs: 1000}
+	failed := Build{
+		Server:      "jenkins1",
+		Job:         "job1",
+		Number:      "2",
+		Result:      "FAILURE",
+		Started:     started,
+		DurationMs:  60000,
+		Causes:      []string{"Started by user"},
+		FailedTests: []string{"com.example.SomeTest.test1"},
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("could not open history: %v", err)
+	}
+	for _, build := range []Build{old, failed, failed} {
+		if err := store.Record(build); err != nil {
+			t.Fatalf("could not record %v: %v", build, err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("could not reopen history: %v", err)
+	}
+	if err := reopened.Record(failed); err != nil {
+		t.Fatalf("could not record %v: %v", failed, err)
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	builds, err := Load(path, time.Time{})
+	if err != nil {
+		t.Fatalf("could not load history: %v", err)
+	}
+	if len(builds) != 2 || !reflect.DeepEqual(builds[1], failed) {
+		t.Fatalf("expected each build to be recorded once, got %+v", builds)
+	}
+	if recent, err := Load(path, started.Add(-time.Hour)); err != nil || len(recent) != 1 || recent[0].Number != "2" {
+		t.Fatalf("expected only the recent build, got %+v (%v)", recent, err)
+	}
+}
+
+func TestLoadSkipsBrokenLines(t *testing.T) {
+	file, err := ioutil.TempFile("", "clici-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	content := `{"server":"jenkins1","job":"job1","number":"1","result":"SUCCESS","started":"2017-07-14T10:00:00Z","durationMs":1000}
+
+{"server":"jenkins1","job":"job1","numb
+`
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	builds, err := Load(file.Name(), time.Time{})
+	if err != nil || len(builds) != 1 || builds[0].Number != "1" {
+		t.Fatalf("expected the single complete build, got %+v (%v)", builds, err)
+	}
+}
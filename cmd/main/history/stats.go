@@ -0,0 +1,133 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// JobStats summarizes builds of a single job
+type JobStats struct {
+	Server   string
+	Job      string
+	Builds   int
+	Failures int
+	// MeanTimeToRecovery is the average time from the start of the first failed build
+	// until the end of the next successful one. It is zero if job never recovered
+	MeanTimeToRecovery time.Duration
+	// LongestRedStreak is the highest number of failed builds in a row, aborted builds don't end the streak
+	LongestRedStreak int
+	// AverageDuration is calculated from successful and failed builds only, since aborted ones were cut short
+	AverageDuration time.Duration
+}
+
+// FailureRate is the share of failed builds, between 0 and 1
+func (stats JobStats) FailureRate() float64 {
+	if stats.Builds == 0 {
+		return 0
+	}
+	return float64(stats.Failures) / float64(stats.Builds)
+}
+
+type byStarted []Build
+
+func (builds byStarted) Len() int           { return len(builds) }
+func (builds byStarted) Swap(i, j int)      { builds[i], builds[j] = builds[j], builds[i] }
+func (builds byStarted) Less(i, j int) bool { return builds[i].Started.Before(builds[j].Started) }
+
+// Compute gives statistics per job, sorted by server and job name. Builds without a result (still running)
+// are not taken into account
+func Compute(builds []Build) (result []JobStats) {
+	perJob := make(map[buildKey][]Build)
+	for _, build := range builds {
+		if build.Result == "" {
+			continue
+		}
+		key := buildKey{server: build.Server, job: build.Job}
+		perJob[key] = append(perJob[key], build)
+	}
+	for key, jobBuilds := range perJob {
+		sort.Sort(byStarted(jobBuilds))
+		result = append(result, computeJob(key, jobBuilds))
+	}
+	sort.Sort(byServerAndJob(result))
+	return
+}
+
+func computeJob(key buildKey, builds []Build) JobStats {
+	stats := JobStats{Server: key.server, Job: key.job, Builds: len(builds)}
+	var totalDuration, totalRecovery time.Duration
+	var redSince time.Time
+	recoveries, streak, measured := 0, 0, 0
+	for _, build := range builds {
+		if build.Failed() || build.Succeeded() {
+			totalDuration += build.Duration()
+			measured++
+		}
+		switch {
+		case build.Failed():
+			stats.Failures++
+			streak++
+			if streak > stats.LongestRedStreak {
+				stats.LongestRedStreak = streak
+			}
+			if redSince.IsZero() {
+				redSince = build.Started
+			}
+		case build.Succeeded():
+			streak = 0
+			if !redSince.IsZero() {
+				totalRecovery += build.Started.Add(build.Duration()).Sub(redSince)
+				recoveries++
+				redSince = time.Time{}
+			}
+		}
+	}
+	if measured > 0 {
+		stats.AverageDuration = totalDuration / time.Duration(measured)
+	}
+	if recoveries > 0 {
+		stats.MeanTimeToRecovery = totalRecovery / time.Duration(recoveries)
+	}
+	return stats
+}
+
+type byServerAndJob []JobStats
+
+func (stats byServerAndJob) Len() int      { return len(stats) }
+func (stats byServerAndJob) Swap(i, j int) { stats[i], stats[j] = stats[j], stats[i] }
+func (stats byServerAndJob) Less(i, j int) bool {
+	if stats[i].Server != stats[j].Server {
+		return stats[i].Server < stats[j].Server
+	}
+	return stats[i].Job < stats[j].Job
+}
+
+// completenessNote explains why statistics can miss builds, see Store.Observe
+const completenessNote = "Note: only the last build of each job is recorded at each refresh, " +
+	"builds which started and finished between two refreshes are missing from these statistics"
+
+// Report writes the statistics as a table, followed by a note on how complete they are
+func Report(w io.Writer, stats []JobStats) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SERVER\tJOB\tBUILDS\tFAILURE RATE\tMTTR\tLONGEST RED STREAK\tAVG DURATION")
+	for _, job := range stats {
+		mttr := "-"
+		if job.MeanTimeToRecovery > 0 {
+			mttr = roundDuration(job.MeanTimeToRecovery).String()
+		}
+		fmt.Fprintf(table, "%v\t%v\t%d\t%.1f%%\t%v\t%d\t%v\n", job.Server, job.Job, job.Builds, 100*job.FailureRate(),
+			mttr, job.LongestRedStreak, roundDuration(job.AverageDuration))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%v\n", completenessNote)
+	return err
+}
+
+func roundDuration(duration time.Duration) time.Duration {
+	return duration - duration%time.Second
+}
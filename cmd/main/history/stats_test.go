@@ -0,0 +1,122 @@
+package history
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildsOf makes builds of a single job, each one starting an hour after the previous one
+func buildsOf(job string, results []string, durations []time.Duration) (builds []Build) {
+	start := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	for i, result := range results {
+		builds = append(builds, Build{
+			Server:     "jenkins1",
+			Job:        job,
+			Number:     string(rune('1' + i)),
+			Result:     result,
+			Started:    start.Add(time.Duration(i) * time.Hour),
+			DurationMs: int64(durations[i] / time.Millisecond),
+		})
+	}
+	return
+}
+
+func TestCompute(t *testing.T) {
+	minute := time.Minute
+	cases := []struct {
+		name      string
+		results   []string
+		durations []time.Duration
+		expected  JobStats
+	}{
+		{
+			name:      "always green",
+			results:   []string{"SUCCESS", "SUCCESS"},
+			durations: []time.Duration{2 * minute, 4 * minute},
+			expected:  JobStats{Builds: 2, AverageDuration: 3 * minute},
+		},
+		{
+			// red since 10:00, fixed by the build which started at 12:00 and took 10 minutes
+			name:      "single recovery",
+			results:   []string{"FAILURE", "UNSTABLE", "SUCCESS"},
+			durations: []time.Duration{minute, minute, 10 * minute},
+			expected:  JobStats{Builds: 3, Failures: 2, LongestRedStreak: 2, MeanTimeToRecovery: 2*time.Hour + 10*minute, AverageDuration: 4 * minute},
+		},
+		{
+			name:      "two recoveries",
+			results:   []string{"FAILURE", "SUCCESS", "FAILURE", "FAILURE", "FAILURE", "SUCCESS"},
+			durations: []time.Duration{minute, minute, minute, minute, minute, minute},
+			expected:  JobStats{Builds: 6, Failures: 4, LongestRedStreak: 3, MeanTimeToRecovery: 2*time.Hour + minute, AverageDuration: minute},
+		},
+		{
+			name:      "never recovered",
+			results:   []string{"SUCCESS", "FAILURE", "FAILURE"},
+			durations: []time.Duration{minute, minute, minute},
+			expected:  JobStats{Builds: 3, Failures: 2, LongestRedStreak: 2, AverageDuration: minute},
+		},
+		{
+			// aborted build was cut short so it doesn't count into the average, nor does it end the streak
+			name:      "aborted build",
+			results:   []string{"FAILURE", "ABORTED", "FAILURE", "SUCCESS"},
+			durations: []time.Duration{4 * minute, 10 * time.Second, 4 * minute, 4 * minute},
+			expected:  JobStats{Builds: 4, Failures: 2, LongestRedStreak: 2, MeanTimeToRecovery: 3*time.Hour + 4*minute, AverageDuration: 4 * minute},
+		},
+		{
+			name:      "build in progress",
+			results:   []string{"SUCCESS", ""},
+			durations: []time.Duration{4 * minute, 0},
+			expected:  JobStats{Builds: 1, AverageDuration: 4 * minute},
+		},
+		{
+			name:      "only aborted",
+			results:   []string{"ABORTED"},
+			durations: []time.Duration{minute},
+			expected:  JobStats{Builds: 1},
+		},
+	}
+	for _, c := range cases {
+		c.expected.Server, c.expected.Job = "jenkins1", "job1"
+		stats := Compute(buildsOf("job1", c.results, c.durations))
+		if len(stats) != 1 || stats[0] != c.expected {
+			t.Errorf("%v: expected %+v, got %+v", c.name, c.expected, stats)
+		}
+	}
+}
+
+func TestComputeSortsBuildsAndJobs(t *testing.T) {
+	minute := time.Minute
+	builds := append(buildsOf("job2", []string{"SUCCESS"}, []time.Duration{minute}),
+		buildsOf("job1", []string{"FAILURE", "SUCCESS"}, []time.Duration{minute, minute})...)
+	// recovery can only be found if builds are looked at in order they started
+	builds[1], builds[2] = builds[2], builds[1]
+	stats := Compute(builds)
+	if len(stats) != 2 || stats[0].Job != "job1" || stats[1].Job != "job2" {
+		t.Fatalf("expected stats of job1 and job2, got %+v", stats)
+	}
+	if stats[0].MeanTimeToRecovery != time.Hour+minute {
+		t.Fatalf("expected recovery of job1 in an hour and a minute, got %v", stats[0].MeanTimeToRecovery)
+	}
+}
+
+func TestEmptyStatsHaveNoFailureRate(t *testing.T) {
+	if rate := (JobStats{}).FailureRate(); rate != 0 {
+		t.Fatalf("expected zero failure rate without builds, got %v", rate)
+	}
+}
+
+func TestReportNotesMissingBuilds(t *testing.T) {
+	var report bytes.Buffer
+	stats := Compute(buildsOf("job1", []string{"FAILURE", "SUCCESS"}, []time.Duration{time.Minute, time.Minute}))
+	if err := Report(&report, stats); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "jenkins1  job1  2") {
+		t.Fatalf("expected header and a line of job1, got %q", report.String())
+	}
+	if lines[3] != completenessNote {
+		t.Fatalf("expected the report to end with a note on missing builds, got %q", lines[3])
+	}
+}
@@ -1,18 +1,36 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/milanaleksic/clici/cmd/main/controller"
+	"github.com/milanaleksic/clici/cmd/main/notifier"
 	"github.com/milanaleksic/clici/cmd/main/view"
+	"github.com/milanaleksic/clici/cmd/server"
+	"github.com/milanaleksic/clici/cmd/server/client"
 	"github.com/milanaleksic/clici/jenkins"
 )
 
 // Version holds the main version string which should be updated externally when building release
 var Version = "undefined"
 
-func getAPI() (result []controller.JenkinsAPIRoot) {
+func getAPI(remote *client.Client) (result []controller.JenkinsAPIRoot) {
+	if remote != nil {
+		for _, aServer := range options.Jenkins {
+			result = append(result, controller.JenkinsAPIRoot{
+				API:    remote.API(aServer.Location),
+				Jobs:   aServer.Jobs,
+				Server: aServer.Location,
+				Group:  aServer.Group,
+			})
+		}
+		return
+	}
 	if options.Application.Mock {
 		for _, aServer := range options.Jenkins {
 			result = append(result, controller.JenkinsAPIRoot{
@@ -34,8 +52,139 @@ func getAPI() (result []controller.JenkinsAPIRoot) {
 	return
 }
 
+// getRemote creates a client towards Clici server if it is configured, subscribing to the same jobs
+// that would otherwise be polled directly
+func getRemote() *client.Client {
+	if options.Remote.Location == "" {
+		return nil
+	}
+	config := client.Config{
+		Location:          options.Remote.Location,
+		Token:             options.Remote.Token,
+		ClientVersion:     Version,
+		HeartbeatInterval: options.Remote.Heartbeat.Duration,
+	}
+	for _, aServer := range options.Jenkins {
+		for _, job := range aServer.Jobs {
+			if job == "" || strings.ContainsAny(job, "*?[") {
+				if job == "" {
+					job = "*"
+				}
+				config.Patterns = append(config.Patterns, &server.Register_Pattern{ServerLocation: aServer.Location, JobGlob: job})
+			} else {
+				config.Jobs = append(config.Jobs, &server.Register_Job{ServerLocation: aServer.Location, JobName: job})
+			}
+		}
+	}
+	return client.New(config)
+}
+
+func groupOfServer(location string) string {
+	for _, aServer := range options.Jenkins {
+		if aServer.Location == location {
+			return aServer.Group
+		}
+	}
+	return ""
+}
+
+// refreshOfServer gives how often jobs of a server are refreshed when nothing special happens with them
+func refreshOfServer(location string) time.Duration {
+	for _, aServer := range options.Jenkins {
+		if aServer.Location == location && aServer.Refresh.Duration > 0 {
+			return aServer.Refresh.Duration
+		}
+	}
+	return options.Application.Refresh.Duration
+}
+
+// getNotifier creates a notifier with all sinks from the configuration. APIs are used by sinks which
+// ask Jenkins for build details
+func getNotifier(apis []controller.JenkinsAPIRoot) *notifier.Notifier {
+	result := notifier.New()
+	for _, configured := range options.Notification {
+		filter := notifier.Filter{Jobs: configured.Jobs}
+		if configured.QuietHours != "" {
+			quietHours, err := notifier.ParseQuietHours(configured.QuietHours)
+			if err != nil {
+				log.Fatalf("Failure while configuring %v notification: %v", configured.Sink, err)
+			}
+			filter.QuietHours = quietHours
+		}
+		for _, name := range configured.Transitions {
+			transition, err := notifier.ParseTransition(name)
+			if err != nil {
+				log.Fatalf("Failure while configuring %v notification: %v", configured.Sink, err)
+			}
+			filter.Transitions = append(filter.Transitions, transition)
+		}
+		switch configured.Sink {
+		case sinkBell:
+			result.AddSink(&notifier.BellSink{Writer: os.Stdout}, filter)
+		case sinkCommand:
+			sink, err := notifier.NewCommandSink(configured.Command, configured.Arguments)
+			if err != nil {
+				log.Fatalf("Failure while configuring command notification: %v", err)
+			}
+			result.AddSink(sink, filter)
+		case sinkLog:
+			sink, err := notifier.NewLogSink(configured.Path)
+			if err != nil {
+				log.Fatalf("Failure while configuring log notification: %v", err)
+			}
+			result.AddSink(sink, filter)
+		case sinkWebhook:
+			sink, err := notifier.NewWebhookSink(configured.URLs, configured.Body, apiOfServer(apis))
+			if err != nil {
+				log.Fatalf("Failure while configuring webhook notification: %v", err)
+			}
+			if configured.Retries != nil {
+				sink.Retries = *configured.Retries
+			}
+			result.AddSink(sink, filter)
+		default:
+			log.Fatalf("Unknown notification sink %q, expected one of %v, %v, %v, %v", configured.Sink, sinkBell, sinkCommand, sinkLog, sinkWebhook)
+		}
+	}
+	return result
+}
+
+func apiOfServer(apis []controller.JenkinsAPIRoot) func(server string) jenkins.API {
+	return func(server string) jenkins.API {
+		for _, api := range apis {
+			if api.Server == server {
+				return api.API
+			}
+		}
+		return nil
+	}
+}
+
+// getOrder gives the order of jobs from the configuration, with defaults for everything not configured
+func getOrder() view.Order {
+	order := view.DefaultOrder
+	order.BrokenFirst = options.Interface.BrokenFirst
+	if options.Interface.Sort != "" {
+		sortMode, err := view.ParseSortMode(options.Interface.Sort)
+		if err != nil {
+			log.Fatalf("Failure while configuring interface: %v", err)
+		}
+		order.Sort = sortMode
+	}
+	if options.Interface.Group != "" {
+		groupMode, err := view.ParseGroupMode(options.Interface.Group)
+		if err != nil {
+			log.Fatalf("Failure while configuring interface: %v", err)
+		}
+		order.Group = groupMode
+	}
+	return order
+}
+
 func getUI(feedbackChannel chan view.Command) (ui view.View, err error) {
 	view.AvoidUnicode = options.Interface.AvoidUnicode
+	view.Me = options.Interface.Me
+	view.DefaultOrder = getOrder()
 	switch options.Interface.Mode {
 	case interfaceSimple:
 		ui = view.NewConsoleInterface(feedbackChannel)
@@ -50,10 +199,15 @@ func getUI(feedbackChannel chan view.Command) (ui view.View, err error) {
 }
 
 func main() {
+	loadConfiguration()
 	if *options.CommandLine.showVersion {
 		fmt.Printf("clici version: %v\n", Version)
 		return
 	}
+	if flag.Arg(0) == commandStats {
+		runStats(flag.Args()[1:])
+		return
+	}
 	setupLog()
 	defer func() {
 		if logFile != nil {
@@ -65,12 +219,23 @@ func main() {
 	if err != nil {
 		log.Fatal("Failure to boot interface", err)
 	}
+	remote := getRemote()
+	apis := getAPI(remote)
+	notifications := getNotifier(apis)
+	defer notifications.Close()
 	dispatcher := &dispatcher{
 		feedbackChannel: feedbackChannel,
 		controller: &controller.Controller{
-			View: ui,
-			APIs: getAPI(),
+			View: notifications.View(ui),
+			APIs: apis,
 		},
+		remote: remote,
+	}
+	if builds := getHistory(); builds != nil {
+		defer func() {
+			_ = builds.Close()
+		}()
+		dispatcher.controller.BuildObserver = builds.Observe
 	}
 	dispatcher.mainLoop()
 }
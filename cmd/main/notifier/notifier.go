@@ -0,0 +1,238 @@
+/*
+Package notifier compares successive states of the application model and tells the configured sinks about
+transitions worth knowing about, like a job going red, so they are not missed while working in another window.
+*/
+package notifier
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/main/view"
+	"github.com/milanaleksic/clici/model"
+)
+
+// Transition is a change between two successive states of a job
+type Transition string
+
+const (
+	// Failed means job was successful and now it failed
+	Failed Transition = "failed"
+	// Fixed means job was failing and now it succeeded
+	Fixed Transition = "fixed"
+	// Started means job was not building and now it is
+	Started Transition = "started"
+	// Finished means job was building and now it is not
+	Finished Transition = "finished"
+)
+
+// queueSize is how many events can wait for each sink before new ones get dropped
+const queueSize = 100
+
+// KnownTransitions lists all transitions, for example to validate configuration
+var KnownTransitions = []Transition{Failed, Fixed, Started, Finished}
+
+// ParseTransition gives the transition with the given name, as used in configuration
+func ParseTransition(name string) (Transition, error) {
+	for _, transition := range KnownTransitions {
+		if string(transition) == name {
+			return transition, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown transition %q, expected one of %v", name, KnownTransitions)
+}
+
+// Event is a single transition of a single job
+type Event struct {
+	Transition Transition
+	Job        model.JobState
+	Previous   model.JobState
+	Time       time.Time
+}
+
+func (event Event) String() string {
+	return fmt.Sprintf("%v on %v %v", event.Job.JobName, event.Job.Server, event.Transition)
+}
+
+// Sink is where notifications go, like terminal bell or a log file. Sinks which are also
+// io.Closer are closed when notifier is closed
+type Sink interface {
+	Notify(event Event) error
+}
+
+// Filter decides which events reach a sink
+type Filter struct {
+	// Jobs are job names or globs (as understood by path.Match); all jobs are accepted if empty
+	Jobs []string
+	// Transitions are accepted transitions; all transitions are accepted if empty
+	Transitions []Transition
+	// QuietHours is a part of the day when no events are accepted; events are accepted all day if not set
+	QuietHours *QuietHours
+}
+
+func (filter Filter) accepts(event Event) bool {
+	return filter.acceptsJob(event.Job.JobName) &&
+		filter.acceptsTransition(event.Transition) &&
+		(filter.QuietHours == nil || !filter.QuietHours.Contains(event.Time))
+}
+
+func (filter Filter) acceptsJob(jobName string) bool {
+	if len(filter.Jobs) == 0 {
+		return true
+	}
+	for _, job := range filter.Jobs {
+		if matched, err := path.Match(job, jobName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter Filter) acceptsTransition(transition Transition) bool {
+	if len(filter.Transitions) == 0 {
+		return true
+	}
+	for _, accepted := range filter.Transitions {
+		if accepted == transition {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredSink has its own queue of events, so a sink that is slow (e.g. repeating a failed post)
+// doesn't hold back the others
+type filteredSink struct {
+	sink   Sink
+	filter Filter
+	events chan Event
+}
+
+type jobKey struct {
+	server  string
+	jobName string
+}
+
+// Notifier remembers the last known state of each job and sends transitions to the sinks.
+// Each sink is called from its own goroutine, in order of events, so a slow sink doesn't block the view
+// nor the other sinks
+type Notifier struct {
+	sinks      []*filteredSink
+	previous   map[jobKey]model.JobState
+	delivering sync.WaitGroup
+}
+
+// New creates a notifier without sinks
+func New() *Notifier {
+	return &Notifier{
+		previous: make(map[jobKey]model.JobState),
+	}
+}
+
+// AddSink registers a sink for the events accepted by the filter. It should be called before any state is processed
+func (notifier *Notifier) AddSink(sink Sink, filter Filter) {
+	registered := &filteredSink{sink: sink, filter: filter, events: make(chan Event, queueSize)}
+	notifier.sinks = append(notifier.sinks, registered)
+	notifier.delivering.Add(1)
+	go notifier.deliver(registered)
+}
+
+// Close stops the notifier after all waiting events are delivered
+func (notifier *Notifier) Close() {
+	for _, registered := range notifier.sinks {
+		close(registered.events)
+	}
+	notifier.delivering.Wait()
+	for _, registered := range notifier.sinks {
+		if closer, ok := registered.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Could not close notification sink: %v", err)
+			}
+		}
+	}
+}
+
+// Process finds transitions since the previously processed state and queues them for the sinks
+// whose filters accept them
+func (notifier *Notifier) Process(state *model.State) {
+	for _, event := range transitions(notifier.previous, state.JobStates) {
+		log.Printf("Notification: %v", event)
+		for _, registered := range notifier.sinks {
+			if !registered.filter.accepts(event) {
+				continue
+			}
+			select {
+			case registered.events <- event:
+			default:
+				log.Printf("Too many notifications waiting for a sink, dropping: %v", event)
+			}
+		}
+	}
+	for _, jobState := range state.JobStates {
+		if jobState.Error == nil {
+			notifier.previous[jobKey{server: jobState.Server, jobName: jobState.JobName}] = jobState
+		}
+	}
+}
+
+// transitions compares current job states with the previous ones. Jobs seen for the first time
+// and jobs which could not be refreshed don't cause any transitions
+func transitions(previous map[jobKey]model.JobState, current []model.JobState) (events []Event) {
+	now := time.Now()
+	for _, jobState := range current {
+		if jobState.Error != nil {
+			continue
+		}
+		known, ok := previous[jobKey{server: jobState.Server, jobName: jobState.JobName}]
+		if !ok {
+			continue
+		}
+		event := Event{Job: jobState, Previous: known, Time: now}
+		if !known.Building && jobState.Building {
+			event.Transition = Started
+			events = append(events, event)
+		}
+		if known.Building && !jobState.Building {
+			event.Transition = Finished
+			events = append(events, event)
+		}
+		if known.PreviousState == model.Success && jobState.PreviousState == model.Failure {
+			event.Transition = Failed
+			events = append(events, event)
+		}
+		if known.PreviousState == model.Failure && jobState.PreviousState == model.Success {
+			event.Transition = Fixed
+			events = append(events, event)
+		}
+	}
+	return
+}
+
+// deliver passes queued events to the sink until its queue is closed
+func (notifier *Notifier) deliver(registered *filteredSink) {
+	defer notifier.delivering.Done()
+	for event := range registered.events {
+		if err := registered.sink.Notify(event); err != nil {
+			log.Printf("Notification sink failed for %v: %v", event, err)
+		}
+	}
+}
+
+// View wraps a view so that each state presented to it is processed by the notifier first
+func (notifier *Notifier) View(wrapped view.View) view.View {
+	return &notifyingView{View: wrapped, notifier: notifier}
+}
+
+type notifyingView struct {
+	view.View
+	notifier *Notifier
+}
+
+func (notifying *notifyingView) PresentState(state *model.State) {
+	notifying.notifier.Process(state)
+	notifying.View.PresentState(state)
+}
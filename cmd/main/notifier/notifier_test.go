@@ -0,0 +1,190 @@
+package notifier
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestTransitions(t *testing.T) {
+	cases := []struct {
+		name     string
+		previous model.JobState
+		current  model.JobState
+		expected []Transition
+	}{
+		{"nothing changed", model.JobState{PreviousState: model.Success}, model.JobState{PreviousState: model.Success}, nil},
+		{"went red", model.JobState{PreviousState: model.Success}, model.JobState{PreviousState: model.Failure}, []Transition{Failed}},
+		{"went green", model.JobState{PreviousState: model.Failure}, model.JobState{PreviousState: model.Success}, []Transition{Fixed}},
+		{"build started", model.JobState{PreviousState: model.Success}, model.JobState{PreviousState: model.Success, Building: true}, []Transition{Started}},
+		{"build finished red", model.JobState{PreviousState: model.Success, Building: true}, model.JobState{PreviousState: model.Failure}, []Transition{Finished, Failed}},
+		{"unknown is not a failure", model.JobState{PreviousState: model.Success}, model.JobState{PreviousState: model.Unknown}, nil},
+		{"refresh failed", model.JobState{PreviousState: model.Success}, model.JobState{PreviousState: model.Failure, Error: errors.New("timeout")}, nil},
+	}
+	for _, c := range cases {
+		c.previous.Server, c.previous.JobName = "jenkins1", "job1"
+		c.current.Server, c.current.JobName = "jenkins1", "job1"
+		previous := map[jobKey]model.JobState{{server: "jenkins1", jobName: "job1"}: c.previous}
+		var found []Transition
+		for _, event := range transitions(previous, []model.JobState{c.current}) {
+			found = append(found, event.Transition)
+		}
+		if !reflect.DeepEqual(found, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, found)
+		}
+	}
+}
+
+func TestFirstSeenJobHasNoTransitions(t *testing.T) {
+	current := []model.JobState{{Server: "jenkins1", JobName: "job1", PreviousState: model.Failure}}
+	if events := transitions(map[jobKey]model.JobState{}, current); len(events) != 0 {
+		t.Fatalf("job seen for the first time should not cause transitions, got %v", events)
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	cases := []struct {
+		quietHours string
+		moment     string
+		expected   bool
+	}{
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"22:00-07:00", "21:59", false},
+		{"22:00-07:00", "22:00", true},
+		{"22:00-07:00", "23:59", true},
+		{"22:00-07:00", "00:00", true},
+		{"22:00-07:00", "06:59", true},
+		{"22:00-07:00", "07:00", false},
+		{"22:00-07:00", "12:00", false},
+	}
+	for _, c := range cases {
+		quiet, err := ParseQuietHours(c.quietHours)
+		if err != nil {
+			t.Fatalf("could not parse %v: %v", c.quietHours, err)
+		}
+		moment, err := time.Parse("15:04", c.moment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contains := quiet.Contains(moment); contains != c.expected {
+			t.Errorf("%v contains %v: expected %v, got %v", c.quietHours, c.moment, c.expected, contains)
+		}
+	}
+}
+
+func TestInvalidQuietHours(t *testing.T) {
+	for _, value := range []string{"", "22:00", "24:00-07:00", "22:00-07:60", "late-early"} {
+		if _, err := ParseQuietHours(value); err == nil {
+			t.Errorf("%q should not be accepted as quiet hours", value)
+		}
+	}
+}
+
+// recordingSink remembers all events it was notified about
+type recordingSink struct {
+	sync.Mutex
+	events []Event
+}
+
+func (sink *recordingSink) Notify(event Event) error {
+	sink.Lock()
+	defer sink.Unlock()
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+func (sink *recordingSink) jobs() (jobs []string) {
+	sink.Lock()
+	defer sink.Unlock()
+	for _, event := range sink.events {
+		jobs = append(jobs, event.Job.JobName+" "+string(event.Transition))
+	}
+	return
+}
+
+// blockingSink doesn't finish notifying until it is released
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (sink *blockingSink) Notify(event Event) error {
+	<-sink.release
+	return nil
+}
+
+func TestSinksReceiveEventsAcceptedByTheirFilters(t *testing.T) {
+	all := &recordingSink{}
+	deploys := &recordingSink{}
+	failures := &recordingSink{}
+	blocked := &blockingSink{release: make(chan struct{})}
+	notifier := New()
+	notifier.AddSink(blocked, Filter{})
+	notifier.AddSink(all, Filter{})
+	notifier.AddSink(deploys, Filter{Jobs: []string{"deploy-*"}})
+	notifier.AddSink(failures, Filter{Transitions: []Transition{Failed}})
+
+	notifier.Process(&model.State{JobStates: []model.JobState{
+		{Server: "jenkins1", JobName: "build", PreviousState: model.Success},
+		{Server: "jenkins1", JobName: "deploy-prod", PreviousState: model.Failure},
+	}})
+	notifier.Process(&model.State{JobStates: []model.JobState{
+		{Server: "jenkins1", JobName: "build", PreviousState: model.Failure},
+		{Server: "jenkins1", JobName: "deploy-prod", PreviousState: model.Success},
+	}})
+	// sink that is still busy must not hold back the others
+	deadline := time.Now().Add(time.Second)
+	for len(all.jobs()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(blocked.release)
+	notifier.Close()
+
+	expectations := []struct {
+		name     string
+		sink     *recordingSink
+		expected []string
+	}{
+		{"all", all, []string{"build failed", "deploy-prod fixed"}},
+		{"deploys", deploys, []string{"deploy-prod fixed"}},
+		{"failures", failures, []string{"build failed"}},
+	}
+	for _, e := range expectations {
+		if jobs := e.sink.jobs(); !reflect.DeepEqual(jobs, e.expected) {
+			t.Errorf("%v sink: expected %v, got %v", e.name, e.expected, jobs)
+		}
+	}
+}
+
+func TestFilterAccepts(t *testing.T) {
+	night, err := ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	midnight := time.Date(2017, 7, 14, 0, 30, 0, 0, time.UTC)
+	noon := time.Date(2017, 7, 14, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		filter   Filter
+		event    Event
+		expected bool
+	}{
+		{"empty filter", Filter{}, Event{Transition: Failed, Job: model.JobState{JobName: "build"}, Time: midnight}, true},
+		{"matching glob", Filter{Jobs: []string{"deploy-*"}}, Event{Transition: Failed, Job: model.JobState{JobName: "deploy-prod"}, Time: noon}, true},
+		{"other job", Filter{Jobs: []string{"deploy-*"}}, Event{Transition: Failed, Job: model.JobState{JobName: "build"}, Time: noon}, false},
+		{"other transition", Filter{Transitions: []Transition{Failed}}, Event{Transition: Fixed, Job: model.JobState{JobName: "build"}, Time: noon}, false},
+		{"within quiet hours", Filter{QuietHours: night}, Event{Transition: Failed, Job: model.JobState{JobName: "build"}, Time: midnight}, false},
+		{"outside quiet hours", Filter{QuietHours: night}, Event{Transition: Failed, Job: model.JobState{JobName: "build"}, Time: noon}, true},
+	}
+	for _, c := range cases {
+		if accepted := c.filter.accepts(c.event); accepted != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, accepted)
+		}
+	}
+}
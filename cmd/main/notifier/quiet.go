@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuietHours is a part of the day, possibly over midnight, like 22:00-07:00
+type QuietHours struct {
+	// from and to are minutes since midnight
+	from int
+	to   int
+}
+
+// ParseQuietHours parses quiet hours in the form of HH:MM-HH:MM
+func ParseQuietHours(value string) (*QuietHours, error) {
+	var fromHour, fromMinute, toHour, toMinute int
+	if _, err := fmt.Sscanf(value, "%d:%d-%d:%d", &fromHour, &fromMinute, &toHour, &toMinute); err != nil {
+		return nil, fmt.Errorf("Quiet hours %q are not in form of HH:MM-HH:MM: %v", value, err)
+	}
+	for _, hour := range []int{fromHour, toHour} {
+		if hour < 0 || hour > 23 {
+			return nil, fmt.Errorf("Quiet hours %q have an invalid hour %d", value, hour)
+		}
+	}
+	for _, minute := range []int{fromMinute, toMinute} {
+		if minute < 0 || minute > 59 {
+			return nil, fmt.Errorf("Quiet hours %q have an invalid minute %d", value, minute)
+		}
+	}
+	return &QuietHours{from: fromHour*60 + fromMinute, to: toHour*60 + toMinute}, nil
+}
+
+// Contains tells if the moment, in its own time zone, is within quiet hours
+func (quiet *QuietHours) Contains(moment time.Time) bool {
+	minute := moment.Hour()*60 + moment.Minute()
+	if quiet.from <= quiet.to {
+		return minute >= quiet.from && minute < quiet.to
+	}
+	return minute >= quiet.from || minute < quiet.to
+}
@@ -0,0 +1,123 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"text/template"
+	"time"
+)
+
+// BellSink rings the terminal bell
+type BellSink struct {
+	Writer io.Writer
+}
+
+// Notify writes the bell character
+func (sink *BellSink) Notify(event Event) error {
+	_, err := sink.Writer.Write([]byte("\a"))
+	return err
+}
+
+// templateData is what command arguments can refer to, like {{.Job}} or {{.Transition}}
+type templateData struct {
+	Job        string
+	Server     string
+	Group      string
+	Transition Transition
+	Causes     string
+	Culprits   string
+	Time       string
+}
+
+func newTemplateData(event Event) templateData {
+	return templateData{
+		Job:        event.Job.JobName,
+		Server:     event.Job.Server,
+		Group:      event.Job.Group,
+		Transition: event.Transition,
+		Causes:     event.Job.CausesFriendly,
+		Culprits:   event.Job.CulpritsFriendly,
+		Time:       event.Job.Time,
+	}
+}
+
+// CommandSink executes an external command, like notify-send, with arguments being templates
+// (as understood by text/template) filled in with the job state, for example "{{.Job}} {{.Transition}}"
+type CommandSink struct {
+	command   string
+	arguments []*template.Template
+	// Timeout is how long command can run before it gets killed
+	Timeout time.Duration
+}
+
+// DefaultCommandTimeout is how long a notification command can run if nothing else is set
+const DefaultCommandTimeout = 10 * time.Second
+
+// NewCommandSink parses argument templates of the command
+func NewCommandSink(command string, arguments []string) (sink *CommandSink, err error) {
+	sink = &CommandSink{command: command, Timeout: DefaultCommandTimeout}
+	for i, argument := range arguments {
+		parsed, err := template.New(fmt.Sprintf("argument %d", i)).Parse(argument)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse argument %q of notification command %v: %v", argument, command, err)
+		}
+		sink.arguments = append(sink.arguments, parsed)
+	}
+	return
+}
+
+// Notify executes the command and waits for it to finish
+func (sink *CommandSink) Notify(event Event) error {
+	data := newTemplateData(event)
+	arguments := make([]string, len(sink.arguments))
+	for i, argument := range sink.arguments {
+		var buffer bytes.Buffer
+		if err := argument.Execute(&buffer, data); err != nil {
+			return err
+		}
+		arguments[i] = buffer.String()
+	}
+	command := exec.Command(sink.command, arguments...)
+	if err := command.Start(); err != nil {
+		return err
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- command.Wait()
+	}()
+	select {
+	case err := <-finished:
+		return err
+	case <-time.After(sink.Timeout):
+		_ = command.Process.Kill()
+		return fmt.Errorf("command %v did not finish in %v", sink.command, sink.Timeout)
+	}
+}
+
+// LogSink appends a line per event to a file
+type LogSink struct {
+	file *os.File
+}
+
+// NewLogSink opens (or creates) the file for appending
+func NewLogSink(path string) (*LogSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &LogSink{file: file}, nil
+}
+
+// Notify writes the event as a single line
+func (sink *LogSink) Notify(event Event) error {
+	_, err := fmt.Fprintf(sink.file, "%v %v\n", event.Time.Format("2006-01-02 15:04:05"), event)
+	return err
+}
+
+// Close closes the file
+func (sink *LogSink) Close() error {
+	return sink.file.Close()
+}
@@ -0,0 +1,196 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+)
+
+const (
+	// DefaultWebhookBody is a message understood by both Slack and Mattermost incoming webhooks
+	DefaultWebhookBody = `{"text": {{json (printf "%v %v on %v. Culprits: %v. Failed tests: %d. %v" .Job .Transition .Server .Culprits .FailedTests .URL)}}}`
+	// DefaultWebhookRetries is how many times a failed post is repeated
+	DefaultWebhookRetries = 3
+	// DefaultWebhookRetryDelay is the wait before the first repeated post, it doubles with each next one
+	DefaultWebhookRetryDelay = 2 * time.Second
+	// DefaultDedupeWindow is how long the same transition of the same job is not posted again,
+	// when it is not known which build caused it
+	DefaultDedupeWindow = 30 * time.Minute
+
+	lastCompletedBuild = "lastCompletedBuild"
+)
+
+// webhookData is what the body template can refer to: everything command arguments can,
+// together with {{.FailedTests}} count and {{.URL}} of the build
+type webhookData struct {
+	templateData
+	FailedTests int
+	URL         string
+}
+
+// dedupeKey is kept per webhook URL, so that a webhook which failed is not held back by the others
+type dedupeKey struct {
+	url        string
+	server     string
+	jobName    string
+	transition Transition
+}
+
+type sentNotification struct {
+	buildID string
+	time    time.Time
+}
+
+// WebhookSink posts a templated JSON body to chat webhooks (Slack and Mattermost compatible).
+// Jenkins is asked for details of the build, like the number of failed tests
+type WebhookSink struct {
+	urls []string
+	body *template.Template
+	apis func(server string) jenkins.API
+	// Retries is how many times a failed post is repeated
+	Retries int
+	// RetryDelay is the wait before the first repeated post, it doubles with each next one
+	RetryDelay time.Duration
+	// DedupeWindow is how long the same transition of the same job is not posted again when
+	// build id can't be found out. With build id, each build is posted only once
+	DedupeWindow time.Duration
+	client       *http.Client
+	sentLock     sync.Mutex
+	sent         map[dedupeKey]sentNotification
+}
+
+// NewWebhookSink parses the body template; DefaultWebhookBody is used if body is empty.
+// APIs are used to find out build details, they can give back nil if the server is not known
+func NewWebhookSink(urls []string, body string, apis func(server string) jenkins.API) (*WebhookSink, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("At least one webhook URL is needed")
+	}
+	if body == "" {
+		body = DefaultWebhookBody
+	}
+	parsed, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse webhook body %q: %v", body, err)
+	}
+	return &WebhookSink{
+		urls:         urls,
+		body:         parsed,
+		apis:         apis,
+		Retries:      DefaultWebhookRetries,
+		RetryDelay:   DefaultWebhookRetryDelay,
+		DedupeWindow: DefaultDedupeWindow,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		sent:         make(map[dedupeKey]sentNotification),
+	}, nil
+}
+
+// toJSON quotes a value so it can be safely put into a JSON body
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Notify posts the event to all webhooks, skipping those the same build was already posted to
+func (sink *WebhookSink) Notify(event Event) error {
+	data := webhookData{templateData: newTemplateData(event)}
+	buildID := ""
+	if api := sink.apis(event.Job.Server); api != nil {
+		buildID = sink.describeBuild(api, event, &data)
+	}
+	var body bytes.Buffer
+	if err := sink.body.Execute(&body, data); err != nil {
+		return err
+	}
+	var lastErr error
+	for _, url := range sink.urls {
+		key := dedupeKey{url: url, server: event.Job.Server, jobName: event.Job.JobName, transition: event.Transition}
+		if sink.isDuplicate(key, buildID, event.Time) {
+			log.Printf("Not posting %v to webhook %v again for build %q", event, url, buildID)
+			continue
+		}
+		if err := sink.post(url, body.Bytes()); err != nil {
+			log.Printf("Could not post %v to webhook %v: %v", event, url, err)
+			lastErr = err
+			continue
+		}
+		sink.remember(key, buildID, event.Time)
+	}
+	return lastErr
+}
+
+// describeBuild fills in build URL and failed tests count, giving back the build id if it can be found out
+func (sink *WebhookSink) describeBuild(api jenkins.API, event Event, data *webhookData) (buildID string) {
+	jobName := event.Job.JobName
+	if event.Transition == Started {
+		data.URL = api.GetLastBuildURLForJob(jobName)
+		if status, err := api.GetCurrentStatus(jobName); err == nil {
+			buildID = status.ID
+		}
+		return
+	}
+	data.URL = api.GetLastCompletedBuildURLForJob(jobName)
+	if status, err := api.GetStatusForJob(jobName, lastCompletedBuild); err == nil {
+		buildID = status.ID
+	}
+	if event.Transition == Failed || event.Transition == Finished {
+		if testCases, err := api.GetFailedTestList(jobName); err == nil {
+			data.FailedTests = len(testCases)
+		}
+	}
+	return
+}
+
+// isDuplicate tells if the notification was already posted
+func (sink *WebhookSink) isDuplicate(key dedupeKey, buildID string, moment time.Time) bool {
+	sink.sentLock.Lock()
+	defer sink.sentLock.Unlock()
+	previous, ok := sink.sent[key]
+	if !ok {
+		return false
+	}
+	if buildID != "" {
+		return previous.buildID == buildID
+	}
+	return moment.Sub(previous.time) < sink.DedupeWindow
+}
+
+// remember marks the notification as posted, so that it is not posted again
+func (sink *WebhookSink) remember(key dedupeKey, buildID string, moment time.Time) {
+	sink.sentLock.Lock()
+	defer sink.sentLock.Unlock()
+	sink.sent[key] = sentNotification{buildID: buildID, time: moment}
+}
+
+// post sends the body, repeating it with growing delays if webhook is not reachable or fails on its side.
+// Waiting between the attempts only holds back the notifications of this sink
+func (sink *WebhookSink) post(url string, body []byte) (err error) {
+	delay := sink.RetryDelay
+	for attempt := 0; attempt <= sink.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var resp *http.Response
+		resp, err = sink.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("webhook answered with %v", resp.Status)
+		if resp.StatusCode < 500 {
+			// request itself is wrong, repeating it won't help
+			return
+		}
+	}
+	return
+}
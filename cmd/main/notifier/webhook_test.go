@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestWebhookFailedPostIsNotRememberedAsSent(t *testing.T) {
+	var requests int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer webhook.Close()
+
+	sink, err := NewWebhookSink([]string{webhook.URL}, "", func(server string) jenkins.API { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{
+		Job:        model.JobState{Server: "jenkins1", JobName: "job1"},
+		Transition: Failed,
+		Time:       time.Now(),
+	}
+
+	if err := sink.Notify(event); err == nil {
+		t.Fatal("rejected post should be reported")
+	}
+	if err := sink.Notify(event); err != nil {
+		t.Fatalf("post after the failed one should succeed, got %v", err)
+	}
+	if err := sink.Notify(event); err != nil {
+		t.Fatalf("duplicate should not be an error, got %v", err)
+	}
+	if posted := atomic.LoadInt32(&requests); posted != 2 {
+		t.Fatalf("expected the failed post and its repetition only, got %d requests", posted)
+	}
+}
+
+func TestWebhookFailedPostIsRepeatedOnlyToTheFailedURL(t *testing.T) {
+	var healthyRequests, failingRequests int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyRequests, 1)
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failingRequests, 1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer failing.Close()
+
+	sink, err := NewWebhookSink([]string{healthy.URL, failing.URL}, "", func(server string) jenkins.API { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{
+		Job:        model.JobState{Server: "jenkins1", JobName: "job1"},
+		Transition: Failed,
+		Time:       time.Now(),
+	}
+
+	if err := sink.Notify(event); err == nil {
+		t.Fatal("rejected post should be reported")
+	}
+	if err := sink.Notify(event); err != nil {
+		t.Fatalf("post after the failed one should succeed, got %v", err)
+	}
+	if posted := atomic.LoadInt32(&healthyRequests); posted != 1 {
+		t.Fatalf("expected a single post to the healthy webhook, got %d requests", posted)
+	}
+	if posted := atomic.LoadInt32(&failingRequests); posted != 2 {
+		t.Fatalf("expected the failed post and its repetition, got %d requests", posted)
+	}
+}
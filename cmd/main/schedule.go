@@ -0,0 +1,170 @@
+package main
+
+import (
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/main/controller"
+	"github.com/milanaleksic/clici/model"
+)
+
+const (
+	// minimumRefresh is the shortest time between two refreshes of a job, no matter how active it is
+	minimumRefresh = 2 * time.Second
+	// building and queued jobs are refreshed this many times more often than the endpoint interval
+	activeRefreshDivisor = 5
+	// jobs which did not change for this long are considered idle
+	idleAfter = 30 * time.Minute
+	// idle jobs are refreshed this many times less often than the endpoint interval
+	idleRefreshFactor = 2
+	// all configured jobs (including disabled ones and new jobs matching globs) are
+	// refreshed this many times less often than the endpoint interval
+	discoveryRefreshFactor = 4
+	// an endpoint that keeps failing is retried at most this many times less often than its interval
+	maximumBackoff = 16
+)
+
+// jobSchedule remembers when a single job was seen changing and when it is due to be refreshed again
+type jobSchedule struct {
+	next       time.Time
+	lastChange time.Time
+	last       model.JobState
+}
+
+// endpointSchedule decides which jobs of a single endpoint should be refreshed and when.
+// Jobs are refreshed one by one, more often while they build or wait in queue and less often
+// when they are disabled or did not change for a long time. Configured jobs are all refreshed
+// from time to time to find out about new jobs, and always after an error
+type endpointSchedule struct {
+	id            int
+	jobs          []string
+	interval      time.Duration
+	backoff       int
+	nextDiscovery time.Time
+	known         map[string]*jobSchedule
+}
+
+func newEndpointSchedule(id int, jobs []string, interval time.Duration) *endpointSchedule {
+	return &endpointSchedule{
+		id:       id,
+		jobs:     jobs,
+		interval: interval,
+		backoff:  1,
+		known:    make(map[string]*jobSchedule),
+	}
+}
+
+// next gives the moment when something in the endpoint needs to be refreshed
+func (schedule *endpointSchedule) next() time.Time {
+	next := schedule.nextDiscovery
+	if schedule.backoff > 1 {
+		return next
+	}
+	for _, job := range schedule.known {
+		if job.next.Before(next) {
+			next = job.next
+		}
+	}
+	return next
+}
+
+// due gives back jobs which should be refreshed at the given moment, and if it is time
+// to refresh all configured jobs
+func (schedule *endpointSchedule) due(now time.Time) (jobs []string, discovery bool) {
+	if !now.Before(schedule.nextDiscovery) {
+		return schedule.jobs, true
+	}
+	if schedule.backoff > 1 {
+		return nil, false
+	}
+	for name, job := range schedule.known {
+		if !now.Before(job.next) {
+			jobs = append(jobs, name)
+		}
+	}
+	return jobs, false
+}
+
+// refreshed takes into account the outcome of a refresh that has been done at the given moment
+func (schedule *endpointSchedule) refreshed(now time.Time, discovery bool, states []model.JobState, err error) {
+	if err != nil {
+		if schedule.backoff < maximumBackoff {
+			schedule.backoff *= 2
+		}
+		schedule.nextDiscovery = now.Add(schedule.interval * time.Duration(schedule.backoff))
+		return
+	}
+	schedule.backoff = 1
+	if discovery {
+		schedule.nextDiscovery = now.Add(schedule.interval * discoveryRefreshFactor)
+		known := make(map[string]*jobSchedule, len(states))
+		for _, state := range states {
+			if job, ok := schedule.known[state.JobName]; ok {
+				known[state.JobName] = job
+			}
+		}
+		schedule.known = known
+	}
+	for _, state := range states {
+		job, ok := schedule.known[state.JobName]
+		if !ok {
+			job = &jobSchedule{lastChange: now}
+			schedule.known[state.JobName] = job
+		} else if !sameActivity(job.last, state) {
+			job.lastChange = now
+		}
+		job.last = state
+		job.next = now.Add(schedule.intervalFor(job, now))
+	}
+}
+
+func (schedule *endpointSchedule) intervalFor(job *jobSchedule, now time.Time) time.Duration {
+	switch {
+	case job.last.Building || job.last.Queued:
+		if interval := schedule.interval / activeRefreshDivisor; interval > minimumRefresh {
+			return interval
+		}
+		return minimumRefresh
+	case job.last.PreviousState == model.Disabled:
+		return schedule.interval * discoveryRefreshFactor
+	case now.Sub(job.lastChange) >= idleAfter:
+		return schedule.interval * idleRefreshFactor
+	}
+	return schedule.interval
+}
+
+func sameActivity(first, second model.JobState) bool {
+	return first.PreviousState == second.PreviousState &&
+		first.Building == second.Building &&
+		first.Queued == second.Queued &&
+		first.CausesFriendly == second.CausesFriendly
+}
+
+// pollSchedule keeps schedules of all endpoints that are polled directly
+type pollSchedule struct {
+	endpoints []*endpointSchedule
+}
+
+func newPollSchedule(roots []controller.JenkinsAPIRoot, intervalOf func(server string) time.Duration) *pollSchedule {
+	schedule := &pollSchedule{}
+	for id, root := range roots {
+		schedule.endpoints = append(schedule.endpoints, newEndpointSchedule(id, root.Jobs, intervalOf(root.Server)))
+	}
+	return schedule
+}
+
+// next gives the moment when any of the endpoints needs to be refreshed
+func (schedule *pollSchedule) next() (next time.Time) {
+	for i, endpoint := range schedule.endpoints {
+		if endpointNext := endpoint.next(); i == 0 || endpointNext.Before(next) {
+			next = endpointNext
+		}
+	}
+	return
+}
+
+// force makes all configured jobs of all endpoints due immediately
+func (schedule *pollSchedule) force() {
+	for _, endpoint := range schedule.endpoints {
+		endpoint.nextDiscovery = time.Time{}
+	}
+}
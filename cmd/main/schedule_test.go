@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestBackoffGrowsAndResets(t *testing.T) {
+	interval := time.Minute
+	schedule := newEndpointSchedule(0, []string{"job1"}, interval)
+	now := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	if jobs, discovery := schedule.due(now); !discovery || !reflect.DeepEqual(jobs, []string{"job1"}) {
+		t.Fatalf("all configured jobs should be due at the start, got %v (discovery %v)", jobs, discovery)
+	}
+
+	failure := errors.New("connection refused")
+	for _, expected := range []int{2, 4, 8, 16, 16} {
+		schedule.refreshed(now, true, nil, failure)
+		if schedule.backoff != expected {
+			t.Fatalf("expected backoff %d, got %d", expected, schedule.backoff)
+		}
+		if next := schedule.next(); !next.Equal(now.Add(interval * time.Duration(expected))) {
+			t.Fatalf("with backoff %d endpoint should be retried at %v, got %v", expected, now.Add(interval*time.Duration(expected)), next)
+		}
+	}
+
+	schedule.refreshed(now, true, []model.JobState{{JobName: "job1"}}, nil)
+	if schedule.backoff != 1 {
+		t.Fatalf("backoff should be reset after a successful refresh, got %d", schedule.backoff)
+	}
+	if next := schedule.next(); !next.Equal(now.Add(interval)) {
+		t.Fatalf("job should be refreshed after the usual interval, got %v", next)
+	}
+}
+
+func TestJobsAreNotRefreshedWhileBackingOff(t *testing.T) {
+	schedule := newEndpointSchedule(0, []string{"job*"}, time.Minute)
+	now := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	schedule.refreshed(now, true, []model.JobState{{JobName: "job1"}}, nil)
+	later := now.Add(time.Minute)
+	schedule.refreshed(later, false, nil, errors.New("timeout"))
+	if jobs, discovery := schedule.due(later.Add(time.Minute)); len(jobs) != 0 || discovery {
+		t.Fatalf("nothing should be due while backing off, got %v (discovery %v)", jobs, discovery)
+	}
+	if jobs, discovery := schedule.due(later.Add(2 * time.Minute)); !discovery || !reflect.DeepEqual(jobs, []string{"job*"}) {
+		t.Fatalf("all configured jobs should be due after backing off, got %v (discovery %v)", jobs, discovery)
+	}
+}
+
+func TestNextPollOfEachJob(t *testing.T) {
+	interval := time.Minute
+	now := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name      string
+		state     model.JobState
+		unchanged time.Duration
+		expected  time.Duration
+	}{
+		{"regular", model.JobState{PreviousState: model.Success}, 0, interval},
+		{"building", model.JobState{PreviousState: model.Success, Building: true}, 0, interval / activeRefreshDivisor},
+		{"queued", model.JobState{PreviousState: model.Failure, Queued: true}, time.Hour, interval / activeRefreshDivisor},
+		{"disabled", model.JobState{PreviousState: model.Disabled}, 0, interval * discoveryRefreshFactor},
+		{"idle", model.JobState{PreviousState: model.Success}, idleAfter, interval * idleRefreshFactor},
+		{"almost idle", model.JobState{PreviousState: model.Success}, idleAfter - time.Second, interval},
+	}
+	for _, c := range cases {
+		c.state.JobName = "job1"
+		schedule := newEndpointSchedule(0, []string{"job1"}, interval)
+		schedule.refreshed(now, true, []model.JobState{c.state}, nil)
+		refreshedAt := now.Add(c.unchanged)
+		schedule.refreshed(refreshedAt, false, []model.JobState{c.state}, nil)
+		if next := schedule.known["job1"].next; !next.Equal(refreshedAt.Add(c.expected)) {
+			t.Errorf("%v: expected next poll in %v, got %v", c.name, c.expected, next.Sub(refreshedAt))
+		}
+	}
+}
+
+func TestActiveJobsAreNotPolledMoreOftenThanMinimum(t *testing.T) {
+	schedule := newEndpointSchedule(0, []string{"job1"}, 3*time.Second)
+	now := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	schedule.refreshed(now, true, []model.JobState{{JobName: "job1", Building: true}}, nil)
+	if next := schedule.known["job1"].next; !next.Equal(now.Add(minimumRefresh)) {
+		t.Fatalf("expected next poll in %v, got %v", minimumRefresh, next.Sub(now))
+	}
+}
+
+func TestOnlyDueJobsAreRefreshed(t *testing.T) {
+	interval := time.Minute
+	schedule := newEndpointSchedule(0, []string{"*"}, interval)
+	now := time.Date(2017, 7, 14, 10, 0, 0, 0, time.UTC)
+	schedule.refreshed(now, true, []model.JobState{
+		{JobName: "building", Building: true},
+		{JobName: "regular", PreviousState: model.Success},
+		{JobName: "disabled", PreviousState: model.Disabled},
+	}, nil)
+
+	if next := schedule.next(); !next.Equal(now.Add(interval / activeRefreshDivisor)) {
+		t.Fatalf("endpoint should be due when the building job is, got %v", next)
+	}
+	jobs, discovery := schedule.due(now.Add(interval))
+	sort.Strings(jobs)
+	if discovery || !reflect.DeepEqual(jobs, []string{"building", "regular"}) {
+		t.Fatalf("only building and regular jobs should be due after an interval, got %v (discovery %v)", jobs, discovery)
+	}
+	if _, discovery := schedule.due(now.Add(interval * discoveryRefreshFactor)); !discovery {
+		t.Fatal("all configured jobs should be refreshed after the discovery interval")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/main/history"
+)
+
+const commandStats = "stats"
+
+// historyPath gives the location of the build history, relative paths being relative to the executable
+func historyPath() string {
+	if options.History.Path == "" || filepath.IsAbs(options.History.Path) {
+		return options.History.Path
+	}
+	return filepath.Join(filepath.Dir(os.Args[0]), options.History.Path)
+}
+
+func getHistory() *history.Store {
+	if historyPath() == "" {
+		return nil
+	}
+	store, err := history.Open(historyPath())
+	if err != nil {
+		log.Fatalf("Could not open build history %v: %v", historyPath(), err)
+	}
+	return store
+}
+
+// runStats reports statistics of recorded builds
+func runStats(arguments []string) {
+	flags := flag.NewFlagSet(commandStats, flag.ExitOnError)
+	window := flags.String("window", "30d", "Only builds started within this window are reported, like 72h or 14d")
+	jobGlob := flags.String("job", "", "Only jobs with matching name or glob are reported")
+	_ = flags.Parse(arguments)
+
+	windowDuration, err := parseWindow(*window)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid window %q: %v\n", *window, err)
+		os.Exit(2)
+	}
+	if historyPath() == "" {
+		fmt.Fprintln(os.Stderr, "Build history is not recorded, please set path in [history] section of the configuration")
+		os.Exit(1)
+	}
+	builds, err := history.Load(historyPath(), time.Now().Add(-windowDuration))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read build history %v: %v\n", historyPath(), err)
+		os.Exit(1)
+	}
+	if *jobGlob != "" {
+		matching := builds[:0]
+		for _, build := range builds {
+			if matched, err := path.Match(*jobGlob, build.Job); err == nil && matched {
+				matching = append(matching, build)
+			}
+		}
+		builds = matching
+	}
+	if len(builds) == 0 {
+		fmt.Printf("No builds recorded in the last %v\n", *window)
+		return
+	}
+	if err := history.Report(os.Stdout, history.Compute(builds)); err != nil {
+		log.Fatalf("Could not write the report: %v", err)
+	}
+}
+
+// parseWindow understands days ("14d") besides everything time.ParseDuration does
+func parseWindow(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
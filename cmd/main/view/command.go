@@ -22,6 +22,12 @@ const (
 	CmdTestsForJobGroup = "openTests"
 	// CmdRunJob runs a job with a certain ID
 	CmdRunJob = "runJob"
+	// CmdShowDetailsGroup declares a command group to open the dialog with details of the last build of a job behind a certain id
+	CmdShowDetailsGroup = "showDetails"
+	// CmdRefreshGroup declares a command group to refresh all jobs immediately. Takes no job parameter
+	CmdRefreshGroup = "refresh"
+	// CmdCancelRefreshGroup declares a command group to cancel the refresh in progress. Takes no job parameter
+	CmdCancelRefreshGroup = "cancelRefresh"
 )
 
 // CreateCmdShutdownGroup creates a new command of group CmdShutdownGroup
@@ -57,4 +63,19 @@ func CreateCmdTestsForJobGroup() Command {
 // CreateCmdRunJob creates a new command of group CmdRunJob
 func CreateCmdRunJob() Command {
 	return Command{Group: CmdRunJob}
-}
\ No newline at end of file
+}
+
+// CreateCmdShowDetailsGroup creates a new command of group CmdShowDetailsGroup
+func CreateCmdShowDetailsGroup() Command {
+	return Command{Group: CmdShowDetailsGroup}
+}
+
+// CreateCmdRefreshGroup creates a new command of group CmdRefreshGroup
+func CreateCmdRefreshGroup() Command {
+	return Command{Group: CmdRefreshGroup}
+}
+
+// CreateCmdCancelRefreshGroup creates a new command of group CmdCancelRefreshGroup
+func CreateCmdCancelRefreshGroup() Command {
+	return Command{Group: CmdCancelRefreshGroup}
+}
@@ -0,0 +1,85 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+// Me lists names of the user as Jenkins knows them, jobs started or broken by any of them are "my" jobs
+var Me []string
+
+// Filter narrows down jobs shown by a view. Text is matched (ignoring case) against job name, group,
+// server and culprits, toggles additionally keep only failing, building (or queued) or "my" jobs
+type Filter struct {
+	Text         string
+	OnlyFailing  bool
+	OnlyBuilding bool
+	OnlyMine     bool
+}
+
+// IsEmpty tells if the filter lets all jobs through
+func (filter Filter) IsEmpty() bool {
+	return filter == Filter{}
+}
+
+// Matches tells if the job should be shown
+func (filter Filter) Matches(jobState *model.JobState) bool {
+	if filter.OnlyFailing && jobState.PreviousState != model.Failure {
+		return false
+	}
+	if filter.OnlyBuilding && !jobState.Building && !jobState.Queued {
+		return false
+	}
+	if filter.OnlyMine && !isMine(jobState) {
+		return false
+	}
+	if filter.Text == "" {
+		return true
+	}
+	text := strings.ToLower(filter.Text)
+	for _, field := range []string{jobState.JobName, jobState.Group, jobState.Server, jobState.CulpritsFriendly} {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply gives back indexes of the jobs in the state which should be shown
+func (filter Filter) Apply(state *model.State) (visible []int) {
+	for i := range state.JobStates {
+		if filter.Matches(&state.JobStates[i]) {
+			visible = append(visible, i)
+		}
+	}
+	return
+}
+
+func (filter Filter) String() string {
+	var parts []string
+	if filter.Text != "" {
+		parts = append(parts, fmt.Sprintf("/%v", filter.Text))
+	}
+	if filter.OnlyFailing {
+		parts = append(parts, "[failing]")
+	}
+	if filter.OnlyBuilding {
+		parts = append(parts, "[building]")
+	}
+	if filter.OnlyMine {
+		parts = append(parts, "[mine]")
+	}
+	return strings.Join(parts, " ")
+}
+
+func isMine(jobState *model.JobState) bool {
+	people := strings.ToLower(jobState.CausesFriendly + "," + jobState.CulpritsFriendly)
+	for _, name := range Me {
+		if name != "" && strings.Contains(people, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
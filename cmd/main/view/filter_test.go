@@ -0,0 +1,92 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestFilterMatches(t *testing.T) {
+	Me = []string{"Milan"}
+	defer func() {
+		Me = nil
+	}()
+	job := model.JobState{
+		Group:            "Deploy",
+		JobName:          "deploy-production",
+		Server:           "http://jenkins1:8080/",
+		CulpritsFriendly: "jane, john",
+		CausesFriendly:   "Started by user milan",
+		PreviousState:    model.Failure,
+	}
+	cases := []struct {
+		name     string
+		filter   Filter
+		job      model.JobState
+		expected bool
+	}{
+		{"empty filter", Filter{}, job, true},
+		{"job name", Filter{Text: "prod"}, job, true},
+		{"ignoring case", Filter{Text: "PRODUCTION"}, job, true},
+		{"group", Filter{Text: "deploy"}, model.JobState{Group: "Deploy", JobName: "release"}, true},
+		{"server", Filter{Text: "jenkins1"}, job, true},
+		{"culprit", Filter{Text: "jane"}, job, true},
+		{"causes are not matched", Filter{Text: "started by"}, job, false},
+		{"no match", Filter{Text: "staging"}, job, false},
+		{"failing", Filter{OnlyFailing: true}, job, true},
+		{"not failing", Filter{OnlyFailing: true}, model.JobState{PreviousState: model.Success}, false},
+		{"building", Filter{OnlyBuilding: true}, model.JobState{Building: true}, true},
+		{"queued", Filter{OnlyBuilding: true}, model.JobState{Queued: true}, true},
+		{"not building", Filter{OnlyBuilding: true}, job, false},
+		{"started by me", Filter{OnlyMine: true}, job, true},
+		{"broken by me", Filter{OnlyMine: true}, model.JobState{CulpritsFriendly: "MILAN"}, true},
+		{"not mine", Filter{OnlyMine: true}, model.JobState{CulpritsFriendly: "jane"}, false},
+		{"text and toggle", Filter{Text: "prod", OnlyBuilding: true}, job, false},
+	}
+	for _, c := range cases {
+		if matches := c.filter.Matches(&c.job); matches != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, matches)
+		}
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	state := &model.State{JobStates: []model.JobState{
+		{JobName: "build", PreviousState: model.Failure},
+		{JobName: "test", PreviousState: model.Success},
+		{JobName: "deploy", PreviousState: model.Failure},
+	}}
+	if visible := (Filter{OnlyFailing: true}).Apply(state); !reflect.DeepEqual(visible, []int{0, 2}) {
+		t.Fatalf("expected failing jobs 0 and 2, got %v", visible)
+	}
+	if visible := (Filter{Text: "none"}).Apply(state); len(visible) != 0 {
+		t.Fatalf("expected no visible jobs, got %v", visible)
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	cases := []struct {
+		filter   Filter
+		expected string
+	}{
+		{Filter{}, ""},
+		{Filter{Text: "deploy"}, "/deploy"},
+		{Filter{Text: "deploy", OnlyFailing: true, OnlyBuilding: true, OnlyMine: true}, "/deploy [failing] [building] [mine]"},
+	}
+	for _, c := range cases {
+		if description := c.filter.String(); description != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, description)
+		}
+		if c.filter.IsEmpty() != (c.expected == "") {
+			t.Errorf("%q: filter should be empty only if it describes nothing", c.expected)
+		}
+	}
+}
+
+func TestNobodyIsMeByDefault(t *testing.T) {
+	job := model.JobState{CausesFriendly: "Started by user", CulpritsFriendly: ""}
+	if (Filter{OnlyMine: true}).Matches(&job) {
+		t.Fatal("without names configured no job should be mine")
+	}
+}
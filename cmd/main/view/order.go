@@ -0,0 +1,258 @@
+package view
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+// SortMode decides order of jobs inside a group
+type SortMode string
+
+// GroupMode decides how jobs are put into groups
+type GroupMode string
+
+const (
+	// SortBySeverity shows failing jobs first, then unstable or unknown, building, successful and at the end disabled ones
+	SortBySeverity SortMode = "severity"
+	// SortByLastChange shows jobs with the most recent build first
+	SortByLastChange SortMode = "changed"
+	// SortByName shows jobs in alphabetical order
+	SortByName SortMode = "name"
+	// SortByDuration shows jobs with the longest build first
+	SortByDuration SortMode = "duration"
+	// SortAsConfigured keeps jobs in order in which configuration and Jenkins give them
+	SortAsConfigured SortMode = "configured"
+
+	// GroupByConfig uses groups from the configuration
+	GroupByConfig GroupMode = "group"
+	// GroupByServer puts jobs of the same Jenkins server together
+	GroupByServer GroupMode = "server"
+	// GroupByFolder puts jobs of the same Jenkins folder together
+	GroupByFolder GroupMode = "folder"
+	// GroupByStatus puts jobs with the same status together
+	GroupByStatus GroupMode = "status"
+)
+
+// KnownSortModes lists all sort modes, in order in which they are switched at runtime
+var KnownSortModes = []SortMode{SortAsConfigured, SortBySeverity, SortByLastChange, SortByName, SortByDuration}
+
+// KnownGroupModes lists all group modes, in order in which they are switched at runtime
+var KnownGroupModes = []GroupMode{GroupByConfig, GroupByServer, GroupByFolder, GroupByStatus}
+
+// DefaultOrder is the order views start with
+var DefaultOrder = Order{Sort: SortAsConfigured, Group: GroupByConfig}
+
+// ParseSortMode gives the sort mode with the given name, as used in configuration
+func ParseSortMode(name string) (SortMode, error) {
+	for _, mode := range KnownSortModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown sort mode %q, expected one of %v", name, KnownSortModes)
+}
+
+// ParseGroupMode gives the group mode with the given name, as used in configuration
+func ParseGroupMode(name string) (GroupMode, error) {
+	for _, mode := range KnownGroupModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown group mode %q, expected one of %v", name, KnownGroupModes)
+}
+
+// Order decides in which order and in which groups jobs are shown. Groups keep the order in which
+// they first appear, except that groups with broken jobs come first when BrokenFirst is set.
+// BrokenFirst also puts broken jobs at the top of their group, whatever the sort mode is
+type Order struct {
+	Sort        SortMode
+	Group       GroupMode
+	BrokenFirst bool
+}
+
+// NextSort gives the order with the sort mode that follows the current one
+func (order Order) NextSort() Order {
+	order.Sort = KnownSortModes[(indexOfSortMode(order.Sort)+1)%len(KnownSortModes)]
+	return order
+}
+
+// NextGroup gives the order with the group mode that follows the current one
+func (order Order) NextGroup() Order {
+	order.Group = KnownGroupModes[(indexOfGroupMode(order.Group)+1)%len(KnownGroupModes)]
+	return order
+}
+
+// GroupOf gives the name of the group the job belongs to
+func (order Order) GroupOf(jobState *model.JobState) string {
+	switch order.Group {
+	case GroupByServer:
+		return serverOf(jobState)
+	case GroupByFolder:
+		return folderOf(jobState)
+	case GroupByStatus:
+		return statusName(jobState)
+	}
+	return jobState.Group
+}
+
+// Apply sorts indexes of the jobs in the state
+func (order Order) Apply(state *model.State, jobs []int) []int {
+	sorted := byOrder{
+		jobs:      make([]int, len(jobs)),
+		state:     state,
+		order:     order,
+		groupRank: make(map[string]int),
+	}
+	copy(sorted.jobs, jobs)
+	for _, job := range sorted.jobs {
+		group := order.GroupOf(&state.JobStates[job])
+		if _, ok := sorted.groupRank[group]; !ok {
+			sorted.groupRank[group] = len(sorted.groupRank)
+		}
+	}
+	if order.BrokenFirst {
+		lifted := make(map[string]bool)
+		for _, job := range sorted.jobs {
+			group := order.GroupOf(&state.JobStates[job])
+			if isBroken(&state.JobStates[job]) && !lifted[group] {
+				lifted[group] = true
+				sorted.groupRank[group] -= len(sorted.groupRank)
+			}
+		}
+	}
+	sort.Stable(sorted)
+	return sorted.jobs
+}
+
+type byOrder struct {
+	jobs      []int
+	state     *model.State
+	order     Order
+	groupRank map[string]int
+}
+
+func (a byOrder) Len() int      { return len(a.jobs) }
+func (a byOrder) Swap(i, j int) { a.jobs[i], a.jobs[j] = a.jobs[j], a.jobs[i] }
+func (a byOrder) Less(i, j int) bool {
+	first, second := &a.state.JobStates[a.jobs[i]], &a.state.JobStates[a.jobs[j]]
+	if firstRank, secondRank := a.groupRank[a.order.GroupOf(first)], a.groupRank[a.order.GroupOf(second)]; firstRank != secondRank {
+		return firstRank < secondRank
+	}
+	if a.order.BrokenFirst && isBroken(first) != isBroken(second) {
+		return isBroken(first)
+	}
+	return a.order.less(first, second)
+}
+
+func (order Order) less(first, second *model.JobState) bool {
+	switch order.Sort {
+	case SortBySeverity:
+		if severity(first) != severity(second) {
+			return severity(first) < severity(second)
+		}
+	case SortByLastChange:
+		if !first.Started.Equal(second.Started) {
+			return first.Started.After(second.Started)
+		}
+	case SortByDuration:
+		if first.Duration != second.Duration {
+			return first.Duration > second.Duration
+		}
+	case SortByName:
+	default:
+		return false
+	}
+	return first.JobName < second.JobName
+}
+
+func (order Order) String() string {
+	description := fmt.Sprintf("sorted by %v, grouped by %v", order.Sort, order.Group)
+	if order.BrokenFirst {
+		description += ", broken first"
+	}
+	return description
+}
+
+func indexOfSortMode(mode SortMode) int {
+	for i, known := range KnownSortModes {
+		if known == mode {
+			return i
+		}
+	}
+	return 0
+}
+
+func indexOfGroupMode(mode GroupMode) int {
+	for i, known := range KnownGroupModes {
+		if known == mode {
+			return i
+		}
+	}
+	return 0
+}
+
+func isBroken(jobState *model.JobState) bool {
+	return jobState.PreviousState == model.Failure || jobState.Error != nil
+}
+
+func severity(jobState *model.JobState) int {
+	switch {
+	case isBroken(jobState):
+		return 0
+	case jobState.PreviousState == model.Undefined || jobState.PreviousState == model.Unknown:
+		return 1
+	case jobState.Building || jobState.Queued:
+		return 2
+	case jobState.PreviousState == model.Success:
+		return 3
+	}
+	return 4
+}
+
+func statusName(jobState *model.JobState) string {
+	switch severity(jobState) {
+	case 0:
+		return "broken"
+	case 1:
+		return "unstable or unknown"
+	case 2:
+		return "building"
+	case 3:
+		return "successful"
+	}
+	return "disabled"
+}
+
+func serverOf(jobState *model.JobState) string {
+	if location, err := url.Parse(jobState.Server); err == nil && location.Host != "" {
+		return location.Host
+	}
+	return jobState.Server
+}
+
+// folderOf finds Jenkins folder of the job, either from the server location (which can point to a folder)
+// or from the job name itself
+func folderOf(jobState *model.JobState) string {
+	var folders []string
+	if location, err := url.Parse(jobState.Server); err == nil {
+		segments := strings.Split(strings.Trim(location.Path, "/"), "/")
+		for i := 0; i+1 < len(segments); i++ {
+			if segments[i] == "job" {
+				folders = append(folders, segments[i+1])
+				i++
+			}
+		}
+	}
+	if slash := strings.LastIndex(jobState.JobName, "/"); slash != -1 {
+		folders = append(folders, jobState.JobName[:slash])
+	}
+	if len(folders) == 0 {
+		return "/"
+	}
+	return strings.Join(folders, "/")
+}
@@ -0,0 +1,129 @@
+package view
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+// namesInOrder applies the order to all jobs of the state and gives back their names
+func namesInOrder(order Order, state *model.State) (names []string) {
+	var jobs []int
+	for i := range state.JobStates {
+		jobs = append(jobs, i)
+	}
+	for _, job := range order.Apply(state, jobs) {
+		names = append(names, state.JobStates[job].JobName)
+	}
+	return
+}
+
+func TestOrderApply(t *testing.T) {
+	state := &model.State{JobStates: []model.JobState{
+		{Group: "build", JobName: "compile", PreviousState: model.Success},
+		{Group: "build", JobName: "assemble", PreviousState: model.Failure},
+		{Group: "test", JobName: "unit", PreviousState: model.Success},
+		{Group: "deploy", JobName: "staging", PreviousState: model.Success},
+		{Group: "deploy", JobName: "production", PreviousState: model.Success, Error: errors.New("timeout")},
+		{Group: "deploy", JobName: "canary", PreviousState: model.Disabled},
+	}}
+	cases := []struct {
+		name     string
+		order    Order
+		expected []string
+	}{
+		{
+			name:     "as configured",
+			order:    Order{Sort: SortAsConfigured, Group: GroupByConfig},
+			expected: []string{"compile", "assemble", "unit", "staging", "production", "canary"},
+		},
+		{
+			name:     "by name",
+			order:    Order{Sort: SortByName, Group: GroupByConfig},
+			expected: []string{"assemble", "compile", "unit", "canary", "production", "staging"},
+		},
+		{
+			name:     "by severity",
+			order:    Order{Sort: SortBySeverity, Group: GroupByConfig},
+			expected: []string{"assemble", "compile", "unit", "production", "staging", "canary"},
+		},
+		{
+			// groups with broken jobs keep their relative order, broken jobs lead each of them
+			name:     "broken first",
+			order:    Order{Sort: SortAsConfigured, Group: GroupByConfig, BrokenFirst: true},
+			expected: []string{"assemble", "compile", "production", "staging", "canary", "unit"},
+		},
+		{
+			name:     "broken first by name",
+			order:    Order{Sort: SortByName, Group: GroupByConfig, BrokenFirst: true},
+			expected: []string{"assemble", "compile", "production", "canary", "staging", "unit"},
+		},
+		{
+			name:     "broken first by status",
+			order:    Order{Sort: SortAsConfigured, Group: GroupByStatus, BrokenFirst: true},
+			expected: []string{"assemble", "production", "compile", "unit", "staging", "canary"},
+		},
+	}
+	for _, c := range cases {
+		if names := namesInOrder(c.order, state); !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, names)
+		}
+	}
+}
+
+func TestOrderGroupOf(t *testing.T) {
+	cases := []struct {
+		group    GroupMode
+		job      model.JobState
+		expected string
+	}{
+		{GroupByConfig, model.JobState{Group: "deploy", Server: "http://jenkins1:8080/"}, "deploy"},
+		{GroupByServer, model.JobState{Server: "http://jenkins1:8080/"}, "jenkins1:8080"},
+		{GroupByServer, model.JobState{Server: "jenkins1"}, "jenkins1"},
+		{GroupByFolder, model.JobState{Server: "http://jenkins1/job/team/job/backend/", JobName: "build"}, "team/backend"},
+		{GroupByFolder, model.JobState{Server: "http://jenkins1/", JobName: "team/build"}, "team"},
+		{GroupByFolder, model.JobState{Server: "http://jenkins1/", JobName: "build"}, "/"},
+		{GroupByStatus, model.JobState{PreviousState: model.Success, Queued: true}, "building"},
+		{GroupByStatus, model.JobState{PreviousState: model.Disabled}, "disabled"},
+	}
+	for _, c := range cases {
+		order := Order{Sort: SortAsConfigured, Group: c.group}
+		if group := order.GroupOf(&c.job); group != c.expected {
+			t.Errorf("%v of %+v: expected %q, got %q", c.group, c.job, c.expected, group)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	for _, mode := range KnownSortModes {
+		if parsed, err := ParseSortMode(string(mode)); err != nil || parsed != mode {
+			t.Errorf("expected sort mode %v, got %v (%v)", mode, parsed, err)
+		}
+	}
+	for _, mode := range KnownGroupModes {
+		if parsed, err := ParseGroupMode(string(mode)); err != nil || parsed != mode {
+			t.Errorf("expected group mode %v, got %v (%v)", mode, parsed, err)
+		}
+	}
+	if _, err := ParseSortMode("random"); err == nil {
+		t.Error("unknown sort mode should not be accepted")
+	}
+	if _, err := ParseGroupMode(""); err == nil {
+		t.Error("unknown group mode should not be accepted")
+	}
+}
+
+func TestNextModesWrapAround(t *testing.T) {
+	order := DefaultOrder
+	for range KnownSortModes {
+		order = order.NextSort()
+	}
+	for range KnownGroupModes {
+		order = order.NextGroup()
+	}
+	if order != DefaultOrder {
+		t.Fatalf("expected to get back to %v, got %v", DefaultOrder, order)
+	}
+}
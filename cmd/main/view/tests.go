@@ -0,0 +1,210 @@
+package view
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+// testGrouping decides how failed tests are put into groups
+type testGrouping string
+
+const (
+	testsUngrouped testGrouping = "nothing"
+	testsByClass   testGrouping = "class"
+	testsByPackage testGrouping = "package"
+)
+
+// knownTestGroupings lists all test groupings, in order in which they are switched at runtime
+var knownTestGroupings = []testGrouping{testsUngrouped, testsByClass, testsByPackage}
+
+// next gives the test grouping that follows the current one
+func (grouping testGrouping) next() testGrouping {
+	for i, known := range knownTestGroupings {
+		if known == grouping {
+			return knownTestGroupings[(i+1)%len(knownTestGroupings)]
+		}
+	}
+	return knownTestGroupings[0]
+}
+
+// groupOf gives the name of the group the test belongs to, or empty string if tests are not grouped
+func (grouping testGrouping) groupOf(test *model.TestCase) string {
+	switch grouping {
+	case testsByClass:
+		return test.ClassName
+	case testsByPackage:
+		if dot := strings.LastIndex(test.ClassName, "."); dot != -1 {
+			return test.ClassName[:dot]
+		}
+		return "(default package)"
+	}
+	return ""
+}
+
+// nameOf gives the name of the test, without the parts that are already shown by its group
+func (grouping testGrouping) nameOf(test *model.TestCase) string {
+	switch grouping {
+	case testsByClass:
+		return test.Name
+	case testsByPackage:
+		return test.ClassName[strings.LastIndex(test.ClassName, ".")+1:] + "." + test.Name
+	}
+	return test.ClassName + "." + test.Name
+}
+
+// failureAge tells if the test started failing in this build, and if so whether it passed before,
+// or for how many builds it has been failing
+func failureAge(test *model.TestCase) string {
+	switch {
+	case test.Status == "REGRESSION":
+		return "regressed"
+	case test.Age <= 0:
+		return strings.ToLower(test.Status)
+	case test.Age == 1:
+		return "new"
+	}
+	return fmt.Sprintf("failing for %d builds since #%d", test.Age, test.FailedSince)
+}
+
+// isNewFailure tells if the test started failing in this build
+func isNewFailure(test *model.TestCase) bool {
+	return test.Age == 1 || test.Status == "REGRESSION"
+}
+
+// testBrowser is the state of the failed tests dialog: which tests are shown, which one of them
+// is selected and if its stack trace is shown instead of the list
+type testBrowser struct {
+	filter   string
+	grouping testGrouping
+	selected int
+	// origin is the first line of the list that is visible, pageSize how many lines are visible
+	origin   int
+	pageSize int
+	// showTrace tells if the stack trace of the selected test is shown instead of the list,
+	// traceOrigin is its first line that is visible
+	showTrace   bool
+	traceOrigin int
+}
+
+// matches tells if the test should be shown, filter is matched (ignoring case) against class name,
+// test name, status and age of the failure
+func (browser *testBrowser) matches(test *model.TestCase) bool {
+	if browser.filter == "" {
+		return true
+	}
+	text := strings.ToLower(browser.filter)
+	for _, field := range []string{test.ClassName, test.Name, test.Status, failureAge(test)} {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply gives back indexes of the tests which should be shown, with tests of the same group together.
+// Groups keep the order in which they first appear
+func (browser *testBrowser) apply(tests []model.TestCase) []int {
+	sorted := byTestGroup{
+		tests:     tests,
+		grouping:  browser.grouping,
+		groupRank: make(map[string]int),
+	}
+	for i := range tests {
+		if !browser.matches(&tests[i]) {
+			continue
+		}
+		sorted.visible = append(sorted.visible, i)
+		group := browser.grouping.groupOf(&tests[i])
+		if _, ok := sorted.groupRank[group]; !ok {
+			sorted.groupRank[group] = len(sorted.groupRank)
+		}
+	}
+	sort.Stable(sorted)
+	return sorted.visible
+}
+
+// move moves selection by the given number of tests (wrapping around), or scrolls the stack trace
+// by the given number of lines if it is shown
+func (browser *testBrowser) move(delta int, count int) {
+	if browser.showTrace {
+		browser.scrollTrace(delta)
+		return
+	}
+	if count == 0 {
+		return
+	}
+	browser.selected = (browser.selected + delta + count) % count
+}
+
+// page moves selection by the given number of pages, stopping at the first and the last test,
+// or scrolls the stack trace by the given number of pages if it is shown
+func (browser *testBrowser) page(pages int, count int) {
+	pageSize := browser.pageSize
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	if browser.showTrace {
+		switch {
+		case pages == maxInt:
+			browser.traceOrigin = maxInt
+		case pages == -maxInt:
+			browser.traceOrigin = 0
+		default:
+			browser.scrollTrace(pages * pageSize)
+		}
+		return
+	}
+	if count == 0 {
+		return
+	}
+	switch {
+	case pages >= count:
+		browser.selected = count - 1
+	case pages <= -count:
+		browser.selected = 0
+	default:
+		browser.selected += pages * pageSize
+	}
+	if browser.selected >= count {
+		browser.selected = count - 1
+	}
+	if browser.selected < 0 {
+		browser.selected = 0
+	}
+}
+
+// scrollTrace scrolls the stack trace by the given number of lines, the end is checked when it is drawn
+func (browser *testBrowser) scrollTrace(lines int) {
+	if lines > 0 && browser.traceOrigin > maxInt-lines {
+		browser.traceOrigin = maxInt
+		return
+	}
+	browser.traceOrigin += lines
+	if browser.traceOrigin < 0 {
+		browser.traceOrigin = 0
+	}
+}
+
+// changeFilter applies the change to the filter and shows the tests from the beginning
+func (browser *testBrowser) changeFilter(change func(text string) string) {
+	browser.filter = change(browser.filter)
+	browser.selected = 0
+	browser.origin = 0
+	browser.showTrace = false
+}
+
+type byTestGroup struct {
+	tests     []model.TestCase
+	visible   []int
+	grouping  testGrouping
+	groupRank map[string]int
+}
+
+func (a byTestGroup) Len() int      { return len(a.visible) }
+func (a byTestGroup) Swap(i, j int) { a.visible[i], a.visible[j] = a.visible[j], a.visible[i] }
+func (a byTestGroup) Less(i, j int) bool {
+	return a.groupRank[a.grouping.groupOf(&a.tests[a.visible[i]])] < a.groupRank[a.grouping.groupOf(&a.tests[a.visible[j]])]
+}
@@ -0,0 +1,159 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestTestBrowserPage(t *testing.T) {
+	cases := []struct {
+		name     string
+		selected int
+		pages    int
+		expected int
+	}{
+		{"next page", 0, 1, 5},
+		{"previous page", 7, -1, 2},
+		{"stops at the last test", 10, 1, 11},
+		{"stops at the first test", 3, -1, 0},
+		{"last test", 4, maxInt, 11},
+		{"first test", 4, -maxInt, 0},
+	}
+	for _, c := range cases {
+		browser := testBrowser{selected: c.selected, pageSize: 5}
+		browser.page(c.pages, 12)
+		if browser.selected != c.expected {
+			t.Errorf("%v: expected test %d to be selected, got %d", c.name, c.expected, browser.selected)
+		}
+	}
+}
+
+func TestTestBrowserPageWithoutTests(t *testing.T) {
+	browser := testBrowser{pageSize: 5}
+	browser.page(1, 0)
+	browser.move(1, 0)
+	if browser.selected != 0 {
+		t.Fatalf("selection should not move without tests, got %d", browser.selected)
+	}
+}
+
+func TestTestBrowserMoveWrapsAround(t *testing.T) {
+	browser := testBrowser{}
+	browser.move(-1, 3)
+	if browser.selected != 2 {
+		t.Fatalf("expected the last test after moving up from the first one, got %d", browser.selected)
+	}
+	browser.move(1, 3)
+	if browser.selected != 0 {
+		t.Fatalf("expected the first test after moving down from the last one, got %d", browser.selected)
+	}
+}
+
+func TestTestBrowserScrollsTrace(t *testing.T) {
+	cases := []struct {
+		name     string
+		origin   int
+		scroll   func(browser *testBrowser)
+		expected int
+	}{
+		{"line down", 0, func(browser *testBrowser) { browser.move(1, 12) }, 1},
+		{"line up at the top", 0, func(browser *testBrowser) { browser.move(-1, 12) }, 0},
+		{"page down", 3, func(browser *testBrowser) { browser.page(1, 12) }, 8},
+		{"page up", 3, func(browser *testBrowser) { browser.page(-1, 12) }, 0},
+		{"end", 3, func(browser *testBrowser) { browser.page(maxInt, 12) }, maxInt},
+		{"scrolling past the end", maxInt - 1, func(browser *testBrowser) { browser.page(1, 12) }, maxInt},
+		{"home", 3, func(browser *testBrowser) { browser.page(-maxInt, 12) }, 0},
+	}
+	for _, c := range cases {
+		browser := testBrowser{selected: 4, pageSize: 5, showTrace: true, traceOrigin: c.origin}
+		c.scroll(&browser)
+		if browser.traceOrigin != c.expected || browser.selected != 4 {
+			t.Errorf("%v: expected trace from line %d and the same test selected, got line %d and test %d",
+				c.name, c.expected, browser.traceOrigin, browser.selected)
+		}
+	}
+}
+
+func TestTestBrowserApply(t *testing.T) {
+	tests := []model.TestCase{
+		{ClassName: "com.example.ParserTest", Name: "parsesEmpty", Status: "FAILED"},
+		{ClassName: "com.example.web.ServerTest", Name: "starts", Status: "REGRESSION"},
+		{ClassName: "com.example.ParserTest", Name: "parsesNested", Status: "FAILED"},
+		{ClassName: "DefaultTest", Name: "works", Status: "FAILED"},
+	}
+	cases := []struct {
+		name     string
+		browser  testBrowser
+		expected []int
+	}{
+		{"ungrouped", testBrowser{grouping: testsUngrouped}, []int{0, 1, 2, 3}},
+		{"by class", testBrowser{grouping: testsByClass}, []int{0, 2, 1, 3}},
+		{"by package", testBrowser{grouping: testsByPackage}, []int{0, 2, 1, 3}},
+		{"filtered by name", testBrowser{grouping: testsByClass, filter: "PARSES"}, []int{0, 2}},
+		{"filtered by status", testBrowser{grouping: testsUngrouped, filter: "regression"}, []int{1}},
+	}
+	for _, c := range cases {
+		if visible := c.browser.apply(tests); !reflect.DeepEqual(visible, c.expected) {
+			t.Errorf("%v: expected tests %v, got %v", c.name, c.expected, visible)
+		}
+	}
+}
+
+func TestTestGroupingNames(t *testing.T) {
+	test := model.TestCase{ClassName: "com.example.ParserTest", Name: "parsesEmpty"}
+	cases := []struct {
+		grouping testGrouping
+		group    string
+		name     string
+	}{
+		{testsUngrouped, "", "com.example.ParserTest.parsesEmpty"},
+		{testsByClass, "com.example.ParserTest", "parsesEmpty"},
+		{testsByPackage, "com.example", "ParserTest.parsesEmpty"},
+	}
+	for _, c := range cases {
+		if group, name := c.grouping.groupOf(&test), c.grouping.nameOf(&test); group != c.group || name != c.name {
+			t.Errorf("grouped by %v: expected %q in group %q, got %q in group %q", c.grouping, c.name, c.group, name, group)
+		}
+	}
+	if group := testsByPackage.groupOf(&model.TestCase{ClassName: "DefaultTest"}); group != "(default package)" {
+		t.Errorf("expected default package, got %q", group)
+	}
+	if next := testsByPackage.next(); next != testsUngrouped {
+		t.Errorf("expected groupings to wrap around, got %v", next)
+	}
+}
+
+func TestFailureAge(t *testing.T) {
+	cases := []struct {
+		name       string
+		test       model.TestCase
+		age        string
+		newFailure bool
+	}{
+		{"new", model.TestCase{Status: "FAILED", Age: 1, FailedSince: 12}, "new", true},
+		{"regressed", model.TestCase{Status: "REGRESSION", Age: 1, FailedSince: 12}, "regressed", true},
+		{"persistent", model.TestCase{Status: "FAILED", Age: 5, FailedSince: 8}, "failing for 5 builds since #8", false},
+		{"age not known", model.TestCase{Status: "FAILED"}, "failed", false},
+	}
+	for _, c := range cases {
+		if age := failureAge(&c.test); age != c.age {
+			t.Errorf("%v: expected %q, got %q", c.name, c.age, age)
+		}
+		if newFailure := isNewFailure(&c.test); newFailure != c.newFailure {
+			t.Errorf("%v: expected new failure to be %v, got %v", c.name, c.newFailure, newFailure)
+		}
+	}
+}
+
+func TestTestsAreFilteredByFailureAge(t *testing.T) {
+	tests := []model.TestCase{
+		{ClassName: "com.example.ParserTest", Name: "parsesEmpty", Status: "FAILED", Age: 1},
+		{ClassName: "com.example.ParserTest", Name: "parsesNested", Status: "FAILED", Age: 3, FailedSince: 10},
+	}
+	browser := testBrowser{filter: "new"}
+	if visible := browser.apply(tests); !reflect.DeepEqual(visible, []int{0}) {
+		t.Fatalf("expected only the new failure, got %v", visible)
+	}
+}
@@ -41,3 +41,11 @@ func unknownChar() string {
 	}
 	return "❓"
 }
+
+func spinnerChar(frame int) string {
+	frames := []string{"◐", "◓", "◑", "◒"}
+	if AvoidUnicode {
+		frames = []string{"|", "/", "-", "\\"}
+	}
+	return frames[frame%len(frames)]
+}
@@ -72,11 +72,16 @@ func friendlyPreviousState(buildStatus *model.JobState) string {
 // PresentState comes from View and is a call that is used to ask the view
 // to refresh itself based on current model state
 func (ui *ConsoleInterface) PresentState(state *model.State) {
+	if state.Refresh.Total != 0 {
+		// partial results are not dumped, state is dumped once when the refresh is over
+		return
+	}
 	output := "\n\n\n"
 	if state.Error != nil {
 		output = output + redFormat(fmt.Sprintf("Could not fetch running jobs: %v\n", state.Error)) + resetFormat
 	} else {
-		for i, jobState := range state.JobStates {
+		for i, job := range DefaultOrder.Apply(state, Filter{}.Apply(state)) {
+			jobState := state.JobStates[job]
 			output = output + string(itoidrune(i)) + " "
 			if jobState.Error != nil {
 				output = output + fmt.Sprintf("%30v %v%v, %v %v\n", yellowFormat(jobState.JobName), ui.friendlyCurrentStatus(jobState), redFormat(", but REST processing had an error: "), jobState.Error, resetFormat)
@@ -96,6 +101,9 @@ func (ui *ConsoleInterface) PresentState(state *model.State) {
 			}
 		}
 	}
+	if !state.DisconnectedSince.IsZero() {
+		output = output + redFormat(fmt.Sprintf("Disconnected from Clici server since %v, states might be stale\n", state.DisconnectedSince.Format("15:04"))) + resetFormat
+	}
 	fmt.Printf("%vStatus fetched @ %v\n", output, time.Now().Format(time.RFC822))
 }
 
@@ -4,11 +4,25 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jroimartin/gocui"
+	"github.com/mgutz/ansi"
 	"github.com/milanaleksic/clici/model"
-	"strings"
+	"github.com/nsf/termbox-go"
+)
+
+// colors of the job table columns, written as escape codes into the jobs view
+var (
+	buildingColor              = ansi.ColorFunc("blue+b")
+	failedJobColor             = ansi.ColorFunc("red+b")
+	successfulJobColor         = ansi.ColorFunc("green+b")
+	successfulDescriptionColor = ansi.ColorFunc("green")
+	undefinedJobColor          = ansi.ColorFunc("magenta+b")
+	disabledJobColor           = ansi.ColorFunc("yellow")
+	unknownJobColor            = ansi.ColorFunc("white+b")
 )
 
 // CUIInterface is a View that uses a ncurses-like advanced interface that
@@ -17,8 +31,38 @@ type CUIInterface struct {
 	gui             *gocui.Gui
 	feedbackChannel chan Command
 	tableStart      int
+	// selection is shared between key bindings and presenting of the state, which run in different goroutines
+	selectionLock sync.Mutex
+	selected      int
+	jobCount      int
+	modal         bool
+	state         *model.State
+	// filter narrows down jobs shown, visible are indexes of the shown jobs in the state
+	filter        Filter
+	editingFilter bool
+	visible       []int
+	// detailedJob is the index of the job whose details are shown, or negative if they are not shown
+	detailedJob int
+	// order decides how shown jobs are sorted and grouped
+	order Order
+	// tests is the state of the failed tests dialog while it is open, visibleTests are indexes of the shown tests
+	tests        testBrowser
+	testsOpen    bool
+	visibleTests []int
+	// boundRunes are characters that have their own key binding
+	boundRunes map[rune]bool
+	// origin is the first line of the job table that is visible, pageSize how many lines are visible
+	origin   int
+	pageSize int
+	// layout is the latest layout that is not yet handed over to the goroutine of the gui
+	layout gocui.Handler
 }
 
+const maxInt = int(^uint(0) >> 1)
+
+// idShortcuts is the number of jobs that can be reached by their id, 'j' (which would be the next id) is used for navigation
+const idShortcuts = 19
+
 func checkCui(err error) {
 	if err != gocui.ErrUnknownView {
 		log.Panicf("Unexpected error occured: %v", err)
@@ -42,129 +86,222 @@ func friendlyKnownStatus(buildStatus model.JobState) string {
 // PresentState comes from View and is a call that is used to ask the view
 // to refresh itself based on current model state
 func (ui *CUIInterface) PresentState(state *model.State) {
-	if state.Error != nil || len(state.JobStates) == 0 {
-		ui.errorDialog(state)
-		ui.bottomLine()
-		return
+	// key bindings present the state again from another goroutine, while controller keeps changing its own
+	ui.present(copyState(state))
+}
+
+// copyState copies the state deep enough for the copy not to change when the controller changes the original
+func copyState(state *model.State) *model.State {
+	copied := *state
+	copied.JobStates = append([]model.JobState(nil), state.JobStates...)
+	copied.FailedTests = append([]model.TestCase(nil), state.FailedTests...)
+	if state.Details != nil {
+		details := *state.Details
+		copied.Details = &details
 	}
-	if len(state.FailedTests) != 0 {
-		ui.informationDialogOfTests(state)
-		ui.bottomLine()
-		return
+	return &copied
+}
+
+// present draws the state, which has to be owned by the view
+func (ui *CUIInterface) present(state *model.State) {
+	ui.selectionLock.Lock()
+	ui.state = state
+	ui.visible = ui.order.Apply(state, ui.filter.Apply(state))
+	ui.jobCount = len(ui.visible)
+	visible := ui.visible
+	filter, editingFilter, order := ui.filter, ui.editingFilter, ui.order
+	ui.modal = state.Error != nil || len(state.FailedTests) != 0 || state.ShowHelp || state.Details != nil
+	ui.detailedJob = -1
+	if state.Details != nil {
+		ui.detailedJob = state.Details.Job
 	}
-	ui.gui.SetLayout(func(gui *gocui.Gui) error {
-		lengthForJobNames := ui.maxLengthOfName(state)
-		groupCount := ui.countDistinctGroups(state)
-		if v, err := gui.SetView("job_id", 0, ui.tableStart, 3, 2*len(state.JobStates)+4+groupCount); err != nil {
-			checkCui(err)
-			v.Frame = false
-			v.FgColor = gocui.ColorWhite
-			prevGroup := ""
-			iter := 0
-			for _, jobState := range state.JobStates {
-				if jobState.Group != prevGroup {
-					prevGroup = jobState.Group
-					fmt.Fprintf(v, " \n")
-				}
-				fmt.Fprintf(v, "%s\n", string(itoidrune(iter)))
-				iter++
-			}
-		}
-		if v, err := gui.SetView("job_name", 2, ui.tableStart, lengthForJobNames+3, 2*len(state.JobStates)+4+groupCount); err != nil {
-			checkCui(err)
-			v.Frame = false
-			v.FgColor = gocui.ColorYellow
-			prevGroup := ""
-			for _, jobState := range state.JobStates {
-				if jobState.Group != prevGroup {
-					prevGroup = jobState.Group
-					fmt.Fprintf(v, ui.leftPad2Len(fmt.Sprintf(" %v\n", jobState.Group), "=", lengthForJobNames+1))
-				}
-				fmt.Fprintf(v, "%"+strconv.Itoa(lengthForJobNames)+"v\n", jobState.JobName)
-			}
-		}
-		prevGroup := ""
-		iter := 0
-		for _, jobState := range state.JobStates {
-			if jobState.Group != prevGroup {
-				prevGroup = jobState.Group
-				iter++
+	if ui.selected >= ui.jobCount {
+		ui.selected = ui.jobCount - 1
+	}
+	if ui.selected < 0 {
+		ui.selected = 0
+	}
+	if !ui.testsOpen {
+		ui.tests = testBrowser{grouping: testsByClass}
+	}
+	ui.testsOpen = state.Error == nil && len(state.FailedTests) != 0
+	ui.visibleTests = ui.tests.apply(state.FailedTests)
+	if ui.tests.selected >= len(ui.visibleTests) {
+		ui.tests.selected = len(ui.visibleTests) - 1
+	}
+	if ui.tests.selected < 0 {
+		ui.tests.selected = 0
+	}
+	tests, visibleTests := ui.tests, ui.visibleTests
+	ui.selectionLock.Unlock()
+	var layout gocui.Handler
+	switch {
+	case state.Error == nil && len(state.JobStates) == 0 && state.Refresh.Total != 0:
+		// nothing to show until the first refresh brings some jobs
+		layout = splash
+	case state.Error != nil || len(state.JobStates) == 0:
+		layout = ui.errorDialog(state)
+	case len(state.FailedTests) != 0:
+		layout = ui.testsDialog(state.FailedTests, visibleTests, tests, editingFilter)
+	case state.Details != nil:
+		layout = ui.detailsDialog(state.Details)
+	default:
+		layout = func(gui *gocui.Gui) error {
+			lengthForJobNames := ui.maxLengthOfName(state)
+			ui.showJobTable(state, visible, order, lengthForJobNames)
+			ui.topLine(lengthForJobNames, filter, editingFilter, order, len(visible), len(state.JobStates))
+			if state.ShowHelp {
+				ui.helpDialog()
 			}
-			ui.showJobColumns(&jobState, iter, lengthForJobNames)
-			iter++
+			return nil
 		}
-		ui.topLine(lengthForJobNames)
-		ui.bottomLine()
-		if state.ShowHelp {
-			ui.helpDialog()
+	}
+	ui.setLayout(func(gui *gocui.Gui) error {
+		if err := layout(gui); err != nil {
+			return err
 		}
+		ui.bottomLine(state)
 		return nil
 	})
 }
 
-func (ui *CUIInterface) showJobColumns(jobState *model.JobState, index int, lengthForJobNames int) {
-	ui.showBuildFlagColumn(jobState, index, lengthForJobNames)
-	ui.showJobStatusColumn(jobState, index, lengthForJobNames)
-	ui.showJobDescriptionColumn(jobState, index, lengthForJobNames)
+// setLayout hands the layout over to the goroutine of the gui, which is the only one allowed to touch the views.
+// Handovers can arrive in a different order, so only the latest layout is used
+func (ui *CUIInterface) setLayout(layout gocui.Handler) {
+	ui.selectionLock.Lock()
+	ui.layout = layout
+	ui.selectionLock.Unlock()
+	ui.gui.Execute(func(g *gocui.Gui) error {
+		ui.selectionLock.Lock()
+		layout := ui.layout
+		ui.layout = nil
+		ui.selectionLock.Unlock()
+		if layout != nil {
+			g.SetLayout(layout)
+		}
+		return nil
+	})
 }
 
-func (ui *CUIInterface) showBuildFlagColumn(jobState *model.JobState, index int, lengthForJobNames int) {
-	if v, err := ui.gui.SetView(fmt.Sprintf("building_job_%v", index), lengthForJobNames+3, ui.tableStart+index, lengthForJobNames+5, ui.tableStart+index+2); err != nil {
+func splash(g *gocui.Gui) error {
+	maxX, maxY := g.Size()
+	if v, err := g.SetView("center", maxX/2-24, maxY/2-2, maxX/2+23, maxY/2+1); err != nil {
 		checkCui(err)
 		v.Frame = false
-		v.FgColor = gocui.ColorBlue | gocui.AttrBold
-		if jobState.Building {
-			switch {
-			case jobState.Building:
-				fmt.Fprint(v, buildingChar())
+		fmt.Fprintln(v, " Jenkins Ping\n https://github.com/milanaleksic/clici")
+	}
+	return nil
+}
+
+// showJobTable draws all jobs into a single view, scrolled so that the selected job is visible.
+// Colored columns are drawn over the table only for the rows that are visible
+func (ui *CUIInterface) showJobTable(state *model.State, visible []int, order Order, lengthForJobNames int) {
+	maxX, maxY := ui.gui.Size()
+	v, err := ui.gui.SetView("jobs", -1, ui.tableStart, maxX, maxY-1)
+	if err == nil {
+		return
+	}
+	checkCui(err)
+	v.Frame = false
+	v.FgColor = gocui.ColorYellow
+	v.Highlight = true
+	v.SelBgColor = gocui.ColorBlue
+	v.SelFgColor = gocui.ColorWhite | gocui.AttrBold
+	_, height := v.Size()
+	selected := ui.selection()
+	prevGroup := ""
+	selectedLine := 0
+	line := 0
+	for i, job := range visible {
+		jobState := state.JobStates[job]
+		if group := order.GroupOf(&jobState); group != prevGroup {
+			prevGroup = group
+			header := fmt.Sprintf(" %v", group)
+			if len(header) < lengthForJobNames {
+				header = ui.leftPad2Len(header, "=", lengthForJobNames)
 			}
+			fmt.Fprintf(v, "   %v\n", header)
+			line++
+		}
+		if i == selected {
+			selectedLine = line
 		}
+		id := ""
+		if i < idShortcuts {
+			id = string(itoidrune(i))
+		}
+		building := ""
+		if jobState.Building {
+			building = buildingChar()
+		}
+		fmt.Fprintf(v, "%-2s %"+strconv.Itoa(lengthForJobNames)+"v %v %v %v\n",
+			id, jobState.JobName,
+			buildingColor(fmt.Sprintf("%-1s", building)),
+			jobStatusColor(&jobState)(fmt.Sprintf("%-1s", friendlyKnownStatus(jobState))),
+			jobDescriptionColor(&jobState)(describeJob(&jobState)))
+		line++
+	}
+	origin := ui.scrollTo(selectedLine, height)
+	if err := v.SetOrigin(0, origin); err != nil {
+		log.Printf("Could not scroll jobs: %v", err)
+	}
+	if err := v.SetCursor(0, selectedLine-origin); err != nil {
+		log.Printf("Could not highlight selected job: %v", err)
 	}
 }
 
-func (ui *CUIInterface) showJobStatusColumn(jobState *model.JobState, index int, lengthForJobNames int) {
-	if v, err := ui.gui.SetView(fmt.Sprintf("curr_job_status_%v", index), lengthForJobNames+5, ui.tableStart+index, lengthForJobNames+7, ui.tableStart+index+2); err != nil {
-		checkCui(err)
-		v.Frame = false
-		switch {
-		case jobState.PreviousState == model.Failure:
-			v.FgColor = gocui.ColorRed | gocui.AttrBold
-		case jobState.PreviousState == model.Success:
-			v.FgColor = gocui.ColorGreen | gocui.AttrBold
-		case jobState.PreviousState == model.Undefined:
-			v.FgColor = gocui.ColorMagenta | gocui.AttrBold
-		case jobState.PreviousState == model.Disabled:
-			v.FgColor = gocui.ColorYellow
-		case jobState.PreviousState == model.Unknown:
-			v.FgColor = gocui.ColorWhite | gocui.AttrBold
-		default:
-			v.FgColor = gocui.ColorWhite | gocui.AttrBold
-		}
-		fmt.Fprintf(v, "%v", friendlyKnownStatus(*jobState))
+// scrollTo moves the scrolling origin of the job table just enough for the given line to be visible
+func (ui *CUIInterface) scrollTo(line int, height int) int {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	ui.pageSize = height
+	if line < ui.origin {
+		ui.origin = line
+	} else if line >= ui.origin+height {
+		ui.origin = line - height + 1
 	}
+	return ui.origin
 }
 
-func (ui *CUIInterface) showJobDescriptionColumn(jobState *model.JobState, index int, lengthForJobNames int) {
-	maxX, _ := ui.gui.Size()
-	if v, err := ui.gui.SetView(fmt.Sprintf("curr_job_description_%v", index), lengthForJobNames+7, ui.tableStart+index, maxX, ui.tableStart+index+2); err != nil {
-		checkCui(err)
-		v.Frame = false
-		if jobState.Error != nil {
-			v.FgColor = gocui.ColorRed | gocui.AttrBold
-			fmt.Fprintf(v, "API processing had an error: %v", jobState.Error)
-		} else {
-			switch {
-			case jobState.PreviousState == model.Success:
-				v.FgColor = gocui.ColorGreen
-				fmt.Fprintf(v, "%v (%v)", jobState.CausesFriendly, jobState.Time)
-			case jobState.PreviousState == model.Failure:
-				v.FgColor = gocui.ColorRed | gocui.AttrBold
-				fmt.Fprintf(v, "%v (%v); failed by %v", jobState.CausesFriendly, jobState.Time, jobState.CulpritsFriendly)
-			case jobState.PreviousState == model.Disabled || jobState.PreviousState == model.Unknown || jobState.PreviousState == model.Undefined:
-				v.FgColor = gocui.ColorYellow
-				fmt.Fprintf(v, "%v (%v)", jobState.CausesFriendly, jobState.Time)
-			}
-		}
+func describeJob(jobState *model.JobState) string {
+	if jobState.Error != nil {
+		return fmt.Sprintf("API processing had an error: %v", jobState.Error)
+	}
+	switch {
+	case jobState.PreviousState == model.Failure:
+		return fmt.Sprintf("%v (%v); failed by %v", jobState.CausesFriendly, jobState.Time, jobState.CulpritsFriendly)
+	case jobState.PreviousState == model.Success || jobState.PreviousState == model.Disabled ||
+		jobState.PreviousState == model.Unknown || jobState.PreviousState == model.Undefined:
+		return fmt.Sprintf("%v (%v)", jobState.CausesFriendly, jobState.Time)
+	}
+	return ""
+}
+
+// jobStatusColor matches colors of the job status column to the job state
+func jobStatusColor(jobState *model.JobState) func(string) string {
+	switch jobState.PreviousState {
+	case model.Failure:
+		return failedJobColor
+	case model.Success:
+		return successfulJobColor
+	case model.Undefined:
+		return undefinedJobColor
+	case model.Disabled:
+		return disabledJobColor
+	default:
+		return unknownJobColor
+	}
+}
+
+// jobDescriptionColor matches colors of the job description column to the job state
+func jobDescriptionColor(jobState *model.JobState) func(string) string {
+	switch {
+	case jobState.Error != nil || jobState.PreviousState == model.Failure:
+		return failedJobColor
+	case jobState.PreviousState == model.Success:
+		return successfulDescriptionColor
+	default:
+		return disabledJobColor
 	}
 }
 
@@ -174,22 +311,23 @@ func NewCUIInterface(feedbackChannel chan Command) (view *CUIInterface, err erro
 	view = &CUIInterface{
 		gui:             gocui.NewGui(),
 		feedbackChannel: feedbackChannel,
+		boundRunes:      make(map[rune]bool),
+		order:           DefaultOrder,
+		detailedJob:     -1,
 	}
 	if err = view.gui.Init(); err != nil {
 		return
 	}
 	view.gui.BgColor = gocui.ColorDefault
 	view.gui.FgColor = gocui.ColorWhite
-	view.gui.SetLayout(func(g *gocui.Gui) error {
-		maxX, maxY := g.Size()
-		if v, err2 := g.SetView("center", maxX/2-24, maxY/2-2, maxX/2+23, maxY/2+1); err2 != nil {
-			checkCui(err2)
-			v.Frame = false
-			fmt.Fprintln(v, " Jenkins Ping\n https://github.com/milanaleksic/clici")
-		}
+	view.gui.SetLayout(splash)
+	view.setKeyBindings()
+	// gocui waits for the key following Esc to report both as Alt+key, so Esc alone would never come through.
+	// Input mode is switched only once the main loop (which sets it) runs
+	view.gui.Execute(func(g *gocui.Gui) error {
+		termbox.SetInputMode(termbox.InputEsc)
 		return nil
 	})
-	view.setKeyBindings()
 	go func() {
 		err = view.gui.MainLoop()
 		if err != nil && err != gocui.ErrQuit {
@@ -214,10 +352,10 @@ func (ui *CUIInterface) setKeyBindings() {
 	if err := ui.gui.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, quit); err != nil {
 		return
 	}
-	if err := ui.gui.SetKeybinding("", 'q', gocui.ModNone, quit); err != nil {
+	if err := ui.setKeybinding('q', quit); err != nil {
 		return
 	}
-	if err := ui.gui.SetKeybinding("", '?', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+	if err := ui.setKeybinding('?', func(g *gocui.Gui, v *gocui.View) error {
 		ui.feedbackChannel <- CreateCmdShowHelpGroup()
 		return nil
 	}); err != nil {
@@ -226,23 +364,47 @@ func (ui *CUIInterface) setKeyBindings() {
 	var cmd = CreateCmdOpenCurrentJobGroup()
 	setCommand := func(x Command) func(*gocui.Gui, *gocui.View) error {
 		return func(g *gocui.Gui, v *gocui.View) error {
+			// while details are shown, command applies to that job right away
+			if job, ok := ui.detailed(); ok {
+				x.Job = job
+				ui.feedbackChannel <- x
+				return nil
+			}
 			cmd = x
 			return nil
 		}
 	}
-	if err := ui.gui.SetKeybinding("", 'p', gocui.ModNone, setCommand(CreateCmdOpenPreviousJob())); err != nil {
+	if err := ui.setKeybinding('o', func(g *gocui.Gui, v *gocui.View) error {
+		x := CreateCmdOpenCurrentJobGroup()
+		if job, ok := ui.detailed(); ok {
+			x.Job = job
+		} else if job, ok := ui.selectedJob(); ok {
+			x.Job = job
+		} else {
+			return nil
+		}
+		ui.feedbackChannel <- x
+		return nil
+	}); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('p', setCommand(CreateCmdOpenPreviousJob())); err != nil {
 		return
 	}
-	if err := ui.gui.SetKeybinding("", 't', gocui.ModNone, setCommand(CreateCmdTestsForJobGroup())); err != nil {
+	if err := ui.setKeybinding('t', setCommand(CreateCmdTestsForJobGroup())); err != nil {
 		return
 	}
-	if err := ui.gui.SetKeybinding("", 'r', gocui.ModNone, setCommand(CreateCmdRunJob())); err != nil {
+	if err := ui.setKeybinding('r', setCommand(CreateCmdRunJob())); err != nil {
 		return
 	}
-	for i := 0; i < 20; i++ {
+	for i := 0; i < idShortcuts; i++ {
 		var localizedI = i
-		if err := ui.gui.SetKeybinding("", itoidrune(i), gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
-			cmd.Job = localizedI
+		if err := ui.setKeybinding(itoidrune(i), func(g *gocui.Gui, v *gocui.View) error {
+			job, ok := ui.selectJob(localizedI)
+			if !ok {
+				return nil
+			}
+			cmd.Job = job
 			ui.feedbackChannel <- cmd
 			cmd = CreateCmdOpenCurrentJobGroup()
 			return nil
@@ -250,16 +412,381 @@ func (ui *CUIInterface) setKeyBindings() {
 			return
 		}
 	}
+	moveSelection := func(delta int) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			ui.moveSelection(delta)
+			return nil
+		}
+	}
+	for _, key := range []interface{}{'j', gocui.KeyArrowDown} {
+		if err := ui.setKeybinding(key, moveSelection(1)); err != nil {
+			return
+		}
+	}
+	for _, key := range []interface{}{'k', gocui.KeyArrowUp} {
+		if err := ui.setKeybinding(key, moveSelection(-1)); err != nil {
+			return
+		}
+	}
+	pageSelection := func(pages int) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			ui.pageSelection(pages)
+			return nil
+		}
+	}
+	if err := ui.setKeybinding(gocui.KeyPgdn, pageSelection(1)); err != nil {
+		return
+	}
+	if err := ui.setKeybinding(gocui.KeyPgup, pageSelection(-1)); err != nil {
+		return
+	}
+	if err := ui.setKeybinding(gocui.KeyEnd, pageSelection(maxInt)); err != nil {
+		return
+	}
+	if err := ui.setKeybinding(gocui.KeyHome, pageSelection(-maxInt)); err != nil {
+		return
+	}
+	refresh := func(g *gocui.Gui, v *gocui.View) error {
+		ui.feedbackChannel <- CreateCmdRefreshGroup()
+		return nil
+	}
+	if err := ui.setKeybinding(gocui.KeyF5, refresh); err != nil {
+		return
+	}
+	if err := ui.setKeybinding(gocui.KeyCtrlR, refresh); err != nil {
+		return
+	}
+	changeFilter := func(change func(filter *Filter)) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			ui.changeFilter(change)
+			return nil
+		}
+	}
+	if err := ui.setKeybinding('/', func(g *gocui.Gui, v *gocui.View) error {
+		ui.selectionLock.Lock()
+		ui.editingFilter = true
+		state := ui.state
+		ui.selectionLock.Unlock()
+		if state != nil {
+			ui.present(state)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('F', changeFilter(func(filter *Filter) { filter.OnlyFailing = !filter.OnlyFailing })); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('B', changeFilter(func(filter *Filter) { filter.OnlyBuilding = !filter.OnlyBuilding })); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('M', changeFilter(func(filter *Filter) { filter.OnlyMine = !filter.OnlyMine })); err != nil {
+		return
+	}
+	changeOrder := func(change func(order Order) Order) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			ui.changeOrder(change)
+			return nil
+		}
+	}
+	if err := ui.setKeybinding('S', changeOrder(Order.NextSort)); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('G', func(g *gocui.Gui, v *gocui.View) error {
+		if ui.changeTests(func(tests *testBrowser) { tests.grouping = tests.grouping.next() }) {
+			return nil
+		}
+		ui.changeOrder(Order.NextGroup)
+		return nil
+	}); err != nil {
+		return
+	}
+	if err := ui.setKeybinding('!', changeOrder(func(order Order) Order {
+		order.BrokenFirst = !order.BrokenFirst
+		return order
+	})); err != nil {
+		return
+	}
+	if err := ui.gui.SetKeybinding("", gocui.KeyEsc, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		if ui.isEditingFilter() {
+			ui.typeIntoFilter(func(text string) string { return "" })
+			ui.stopEditingFilter()
+			return nil
+		}
+		ui.selectionLock.Lock()
+		modal, showTrace := ui.modal, ui.testsOpen && ui.tests.showTrace
+		ui.selectionLock.Unlock()
+		if showTrace {
+			ui.changeTests(func(tests *testBrowser) { tests.showTrace = false })
+			return nil
+		}
+		if modal {
+			ui.feedbackChannel <- CreateCmdCloseGroup()
+			return nil
+		}
+		ui.feedbackChannel <- CreateCmdCancelRefreshGroup()
+		return nil
+	}); err != nil {
+		return
+	}
 	if err := ui.gui.SetKeybinding("", gocui.KeyEnter, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
-		ui.feedbackChannel <- CreateCmdCloseGroup()
+		if ui.isEditingFilter() {
+			ui.stopEditingFilter()
+			return nil
+		}
+		if ui.changeTests(func(tests *testBrowser) {
+			tests.showTrace = !tests.showTrace && len(ui.visibleTests) != 0
+			tests.traceOrigin = 0
+		}) {
+			return nil
+		}
+		ui.selectionLock.Lock()
+		modal, selected, visible := ui.modal, ui.selected, ui.visible
+		ui.selectionLock.Unlock()
+		if modal || len(visible) == 0 {
+			ui.feedbackChannel <- CreateCmdCloseGroup()
+			return nil
+		}
+		if cmd.Group == CmdOpenCurrentJobGroup {
+			// no command was chosen before Enter
+			cmd = CreateCmdShowDetailsGroup()
+		}
+		cmd.Job = visible[selected]
+		ui.feedbackChannel <- cmd
+		cmd = CreateCmdOpenCurrentJobGroup()
 		return nil
 	}); err != nil {
 		return
 	}
+	if err := ui.setFilterKeybindings(); err != nil {
+		return
+	}
 }
 
-func (ui *CUIInterface) errorDialog(state *model.State) {
-	ui.gui.SetLayout(func(g *gocui.Gui) error {
+// setKeybinding registers a global key binding which is ignored while the filter is being typed in,
+// characters are added to the filter instead
+func (ui *CUIInterface) setKeybinding(key interface{}, handler gocui.KeybindingHandler) error {
+	if ch, ok := key.(rune); ok {
+		ui.boundRunes[ch] = true
+	}
+	return ui.gui.SetKeybinding("", key, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		if ui.isEditingFilter() {
+			if ch, ok := key.(rune); ok {
+				ui.typeIntoFilter(func(text string) string { return text + string(ch) })
+			}
+			return nil
+		}
+		return handler(g, v)
+	})
+}
+
+// setFilterKeybindings makes all the other printable characters, space and backspace change the filter
+// while it is being typed in
+func (ui *CUIInterface) setFilterKeybindings() error {
+	for ch := '!'; ch <= '~'; ch++ {
+		if !ui.boundRunes[ch] {
+			if err := ui.setKeybinding(ch, func(g *gocui.Gui, v *gocui.View) error { return nil }); err != nil {
+				return err
+			}
+		}
+	}
+	typeIn := func(change func(text string) string) func(*gocui.Gui, *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error {
+			if ui.isEditingFilter() {
+				ui.typeIntoFilter(change)
+			}
+			return nil
+		}
+	}
+	if err := ui.gui.SetKeybinding("", gocui.KeySpace, gocui.ModNone, typeIn(func(text string) string { return text + " " })); err != nil {
+		return err
+	}
+	removeLast := typeIn(func(text string) string {
+		if runes := []rune(text); len(runes) != 0 {
+			return string(runes[:len(runes)-1])
+		}
+		return text
+	})
+	for _, key := range []gocui.Key{gocui.KeyBackspace, gocui.KeyBackspace2} {
+		if err := ui.gui.SetKeybinding("", key, gocui.ModNone, removeLast); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ui *CUIInterface) isEditingFilter() bool {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	return ui.editingFilter
+}
+
+func (ui *CUIInterface) stopEditingFilter() {
+	ui.selectionLock.Lock()
+	ui.editingFilter = false
+	state := ui.state
+	ui.selectionLock.Unlock()
+	if state != nil {
+		ui.present(state)
+	}
+}
+
+// changeOrder applies the change to the order, keeping the same job selected
+func (ui *CUIInterface) changeOrder(change func(order Order) Order) {
+	ui.selectionLock.Lock()
+	ui.order = change(ui.order)
+	state := ui.state
+	if state == nil {
+		ui.selectionLock.Unlock()
+		return
+	}
+	selectedJob := -1
+	if ui.selected < len(ui.visible) {
+		selectedJob = ui.visible[ui.selected]
+	}
+	ui.visible = ui.order.Apply(state, ui.visible)
+	for i, job := range ui.visible {
+		if job == selectedJob {
+			ui.selected = i
+		}
+	}
+	ui.selectionLock.Unlock()
+	ui.present(state)
+}
+
+// changeFilter applies the change to the filter and shows the jobs from the beginning
+func (ui *CUIInterface) changeFilter(change func(filter *Filter)) {
+	ui.selectionLock.Lock()
+	change(&ui.filter)
+	ui.selected = 0
+	ui.origin = 0
+	state := ui.state
+	ui.selectionLock.Unlock()
+	if state != nil {
+		ui.present(state)
+	}
+}
+
+// typeIntoFilter applies the change to the text of the failed tests filter while they are shown,
+// or to the text of the job filter otherwise
+func (ui *CUIInterface) typeIntoFilter(change func(text string) string) {
+	if ui.changeTests(func(tests *testBrowser) { tests.changeFilter(change) }) {
+		return
+	}
+	ui.changeFilter(func(filter *Filter) { filter.Text = change(filter.Text) })
+}
+
+// changeTests applies the change to the failed tests dialog, if it is open
+func (ui *CUIInterface) changeTests(change func(tests *testBrowser)) bool {
+	ui.selectionLock.Lock()
+	if !ui.testsOpen {
+		ui.selectionLock.Unlock()
+		return false
+	}
+	change(&ui.tests)
+	state := ui.state
+	ui.selectionLock.Unlock()
+	ui.present(state)
+	return true
+}
+
+// detailed gives the index of the job whose details are shown, if they are shown
+func (ui *CUIInterface) detailed() (int, bool) {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	return ui.detailedJob, ui.detailedJob >= 0
+}
+
+// selectedJob gives the index in the state of the selected job, if any job is shown
+func (ui *CUIInterface) selectedJob() (int, bool) {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	if ui.selected >= len(ui.visible) {
+		return 0, false
+	}
+	return ui.visible[ui.selected], true
+}
+
+func (ui *CUIInterface) selection() int {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	return ui.selected
+}
+
+// selectJob moves selection to the job shown at the given position, if there is such a job,
+// and gives back its index in the state
+func (ui *CUIInterface) selectJob(position int) (int, bool) {
+	ui.selectionLock.Lock()
+	if position >= len(ui.visible) {
+		ui.selectionLock.Unlock()
+		return 0, false
+	}
+	ui.selected = position
+	job := ui.visible[position]
+	state := ui.state
+	ui.selectionLock.Unlock()
+	ui.present(state)
+	return job, true
+}
+
+func (ui *CUIInterface) moveSelection(delta int) {
+	ui.selectionLock.Lock()
+	if ui.testsOpen {
+		ui.tests.move(delta, len(ui.visibleTests))
+		state := ui.state
+		ui.selectionLock.Unlock()
+		ui.present(state)
+		return
+	}
+	if ui.state == nil || ui.jobCount == 0 {
+		ui.selectionLock.Unlock()
+		return
+	}
+	ui.selected = (ui.selected + delta + ui.jobCount) % ui.jobCount
+	state := ui.state
+	ui.selectionLock.Unlock()
+	ui.present(state)
+}
+
+// pageSelection moves selection by the given number of pages of the job table, stopping at the first and the last job
+func (ui *CUIInterface) pageSelection(pages int) {
+	ui.selectionLock.Lock()
+	if ui.testsOpen {
+		ui.tests.page(pages, len(ui.visibleTests))
+		state := ui.state
+		ui.selectionLock.Unlock()
+		ui.present(state)
+		return
+	}
+	if ui.state == nil || ui.jobCount == 0 {
+		ui.selectionLock.Unlock()
+		return
+	}
+	pageSize := ui.pageSize
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	switch {
+	case pages >= ui.jobCount:
+		ui.selected = ui.jobCount - 1
+	case pages <= -ui.jobCount:
+		ui.selected = 0
+	default:
+		ui.selected += pages * pageSize
+	}
+	if ui.selected >= ui.jobCount {
+		ui.selected = ui.jobCount - 1
+	}
+	if ui.selected < 0 {
+		ui.selected = 0
+	}
+	state := ui.state
+	ui.selectionLock.Unlock()
+	ui.present(state)
+}
+
+func (ui *CUIInterface) errorDialog(state *model.State) gocui.Handler {
+	return func(g *gocui.Gui) error {
 		maxX, maxY := g.Size()
 		if v, err := g.SetView("center", 1, maxY/2-1, maxX-1, maxY/2+2); err != nil {
 			checkCui(err)
@@ -267,30 +794,39 @@ func (ui *CUIInterface) errorDialog(state *model.State) {
 			fmt.Fprintln(v, fmt.Sprintf("Error: %v\n", state.Error))
 		}
 		return nil
-	})
+	}
 }
 
-func (ui *CUIInterface) bottomLine() {
+func (ui *CUIInterface) bottomLine(state *model.State) {
 	maxX, maxY := ui.gui.Size()
 	fetchedMessage := fmt.Sprintf(" @ %v ", time.Now().Format(time.RFC822))
+	if state.Refresh.Total != 0 {
+		fetchedMessage = fmt.Sprintf(" %v refreshing %v/%v servers ", spinnerChar(state.Refresh.Done), state.Refresh.Done, state.Refresh.Total)
+	}
+	if !state.DisconnectedSince.IsZero() {
+		fetchedMessage = fmt.Sprintf(" disconnected since %v ", state.DisconnectedSince.Format("15:04"))
+	}
 	if v, err := ui.gui.SetView("bottom_left", -1, maxY-2, maxX-len(fetchedMessage)+1, maxY); err != nil {
 		checkCui(err)
 		v.BgColor = gocui.ColorBlack
 		v.FgColor = gocui.ColorWhite
 		v.Frame = false
-		fmt.Fprint(v, "<q>: Quit   <id>: Go to job   <?>: Show all commands")
+		fmt.Fprint(v, "<q>: Quit   <j/k>: Select   <Enter>: Details   <o>/<id>: Go to job   </>: Filter   <F5>: Refresh   <?>: Show all commands")
 	}
 	if v, err := ui.gui.SetView("bottom_right", maxX-len(fetchedMessage), maxY-2, maxX, maxY); err != nil {
 		checkCui(err)
 		v.BgColor = gocui.ColorBlack
 		v.FgColor = gocui.ColorWhite
+		if !state.DisconnectedSince.IsZero() {
+			v.BgColor = gocui.ColorRed
+		}
 		v.Frame = false
-		fmt.Fprintf(v, fetchedMessage)
+		fmt.Fprint(v, fetchedMessage)
 	}
 	return
 }
 
-func (ui *CUIInterface) topLine(lengthForJobNames int) {
+func (ui *CUIInterface) topLine(lengthForJobNames int, filter Filter, editingFilter bool, order Order, visibleCount int, jobCount int) {
 	maxX, _ := ui.gui.Size()
 	if v, err := ui.gui.SetView("top", -1, -1, maxX, 1); err != nil {
 		checkCui(err)
@@ -298,49 +834,225 @@ func (ui *CUIInterface) topLine(lengthForJobNames int) {
 		v.FgColor = gocui.ColorWhite
 		v.Frame = false
 		fmt.Fprintf(v, "ID %"+strconv.Itoa(lengthForJobNames)+"v B S DESCRIPTION", "NAME")
+		description := filter.String()
+		if editingFilter {
+			description = fmt.Sprintf("filter: /%v_", filter.Text)
+			v.FgColor = gocui.ColorCyan | gocui.AttrBold
+		}
+		if description != "" {
+			fmt.Fprintf(v, "   %v (%v of %v jobs)", description, visibleCount, jobCount)
+		}
+		fmt.Fprintf(v, "   %v", order)
 	}
 	return
 }
 
 func (ui *CUIInterface) helpDialog() {
-	ui.gui.SetLayout(func(g *gocui.Gui) error {
+	maxX, maxY := ui.gui.Size()
+	if v, err := ui.gui.SetView("center", maxX/2-30, maxY/2-10, maxX/2+30, maxY/2+10); err != nil {
+		checkCui(err)
+		v.FgColor = gocui.ColorWhite
+		v.Overwrite = false
+		fmt.Fprint(v, ""+
+			"              q - Quit\n"+
+			"   j/k, Up/Down - Select Job\n"+
+			"    PgUp/PgDown - Select Job a Page Away\n"+
+			"       Home/End - Select First/Last Job\n"+
+			"          Enter - Show Job Details\n"+
+			"        o, <id> - Open Last Job URL\n"+
+			"p+Enter, p+<id> - Open Last Completed Job URL\n"+
+			"t+Enter, t+<id> - Show Test failures\n"+
+			"    Enter, G, / - Test Stack Trace, Grouping, Filter\n"+
+			"r+Enter, r+<id> - Run Job\n"+
+			"     F5, Ctrl+R - Refresh All Jobs Now\n"+
+			"              / - Filter by Name, Group, Server, Culprit\n"+
+			"        F, B, M - Only Failing, Building, My Jobs\n"+
+			"           S, G - Change Sorting, Grouping\n"+
+			"              ! - Broken Jobs First\n"+
+			"            Esc - Cancel Refresh, Clear Filter\n"+
+			"     Enter, Esc - Close Help\n")
+	}
+}
+
+func (ui *CUIInterface) detailsDialog(details *model.JobDetails) gocui.Handler {
+	return func(g *gocui.Gui) error {
 		maxX, maxY := g.Size()
-		if v, err := g.SetView("center", maxX/2-26, maxY/2-3, maxX/2+26, maxY/2+3); err != nil {
+		if v, err := g.SetView("center", 2, 1, maxX-3, maxY-3); err != nil {
 			checkCui(err)
 			v.FgColor = gocui.ColorWhite
 			v.Overwrite = false
-			fmt.Fprintln(v, ""+
-				"              q - Quit\n"+
-				"           <id> - Open Last Job URL\n"+
-				"         p+<id> - Open Last Completed Job URL\n"+
-				"         t+<id> - Show Test failures\n"+
-				"         r+<id> - Run Job\n"+
-				"          Enter - Close Help\n")
+			fmt.Fprint(v, describeDetails(details, maxX-6))
 		}
 		return nil
-	})
+	}
+}
+
+func describeDetails(details *model.JobDetails, maxLength int) string {
+	output := fmt.Sprintf("%v #%v on %v\n\n", details.JobName, details.BuildID, details.Server)
+	field := func(label string, value interface{}) {
+		output += fmt.Sprintf("%12v: %v\n", label, value)
+	}
+	field("Result", details.Result)
+	field("Started", details.Started.Format(time.RFC822))
+	if details.Building {
+		field("Duration", fmt.Sprintf("running for %v, expected %v", roundDuration(details.Duration), roundDuration(details.EstimatedDuration)))
+	} else {
+		field("Duration", fmt.Sprintf("%v, expected %v", roundDuration(details.Duration), roundDuration(details.EstimatedDuration)))
+	}
+	if details.FailedTestCount >= 0 {
+		field("Failed tests", details.FailedTestCount)
+	} else {
+		field("Failed tests", "unknown")
+	}
+	field("Culprits", strings.Join(details.Culprits, ", "))
+	field("Changes by", strings.Join(details.Authors, ", "))
+	field("Causes", "")
+	for _, cause := range details.Causes {
+		output += fmt.Sprintf("  %v\n", cause)
+	}
+	output += "\nEnd of the log:\n"
+	for _, line := range details.LogLines {
+		if len(line) > maxLength {
+			line = line[:maxLength]
+		}
+		output += line + "\n"
+	}
+	output += "\n<o>: Open build   <p>: Open last completed build   <t>: Failed tests   <r>: Run job   <Enter>: Close\n"
+	return output
 }
 
-func (ui *CUIInterface) informationDialogOfTests(state *model.State) {
-	ui.gui.SetLayout(func(g *gocui.Gui) error {
+func roundDuration(duration time.Duration) time.Duration {
+	return duration - duration%time.Second
+}
+
+// testsDialog shows the list of failed tests, or the stack trace of the selected test
+func (ui *CUIInterface) testsDialog(tests []model.TestCase, visible []int, browser testBrowser, editingFilter bool) gocui.Handler {
+	return func(g *gocui.Gui) error {
 		maxX, maxY := g.Size()
-		if v, err := g.SetView("center", 2, 1, maxX-3, maxY-3); err != nil {
+		if v, err := g.SetView("tests_top", 2, 1, maxX-3, 4); err != nil {
 			checkCui(err)
-			maxLength := maxX - 6
-			output := fmt.Sprintf("Failed tests (%d of them): \n", len(state.FailedTests))
-			for _, failedTest := range state.FailedTests {
-				if len(failedTest) > maxLength {
-					output = output + fmt.Sprintf("%s\n", failedTest[len(failedTest)-maxLength:])
-				} else {
-					output = output + fmt.Sprintf("%s\n", failedTest)
+			v.FgColor = gocui.ColorWhite
+			newFailures := 0
+			for i := range tests {
+				if isNewFailure(&tests[i]) {
+					newFailures++
 				}
 			}
-			v.FgColor = gocui.ColorWhite
-			v.Overwrite = false
-			fmt.Fprintln(v, output)
+			fmt.Fprintf(v, "Failed tests (%d of %d, %d new or regressed), grouped by %v", len(visible), len(tests), newFailures, browser.grouping)
+			if editingFilter {
+				fmt.Fprintf(v, "   filter: /%v_", browser.filter)
+				v.FgColor = gocui.ColorCyan | gocui.AttrBold
+			} else if browser.filter != "" {
+				fmt.Fprintf(v, "   /%v", browser.filter)
+			}
+			if browser.showTrace {
+				fmt.Fprint(v, "\n<j/k>: Scroll   <Enter>, <Esc>: Back to the list")
+			} else {
+				fmt.Fprint(v, "\n<j/k>: Select   <Enter>: Stack trace   <G>: Grouping   </>: Filter   <Esc>: Close")
+			}
+		}
+		if browser.showTrace && browser.selected < len(visible) {
+			ui.showTestTrace(&tests[visible[browser.selected]], maxX, maxY)
+		} else {
+			ui.showTestList(tests, visible, browser, maxX, maxY)
 		}
 		return nil
-	})
+	}
+}
+
+func (ui *CUIInterface) showTestList(tests []model.TestCase, visible []int, browser testBrowser, maxX int, maxY int) {
+	v, err := ui.gui.SetView("tests", 2, 4, maxX-3, maxY-3)
+	if err == nil {
+		return
+	}
+	checkCui(err)
+	v.FgColor = gocui.ColorWhite
+	v.Highlight = true
+	v.SelBgColor = gocui.ColorBlue
+	v.SelFgColor = gocui.ColorWhite | gocui.AttrBold
+	_, height := v.Size()
+	prevGroup := ""
+	selectedLine := 0
+	line := 0
+	for i, test := range visible {
+		testCase := &tests[test]
+		if group := browser.grouping.groupOf(testCase); group != "" && (i == 0 || group != prevGroup) {
+			prevGroup = group
+			fmt.Fprintf(v, "== %v\n", group)
+			line++
+		}
+		if i == browser.selected {
+			selectedLine = line
+		}
+		fmt.Fprintf(v, "  %-34v %v\n", failureAge(testCase), browser.grouping.nameOf(testCase))
+		line++
+	}
+	origin := ui.scrollTestsTo(selectedLine, height)
+	if err := v.SetOrigin(0, origin); err != nil {
+		log.Printf("Could not scroll tests: %v", err)
+	}
+	if err := v.SetCursor(0, selectedLine-origin); err != nil {
+		log.Printf("Could not highlight selected test: %v", err)
+	}
+}
+
+func (ui *CUIInterface) showTestTrace(testCase *model.TestCase, maxX int, maxY int) {
+	v, err := ui.gui.SetView("tests_trace", 2, 4, maxX-3, maxY-3)
+	if err == nil {
+		return
+	}
+	checkCui(err)
+	v.FgColor = gocui.ColorWhite
+	v.Wrap = true
+	width, height := v.Size()
+	trace := strings.Replace(testCase.StackTrace, "\t", "    ", -1)
+	if strings.TrimSpace(trace) == "" {
+		trace = "(no stack trace)"
+	}
+	output := fmt.Sprintf("%8v: %v (%v)\n%8v: %v\n%8v: %v\n\n%v", "Status", testCase.Status, failureAge(testCase),
+		"Class", testCase.ClassName, "Test", testCase.Name, trace)
+	fmt.Fprint(v, output)
+	if width < 1 {
+		// view is too narrow to show anything, there is nothing to scroll
+		return
+	}
+	lines := 0
+	for _, line := range strings.Split(output, "\n") {
+		lines++
+		if length := len([]rune(line)); length > width {
+			lines += (length - 1) / width
+		}
+	}
+	if err := v.SetOrigin(0, ui.scrollTraceTo(lines, height)); err != nil {
+		log.Printf("Could not scroll stack trace: %v", err)
+	}
+}
+
+// scrollTestsTo moves the scrolling origin of the failed tests just enough for the given line to be visible
+func (ui *CUIInterface) scrollTestsTo(line int, height int) int {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	ui.tests.pageSize = height
+	if line < ui.tests.origin {
+		ui.tests.origin = line
+	} else if line >= ui.tests.origin+height {
+		ui.tests.origin = line - height + 1
+	}
+	return ui.tests.origin
+}
+
+// scrollTraceTo keeps the scrolling origin of the stack trace within its lines
+func (ui *CUIInterface) scrollTraceTo(lines int, height int) int {
+	ui.selectionLock.Lock()
+	defer ui.selectionLock.Unlock()
+	ui.tests.pageSize = height
+	if ui.tests.traceOrigin > lines-height {
+		ui.tests.traceOrigin = lines - height
+	}
+	if ui.tests.traceOrigin < 0 {
+		ui.tests.traceOrigin = 0
+	}
+	return ui.tests.traceOrigin
 }
 
 func (ui *CUIInterface) maxLengthOfName(state *model.State) (lengthForJobNames int) {
@@ -353,16 +1065,6 @@ func (ui *CUIInterface) maxLengthOfName(state *model.State) (lengthForJobNames i
 	return
 }
 
-func (ui *CUIInterface) countDistinctGroups(state *model.State) (distinctGroupCount int) {
-	distinctGroups := make(map[string]bool)
-	for _, jobState := range state.JobStates {
-		if jobState.Group != "" {
-			distinctGroups[jobState.Group] = true
-		}
-	}
-	return len(distinctGroups)
-}
-
 func (ui *CUIInterface) leftPad2Len(s string, padStr string, overallLen int) string {
 	var padCountInt = 1 + ((overallLen - len(padStr)) / len(padStr))
 	var retStr = strings.Repeat(padStr, padCountInt) + s
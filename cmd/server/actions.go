@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/milanaleksic/clici/jenkins"
+)
+
+const (
+	// ActionFailedTests gives the failed tests of the last failed build (or of a given build)
+	ActionFailedTests = "failedTests"
+	// ActionLastLogLines gives the end of console output of the last build (or of a given build)
+	ActionLastLogLines = "lastLogLines"
+	// ActionPreviousFailureCauses gives people who caused the previous failures (or failure of a given build)
+	ActionPreviousFailureCauses = "previousFailureCauses"
+	// ActionLastBuildURL gives the URL of the last build, to be opened in a browser
+	ActionLastBuildURL = "lastBuildURL"
+	// ActionLastCompletedBuildURL gives the URL of the last completed build, to be opened in a browser
+	ActionLastCompletedBuildURL = "lastCompletedBuildURL"
+	// ActionBuildStatus gives the status of the last build (or of a given build), for example to explain it in detail
+	ActionBuildStatus = "buildStatus"
+
+	// DefaultLogLineCount is how many log lines are given back when client doesn't ask for a specific count
+	DefaultLogLineCount = 30
+
+	lastBuild = "lastBuild"
+)
+
+// ExecuteAction calls Jenkins on behalf of a client. It is a blocking call
+func (processor *Processor) ExecuteAction(request *ActionRequest) *ActionResponse {
+	log.Printf("Executing action %v on job %v of server %v", request.Action, request.JobName, request.ServerLocation)
+	response := &ActionResponse{RequestId: request.RequestId}
+	if err := processor.checkServer(request.ServerLocation); err != nil {
+		log.Printf("Rejecting action %v: %v", request.Action, err)
+		response.Error = err.Error()
+		return response
+	}
+	api := processor.newAPI(request.ServerLocation)
+	var err error
+	switch request.Action {
+	case ActionRunJob:
+		err = api.RunJob(request.JobName)
+	case ActionFailedTests:
+		var testCases []jenkins.TestCase
+		if request.BuildId == "" {
+			testCases, err = api.GetFailedTestList(request.JobName)
+		} else {
+			testCases, err = api.GetFailedTestListFor(request.JobName, request.BuildId)
+		}
+		for _, testCase := range testCases {
+			response.TestCases = append(response.TestCases, &TestCase{
+				ClassName:       testCase.ClassName,
+				Name:            testCase.Name,
+				Status:          testCase.Status,
+				ErrorStackTrace: testCase.ErrorStackTrace,
+				Age:             int32(testCase.Age),
+				FailedSince:     int32(testCase.FailedSince),
+			})
+		}
+	case ActionLastLogLines:
+		buildID := request.BuildId
+		if buildID == "" {
+			buildID = lastBuild
+		}
+		lineCount := int(request.LineCount)
+		if lineCount == 0 {
+			lineCount = DefaultLogLineCount
+		}
+		response.Lines, err = api.GetLastLogLines(request.JobName, buildID, lineCount)
+	case ActionPreviousFailureCauses:
+		if request.BuildId == "" {
+			response.Lines = api.CausesOfPreviousFailures(request.JobName)
+		} else {
+			response.Lines = api.CausesOfFailures(request.JobName, request.BuildId)
+		}
+	case ActionLastBuildURL:
+		response.Url = api.GetLastBuildURLForJob(request.JobName)
+	case ActionLastCompletedBuildURL:
+		response.Url = api.GetLastCompletedBuildURLForJob(request.JobName)
+	case ActionBuildStatus:
+		buildID := request.BuildId
+		if buildID == "" {
+			buildID = lastBuild
+		}
+		var status *jenkins.JobStatus
+		if status, err = api.GetStatusForJob(request.JobName, buildID); err == nil {
+			response.Status = toWireBuildStatus(status)
+		}
+	default:
+		err = fmt.Errorf("unknown action %v", request.Action)
+	}
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Success = true
+	}
+	return response
+}
+
+// checkAction gives back an error if the policy doesn't allow the action
+func (policy *TokenPolicy) checkAction(request *ActionRequest) error {
+	if !policy.allowsJob(request.ServerLocation, request.JobName) {
+		return fmt.Errorf("not allowed to access job %v on %v", request.JobName, request.ServerLocation)
+	}
+	if request.Action == ActionRunJob && !policy.allowsAction(ActionRunJob) {
+		return fmt.Errorf("not allowed to run job %v on %v", request.JobName, request.ServerLocation)
+	}
+	return nil
+}
+
+func toWireBuildStatus(status *jenkins.JobStatus) *BuildStatus {
+	result := &BuildStatus{
+		Id:                status.ID,
+		Result:            status.Result,
+		Building:          status.Building,
+		Timestamp:         status.Timestamp,
+		Duration:          status.Duration,
+		EstimatedDuration: status.EstimatedDuration,
+	}
+	for _, culprit := range status.Culprits {
+		result.Culprits = append(result.Culprits, culprit.FullName)
+	}
+	for _, changeSet := range status.ChangeSets {
+		for _, item := range changeSet.Items {
+			result.Authors = append(result.Authors, item.Author.FullName)
+		}
+	}
+	for _, action := range status.Actions {
+		for _, cause := range action.Causes {
+			result.Causes = append(result.Causes, &Cause{
+				UserId:           cause.UserID,
+				ShortDescription: cause.ShortDescription,
+				UpstreamProject:  cause.UpstreamProject,
+				UpstreamBuild:    int32(cause.UpstreamBuild),
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestActionsAreRelayedToJenkins(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		response := sendAction(t, wire, &ActionRequest{
+			RequestId:      7,
+			Action:         ActionLastLogLines,
+			ServerLocation: "jenkins1",
+			JobName:        "job1",
+		})
+		if !response.Success || response.RequestId != 7 {
+			t.Fatalf("action failed: %v", response)
+		}
+		if len(response.Lines) != 2 || response.Lines[0] != "line1" {
+			t.Fatalf("expected log lines from Jenkins, got %v", response.Lines)
+		}
+
+		response = sendAction(t, wire, &ActionRequest{
+			RequestId:      8,
+			Action:         ActionFailedTests,
+			ServerLocation: "jenkins1",
+			JobName:        "job1",
+		})
+		if !response.Success || response.RequestId != 8 {
+			t.Fatalf("expected failed tests, got %v", response)
+		}
+		if len(response.TestCases) != 1 || response.TestCases[0].Age != 3 || response.TestCases[0].FailedSince != 40 {
+			t.Fatalf("expected failed tests with age of the failure, got %v", response.TestCases)
+		}
+
+		response = sendAction(t, wire, &ActionRequest{
+			RequestId:      10,
+			Action:         ActionBuildStatus,
+			ServerLocation: "jenkins1",
+			JobName:        "job1",
+		})
+		if !response.Success || response.GetStatus() == nil || response.GetStatus().Timestamp == 0 {
+			t.Fatalf("expected status of the last build, got %v", response)
+		}
+
+		response = sendAction(t, wire, &ActionRequest{RequestId: 9, Action: "unknown", ServerLocation: "jenkins1"})
+		if response.Success || !strings.Contains(response.Error, "unknown action") {
+			t.Fatalf("unknown action must fail, got %v", response)
+		}
+	})
+}
+
+func TestRunJobRequiresPermission(t *testing.T) {
+	withRunningConfiguredServer(t, withTestConfiguration, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		runJob := &ActionRequest{
+			Action:         ActionRunJob,
+			ServerLocation: "jenkins1",
+			JobName:        "job1",
+		}
+		if response := sendAction(t, wire, runJob); response.Success || response.Error != "authentication required" {
+			t.Fatalf("unauthenticated client must not run jobs, got %v", response)
+		}
+
+		sendAndExpectSuccess(t, wire, &ClientMessage{Authenticate: &Authenticate{Token: "viewer-token"}})
+		if response := sendAction(t, wire, runJob); response.Success || !strings.Contains(response.Error, "not allowed to run") {
+			t.Fatalf("viewer must not run jobs, got %v", response)
+		}
+		runJob.Action = ActionLastLogLines
+		if response := sendAction(t, wire, runJob); !response.Success {
+			t.Fatalf("viewer must be able to read logs, got %v", response)
+		}
+	})
+}
+
+func TestAnonymousClientsAreReadOnly(t *testing.T) {
+	runJob := &ActionRequest{
+		Action:         ActionRunJob,
+		ServerLocation: "jenkins1",
+		JobName:        "job1",
+	}
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		if response := sendAction(t, wire, runJob); response.Success || !strings.Contains(response.Error, "not allowed to run") {
+			t.Fatalf("anonymous client must not run jobs, got %v", response)
+		}
+	})
+	enableRunJob := func(clici *CliciServer) {
+		clici.Configure(&Configuration{AnonymousActions: []string{ActionRunJob}})
+	}
+	withRunningConfiguredServer(t, enableRunJob, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		if response := sendAction(t, wire, runJob); !response.Success {
+			t.Fatalf("anonymous client must run jobs when enabled in configuration, got %v", response)
+		}
+	})
+}
+
+func sendAction(t *testing.T, wire *LengthEncodedProtoReaderWriter, request *ActionRequest) *ActionResponse {
+	if err := wire.WriteProto(&ClientMessage{ActionRequest: request}); err != nil {
+		t.Fatalf("request failed while writing: %v", err)
+	}
+	for {
+		message := ServerMessage{}
+		if err := wire.ReadProto(&message); err != nil {
+			t.Fatalf("request failed with error: %v", err)
+		}
+		if response := message.GetActionResponse(); response != nil {
+			return response
+		}
+	}
+}
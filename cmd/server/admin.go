@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// ShutdownPath on the admin listener gracefully stops the server when called with POST
+	ShutdownPath = "/shutdown"
+	// DefaultAdminAddress is where admin endpoints are served if nothing else is set.
+	// Port is chosen by the system, see AdminAddr
+	DefaultAdminAddress = "localhost:0"
+	// GoodbyeTimeout is how long shutdown waits for the clients to receive the Goodbye message
+	GoodbyeTimeout = 5 * time.Second
+
+	goodbyeReason = "server is shutting down"
+	unixPrefix    = "unix:"
+)
+
+// listenAdmin opens the admin listener on a TCP address, or on a unix socket if address starts with "unix:"
+func listenAdmin(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		socket := strings.TrimPrefix(address, unixPrefix)
+		// socket left behind by a server that didn't stop gracefully would prevent listening
+		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", socket)
+	}
+	return net.Listen("tcp", address)
+}
+
+// AdminAddr gives the address admin endpoints are served on, once the server is started
+func (h *CliciServer) AdminAddr() net.Addr {
+	return h.adminLis.Addr()
+}
+
+func (h *CliciServer) serveAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ShutdownPath, h.shutdownHandler)
+	mux.HandleFunc(HealthPath, h.healthHandler)
+	mux.HandleFunc(RegistrationsPath, h.registrationsHandler)
+	mux.HandleFunc(MetricsPath, h.metricsHandler)
+	log.Printf("Admin endpoints are available on %v", h.adminLis.Addr())
+	if err := http.Serve(h.adminLis, mux); err != nil && !h.closedGracefully {
+		log.Fatalf("Could not serve admin endpoints: %v", err)
+	}
+}
+
+func (h *CliciServer) shutdownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	h.shutdown.Do(func() {
+		h.closedGracefully = true
+		close(h.stopPolling)
+		log.Println("Saying goodbye to the clients")
+		h.waitForClientsToLeave(GoodbyeTimeout)
+		log.Println("Closing the listener")
+		if err := h.lis.Close(); err != nil {
+			log.Fatalf("Not able to shutdown server gracefully, %v", err)
+		}
+	})
+	if _, err := w.Write([]byte(ClosingSuccess)); err != nil {
+		log.Printf("Not able to send ClosingSuccess message to the client, %v", err)
+	}
+	if err := h.adminLis.Close(); err != nil {
+		log.Printf("Not able to close admin listener, %v", err)
+	}
+}
+
+// waitForClientsToLeave waits until all clients got their Goodbye and disconnected, or until timeout
+func (h *CliciServer) waitForClientsToLeave(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for h.processor.ConnectedClients() > 0 {
+		if time.Now().After(deadline) {
+			log.Printf("%d clients are still connected, closing anyway", h.processor.ConnectedClients())
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
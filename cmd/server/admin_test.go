@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestClientsReceiveGoodbyeOnShutdown(t *testing.T) {
+	handler := startServer(t, func(clici *CliciServer) {})
+	ws := dial(t, handler.Port)
+	defer func() {
+		_ = ws.Close()
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		stopServer(t, handler)
+		close(stopped)
+	}()
+
+	wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+	for {
+		message := ServerMessage{}
+		if err := wire.ReadProto(&message); err != nil {
+			t.Fatalf("connection closed without goodbye: %v", err)
+		}
+		if goodbye := message.GetGoodbye(); goodbye != nil {
+			if goodbye.Reason != goodbyeReason {
+				t.Fatalf("unexpected goodbye reason: %v", goodbye.Reason)
+			}
+			break
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(GoodbyeTimeout):
+		t.Fatal("server should stop as soon as all clients are gone")
+	}
+}
+
+func TestShutdownIsNotAvailableToClients(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		resp, err := noKeepAliveClient.Post(fmt.Sprintf("http://localhost:%d%s", clici.Port, ShutdownPath), "text/plain", nil)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("shutdown must be served only on the admin listener, got status %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestAdminOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clici")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socket := filepath.Join(dir, "admin.sock")
+
+	handler := startServer(t, func(clici *CliciServer) {
+		clici.AdminAddress = "unix:" + socket
+	})
+	unixClient := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	resp, err := unixClient.Post("http://admin"+ShutdownPath, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("could not shutdown through unix socket: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != ClosingSuccess {
+		t.Fatalf("graceful server shutdown failed: %v", string(body))
+	}
+	if handler.AdminAddr().Network() != "unix" {
+		t.Fatalf("admin should listen on unix socket, got %v", handler.AdminAddr())
+	}
+}
+
+func TestServingOverTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clici")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	certificate := writeSelfSignedCertificate(t, dir)
+
+	handler := startServer(t, func(clici *CliciServer) {
+		clici.CertFile = filepath.Join(dir, "cert.pem")
+		clici.KeyFile = filepath.Join(dir, "key.pem")
+	})
+	defer stopServer(t, handler)
+
+	config, err := websocket.NewConfig(fmt.Sprintf("wss://localhost:%d/ws", handler.Port), "https://ignored/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	config.TlsConfig = &tls.Config{RootCAs: roots}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("could not connect over TLS: %v", err)
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	if response := handshake(t, ws, ProtocolVersion); !response.Accepted {
+		t.Fatalf("handshake over TLS failed: %v", response.Error)
+	}
+}
+
+// writeSelfSignedCertificate writes cert.pem and key.pem valid for localhost into the directory
+func writeSelfSignedCertificate(t *testing.T, dir string) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"path"
+)
+
+const (
+	// ActionRunJob is the permission needed for a client to start a Jenkins job via server
+	ActionRunJob = "runJob"
+)
+
+// TokenPolicy defines what a client identified by Token is allowed to see and do
+type TokenPolicy struct {
+	// Token is the static secret a client needs to send in Authenticate message
+	Token string `json:"token"`
+	// Name identifies the token owner in logs
+	Name string `json:"name"`
+	// Servers are the Jenkins servers this token can register for; all servers are allowed if empty
+	Servers []string `json:"servers"`
+	// Jobs are job names or globs (as understood by path.Match) this token can register for; all jobs are allowed if empty
+	Jobs []string `json:"jobs"`
+	// Actions are relayed actions this token can execute, like ActionRunJob
+	Actions []string `json:"actions"`
+}
+
+// newAnonymousPolicy makes the policy used for all clients when server has no tokens configured.
+// Anonymous clients see all jobs, but can execute only the explicitly enabled actions
+func newAnonymousPolicy(actions []string) *TokenPolicy {
+	return &TokenPolicy{Name: "anonymous", Actions: actions}
+}
+
+func (policy *TokenPolicy) allowsServer(server string) bool {
+	if len(policy.Servers) == 0 {
+		return true
+	}
+	for _, allowed := range policy.Servers {
+		if allowed == server {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *TokenPolicy) allowsJob(server string, jobName string) bool {
+	if !policy.allowsServer(server) {
+		return false
+	}
+	if len(policy.Jobs) == 0 {
+		return true
+	}
+	for _, allowed := range policy.Jobs {
+		if matched, err := path.Match(allowed, jobName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPattern is conservative: since it is not known which jobs a glob will match in the future,
+// pattern is allowed only if the token can see all jobs or if the same glob is explicitly allowed
+func (policy *TokenPolicy) allowsPattern(server string, jobGlob string) bool {
+	if !policy.allowsServer(server) {
+		return false
+	}
+	if len(policy.Jobs) == 0 {
+		return true
+	}
+	for _, allowed := range policy.Jobs {
+		if allowed == jobGlob {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *TokenPolicy) allowsAction(action string) bool {
+	for _, allowed := range policy.Actions {
+		if allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubscriptions gives back an error for the first job or pattern this policy doesn't allow
+func (policy *TokenPolicy) checkSubscriptions(jobs []*Register_Job, patterns []*Register_Pattern) error {
+	for _, job := range jobs {
+		if !policy.allowsJob(job.ServerLocation, job.JobName) {
+			return fmt.Errorf("not allowed to register for job %v on %v", job.JobName, job.ServerLocation)
+		}
+	}
+	for _, pattern := range patterns {
+		if !policy.allowsPattern(pattern.ServerLocation, pattern.JobGlob) {
+			return fmt.Errorf("not allowed to register for pattern %v on %v", pattern.JobGlob, pattern.ServerLocation)
+		}
+	}
+	return nil
+}
+
+// authenticator knows all static tokens. When no tokens are known, everybody is anonymous
+type authenticator struct {
+	policies  map[string]*TokenPolicy
+	anonymous *TokenPolicy
+}
+
+func newAuthenticator(policies []TokenPolicy, anonymousActions []string) *authenticator {
+	auth := &authenticator{
+		policies:  make(map[string]*TokenPolicy),
+		anonymous: newAnonymousPolicy(anonymousActions),
+	}
+	for i := range policies {
+		auth.policies[policies[i].Token] = &policies[i]
+	}
+	if !auth.enabled() {
+		log.Println("No client tokens configured, authentication is disabled")
+	}
+	return auth
+}
+
+func (auth *authenticator) enabled() bool {
+	return len(auth.policies) != 0
+}
+
+func (auth *authenticator) authenticate(token string) (*TokenPolicy, error) {
+	if !auth.enabled() {
+		return auth.anonymous, nil
+	}
+	if policy, ok := auth.policies[token]; ok && token != "" {
+		return policy, nil
+	}
+	return nil, fmt.Errorf("invalid token")
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/milanaleksic/clici/jenkins"
+	"golang.org/x/net/websocket"
+)
+
+var testConfiguration = &Configuration{
+	Tokens: []TokenPolicy{
+		{
+			Token:   "viewer-token",
+			Name:    "viewer",
+			Servers: []string{"jenkins1"},
+			Jobs:    []string{"job*"},
+		},
+		{
+			Token:   "admin-token",
+			Name:    "admin",
+			Actions: []string{ActionRunJob},
+		},
+	},
+	Jenkins: []JenkinsCredentials{
+		{Location: "jenkins1", Username: "clici", Password: "secret"},
+	},
+}
+
+func TestTokenPolicy(t *testing.T) {
+	viewer := &testConfiguration.Tokens[0]
+	admin := &testConfiguration.Tokens[1]
+	if !viewer.allowsJob("jenkins1", "job1") {
+		t.Fatal("viewer must see job1 on jenkins1")
+	}
+	if viewer.allowsJob("jenkins2", "job1") || viewer.allowsJob("jenkins1", "deploy") {
+		t.Fatal("viewer must not see jobs outside of its servers and jobs")
+	}
+	if !viewer.allowsPattern("jenkins1", "job*") || viewer.allowsPattern("jenkins1", "*") {
+		t.Fatal("viewer must be able to register only for the explicitly allowed pattern")
+	}
+	if viewer.allowsAction(ActionRunJob) || !admin.allowsAction(ActionRunJob) {
+		t.Fatal("only admin may run jobs")
+	}
+	if anonymous := newAnonymousPolicy(nil); anonymous.allowsAction(ActionRunJob) || !anonymous.allowsJob("any", "job") {
+		t.Fatal("anonymous policy (no tokens configured) must see all jobs, but not run them")
+	}
+	if !newAnonymousPolicy([]string{ActionRunJob}).allowsAction(ActionRunJob) {
+		t.Fatal("anonymous policy must allow explicitly enabled actions")
+	}
+}
+
+func TestAuthenticationRequiredWhenTokensConfigured(t *testing.T) {
+	withRunningConfiguredServer(t, withTestConfiguration, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		register := &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+			},
+		}
+		if errorMessage := sendAndExpectFailure(t, wire, register); errorMessage != "authentication required" {
+			t.Fatalf("Unauthenticated registration must be rejected, got: %v", errorMessage)
+		}
+		if errorMessage := sendAndExpectFailure(t, wire, &ClientMessage{Authenticate: &Authenticate{Token: "wrong"}}); errorMessage != "invalid token" {
+			t.Fatalf("Invalid token must be rejected, got: %v", errorMessage)
+		}
+
+		register.Authenticate = &Authenticate{Token: "viewer-token"}
+		sendAndExpectSuccess(t, wire, register)
+
+		errorMessage := sendAndExpectFailure(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins2", JobName: "job1"}},
+			},
+		})
+		if !strings.Contains(errorMessage, "not allowed") {
+			t.Fatalf("Registration outside of token policy must be rejected, got: %v", errorMessage)
+		}
+	})
+}
+
+func TestUnconfiguredJenkinsServersAreRejected(t *testing.T) {
+	unconfigured := "http://169.254.169.254/latest"
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		errorMessage := sendAndExpectFailure(t, wire, &ClientMessage{
+			Register: &Register{
+				Patterns: []*Register_Pattern{{ServerLocation: unconfigured, JobGlob: "*"}},
+			},
+		})
+		if !strings.Contains(errorMessage, "not configured") {
+			t.Fatalf("Registration for unconfigured server must be rejected, got: %v", errorMessage)
+		}
+		errorMessage = sendAndExpectFailure(t, wire, &ClientMessage{
+			ReplaceSubscriptions: &ReplaceSubscriptions{
+				Jobs: []*Register_Job{{ServerLocation: unconfigured, JobName: "job1"}},
+			},
+		})
+		if !strings.Contains(errorMessage, "not configured") {
+			t.Fatalf("Replacing subscriptions with unconfigured server must be rejected, got: %v", errorMessage)
+		}
+		response := sendAction(t, wire, &ActionRequest{Action: ActionLastLogLines, ServerLocation: unconfigured, JobName: "job1"})
+		if response.Success || !strings.Contains(response.Error, "not configured") {
+			t.Fatalf("Action on unconfigured server must be rejected, got: %v", response)
+		}
+
+		eventsURL := fmt.Sprintf("http://localhost:%d%s?server=%s", clici.Port, EventsPath, url.QueryEscape(unconfigured))
+		resp, err := http.Get(eventsURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Events of unconfigured server must be rejected, got: %v", resp.Status)
+		}
+		if registrations := clici.processor.registrationsPerServer(); len(registrations) != 0 {
+			t.Fatalf("Nobody should be registered, got: %v", registrations)
+		}
+	})
+}
+
+func TestServerUsesConfiguredJenkinsCredentials(t *testing.T) {
+	var username, password string
+	withRunningConfiguredServer(t, withTestConfiguration, func(clici *CliciServer, ws *websocket.Conn) {
+		clici.processor.apiSupplier = func(serverLocation string, user, pass string) jenkins.API {
+			username, password = user, pass
+			return &testAPI{color: "blue"}
+		}
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Authenticate: &Authenticate{Token: "admin-token"},
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+			},
+		})
+		clici.processor.ProcessMappings()
+	})
+	if username != "clici" || password != "secret" {
+		t.Fatalf("Jenkins credentials from configuration not used, got %v/%v", username, password)
+	}
+}
+
+func TestConfigurationFileIsLoadedOnStart(t *testing.T) {
+	file, err := ioutil.TempFile("", "clici-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	if err := json.NewEncoder(file).Encode(testConfiguration); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	withRunningConfiguredServer(t, func(clici *CliciServer) { clici.ConfigurationFile = file.Name() }, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		register := &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+			},
+		}
+		if errorMessage := sendAndExpectFailure(t, wire, register); errorMessage != "authentication required" {
+			t.Fatalf("Tokens from the configuration file must be required, got: %v", errorMessage)
+		}
+		register.Authenticate = &Authenticate{Token: "viewer-token"}
+		sendAndExpectSuccess(t, wire, register)
+	})
+}
+
+func withTestConfiguration(clici *CliciServer) {
+	clici.Configure(testConfiguration)
+}
+
+func sendAndExpectFailure(t *testing.T, wire *LengthEncodedProtoReaderWriter, request *ClientMessage) string {
+	if err := wire.WriteProto(request); err != nil {
+		t.Fatalf("request failed while writing: %v", err)
+	}
+	for {
+		message := ServerMessage{}
+		if err := wire.ReadProto(&message); err != nil {
+			t.Fatalf("request failed with error: %v", err)
+		}
+		if response := message.GetRegisterResponse(); response == nil {
+			continue
+		} else if response.Success {
+			t.Fatal("request should have failed")
+		} else {
+			return response.Error
+		}
+	}
+}
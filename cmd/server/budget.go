@@ -0,0 +1,93 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRequestsPerSecond is how many requests are sent to a single Jenkins server per second, if not configured
+	DefaultRequestsPerSecond = 5.0
+	// DefaultMaxInFlight is how many requests can wait for an answer of a single Jenkins server, if not configured
+	DefaultMaxInFlight = 2
+)
+
+// RequestBudget limits the load server puts on a single Jenkins server, shared by polling, webhook refreshes
+// and relayed actions
+type RequestBudget struct {
+	Location string `json:"location"`
+	// RequestsPerSecond is the sustained rate of requests, short bursts of up to one second worth of requests are allowed.
+	// Negative value means unlimited
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	// MaxInFlight is the maximum number of concurrent requests. Negative value means unlimited
+	MaxInFlight int `json:"maxInFlight"`
+}
+
+// requestBudget is a token bucket combined with a semaphore
+type requestBudget struct {
+	sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	inFlight chan struct{}
+}
+
+func newRequestBudget(config RequestBudget) *requestBudget {
+	if config.RequestsPerSecond == 0 {
+		config.RequestsPerSecond = DefaultRequestsPerSecond
+	}
+	if config.MaxInFlight == 0 {
+		config.MaxInFlight = DefaultMaxInFlight
+	}
+	budget := &requestBudget{last: time.Now()}
+	if config.RequestsPerSecond > 0 {
+		budget.rate = config.RequestsPerSecond
+		budget.burst = math.Max(1, math.Ceil(config.RequestsPerSecond))
+		budget.tokens = budget.burst
+	}
+	if config.MaxInFlight > 0 {
+		budget.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	return budget
+}
+
+// take blocks until a request can be sent. It tells if the budget was exhausted, so the request had to wait
+func (budget *requestBudget) take() (exhausted bool) {
+	if budget.inFlight != nil {
+		select {
+		case budget.inFlight <- struct{}{}:
+		default:
+			exhausted = true
+			budget.inFlight <- struct{}{}
+		}
+	}
+	if budget.rate == 0 {
+		return
+	}
+	budget.Lock()
+	now := time.Now()
+	budget.tokens = math.Min(budget.burst, budget.tokens+now.Sub(budget.last).Seconds()*budget.rate)
+	budget.last = now
+	budget.tokens--
+	delay := time.Duration(-budget.tokens / budget.rate * float64(time.Second))
+	budget.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+		exhausted = true
+	}
+	return
+}
+
+// release must be called once the answer for a request taken from the budget is received
+func (budget *requestBudget) release() {
+	if budget.inFlight != nil {
+		<-budget.inFlight
+	}
+}
+
+// current gives the number of requests currently waiting for an answer
+func (budget *requestBudget) current() int {
+	return len(budget.inFlight)
+}
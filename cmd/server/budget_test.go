@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetLimitsRate(t *testing.T) {
+	budget := newRequestBudget(RequestBudget{RequestsPerSecond: 20, MaxInFlight: -1})
+	started := time.Now()
+	exhausted := false
+	for i := 0; i < 25; i++ {
+		if budget.take() {
+			exhausted = true
+		}
+		budget.release()
+	}
+	if elapsed := time.Since(started); elapsed < 200*time.Millisecond {
+		t.Fatalf("25 requests with 20 per second and a burst of 20 should take at least 250ms, took %v", elapsed)
+	}
+	if !exhausted {
+		t.Fatal("budget should have been reported as exhausted")
+	}
+}
+
+func TestBudgetLimitsRequestsInFlight(t *testing.T) {
+	budget := newRequestBudget(RequestBudget{RequestsPerSecond: -1, MaxInFlight: 1})
+	if budget.take() {
+		t.Fatal("first request should not wait")
+	}
+	taken := make(chan bool)
+	go func() {
+		taken <- budget.take()
+	}()
+	select {
+	case <-taken:
+		t.Fatal("second request must wait until the first one is answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	budget.release()
+	if exhausted := <-taken; !exhausted {
+		t.Fatal("second request should have been reported as waiting")
+	}
+}
+
+func TestJobsRegisteredByManyClientsArePolledOnce(t *testing.T) {
+	mapping := NewMapping()
+	mapping.RegisterClient("conn1", registration{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "job1"})
+	mapping.RegisterClient("conn2", registration{ConnectionID: "conn2", ServerLocation: "jenkins", JobName: "job1"})
+	mapping.RegisterPattern("conn1", patternRegistration{ConnectionID: "conn1", ServerLocation: "jenkins", JobGlob: "deploy-*"})
+	mapping.RegisterPattern("conn2", patternRegistration{ConnectionID: "conn2", ServerLocation: "jenkins", JobGlob: "deploy-*"})
+
+	if jobs := mapping.GetAllUniqueJobs()["jenkins"]; len(jobs) != 1 {
+		t.Fatalf("job1 should be listed once, got %v", jobs)
+	}
+	if patterns := mapping.GetAllPatterns()["jenkins"]; len(patterns) != 1 {
+		t.Fatalf("deploy-* should be listed once, got %v", patterns)
+	}
+}
@@ -13,11 +13,13 @@ import (
 const (
 	// MaxAllowedSize is maximum allowed size for the incoming message (not counting 4 bytes for length encoding)
 	MaxAllowedSize = 1024 * 1024
+	sizeOfLength   = 4
 )
 
 // LengthEncodedProtoReaderWriter is a writer/reader that wraps length-encoded protobuff stream.
 // This kind of stream has 2-part communication: first a length is sent as littlendian 4-byte integer
-// and then the protobuff message is sent of that length
+// and then the protobuff message is sent of that length. Reads are exact: the underlying reader is read
+// until the whole declared length arrives, no matter in how many parts it comes
 type LengthEncodedProtoReaderWriter struct {
 	UnderlyingReadWriter io.ReadWriteCloser
 	readBuffer           []byte
@@ -33,6 +35,10 @@ func (lep *LengthEncodedProtoReaderWriter) readSize() (size int, err error) {
 		err = fmt.Errorf("Failure reading length: %v", err)
 		return
 	}
+	if preciseSize < 0 || preciseSize > MaxAllowedSize {
+		err = fmt.Errorf("Encoded size waiting on channel not allowed: %v", preciseSize)
+		return
+	}
 	size = int(preciseSize)
 	return
 }
@@ -46,31 +52,34 @@ func (lep *LengthEncodedProtoReaderWriter) Read(data []byte) (n int, err error)
 		err = fmt.Errorf("Provided slice too small. %v is the size of data, only %v provided", size, len(data))
 		return
 	}
-	n, err = lep.UnderlyingReadWriter.Read(data)
-	if err != nil {
-		return
-	}
-	return
+	return io.ReadFull(lep.UnderlyingReadWriter, data[:size])
 }
 
-// ReadProto method allows direct reading of a protobuff object, with length as a prefix
-func (lep *LengthEncodedProtoReaderWriter) ReadProto(msg proto.Message) (err error) {
+// ReadMessage reads the next message without unmarshalling it, for when it is not known which protobuff
+// object was sent. Returned slice is valid only until the next read
+func (lep *LengthEncodedProtoReaderWriter) ReadMessage() (data []byte, err error) {
 	size, err := lep.readSize()
 	if err != nil {
 		return
 	}
-	if size > MaxAllowedSize {
-		err = fmt.Errorf("Encoded size waiting on channel too big: %v", size)
-		return
-	} else if size > len(lep.readBuffer) {
+	if size > len(lep.readBuffer) {
 		lep.readBuffer = make([]byte, size)
 		log.Printf("Buffer resized to: %v", size)
 	}
-	n, err := lep.UnderlyingReadWriter.Read(lep.readBuffer)
+	if _, err = io.ReadFull(lep.UnderlyingReadWriter, lep.readBuffer[:size]); err != nil {
+		err = fmt.Errorf("Failure reading message of size %v: %v", size, err)
+		return
+	}
+	return lep.readBuffer[:size], nil
+}
+
+// ReadProto method allows direct reading of a protobuff object, with length as a prefix
+func (lep *LengthEncodedProtoReaderWriter) ReadProto(msg proto.Message) (err error) {
+	data, err := lep.ReadMessage()
 	if err != nil {
 		return
 	}
-	err = proto.Unmarshal(lep.readBuffer[:n], msg)
+	err = proto.Unmarshal(data, msg)
 	if err != nil {
 		err = fmt.Errorf("Could not unmarshal message: %v", err)
 		return
@@ -78,15 +87,22 @@ func (lep *LengthEncodedProtoReaderWriter) ReadProto(msg proto.Message) (err err
 	return
 }
 
+// Write sends the length and the data in a single write to the underlying writer,
+// so message-oriented transports (like websocket) don't split them
 func (lep *LengthEncodedProtoReaderWriter) Write(data []byte) (n int, err error) {
-	var size int32
-	size = int32(len(data))
-	err = binary.Write(lep.UnderlyingReadWriter, binary.LittleEndian, size)
+	if len(data) > MaxAllowedSize {
+		err = fmt.Errorf("Message too big to be sent: %v", len(data))
+		return
+	}
+	frame := make([]byte, sizeOfLength, sizeOfLength+len(data))
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+	n, err = lep.UnderlyingReadWriter.Write(frame)
 	if err != nil {
-		err = fmt.Errorf("write bytes for length encoding failed: %v", err)
+		err = fmt.Errorf("write of length encoded message failed: %v", err)
 		return
 	}
-	return lep.UnderlyingReadWriter.Write(data)
+	return n - sizeOfLength, nil
 }
 
 // WriteProto method allows direct writing of a protobuff object, with length as a prefix
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// chunkingReadWriteCloser gives back at most chunkSize bytes on each read,
+// like a network connection can when message arrives in multiple packets
+type chunkingReadWriteCloser struct {
+	bytes.Buffer
+	chunkSize int
+}
+
+func (c *chunkingReadWriteCloser) Read(data []byte) (int, error) {
+	if len(data) > c.chunkSize {
+		data = data[:c.chunkSize]
+	}
+	return c.Buffer.Read(data)
+}
+
+func (c *chunkingReadWriteCloser) Close() error {
+	return nil
+}
+
+func TestReadingMessageArrivingInParts(t *testing.T) {
+	underlying := &chunkingReadWriteCloser{chunkSize: 3}
+	wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: underlying}
+	big := &ClientMessage{Authenticate: &Authenticate{Token: strings.Repeat("x", 100)}}
+	small := &ClientMessage{Authenticate: &Authenticate{Token: "y"}}
+	if err := wire.WriteProto(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := wire.WriteProto(small); err != nil {
+		t.Fatal(err)
+	}
+
+	received := &ClientMessage{}
+	if err := wire.ReadProto(received); err != nil {
+		t.Fatalf("could not read message arriving in parts: %v", err)
+	}
+	if received.GetAuthenticate().Token != big.Authenticate.Token {
+		t.Errorf("unexpected message: %v", received)
+	}
+	received = &ClientMessage{}
+	if err := wire.ReadProto(received); err != nil {
+		t.Fatalf("could not read smaller message after a bigger one: %v", err)
+	}
+	if received.GetAuthenticate().Token != small.Authenticate.Token {
+		t.Errorf("unexpected message after a bigger one: %v", received)
+	}
+}
+
+func TestInvalidSizesAreRejected(t *testing.T) {
+	for _, size := range []int32{-1, MaxAllowedSize + 1} {
+		underlying := &chunkingReadWriteCloser{chunkSize: 1024}
+		if err := binary.Write(underlying, binary.LittleEndian, size); err != nil {
+			t.Fatal(err)
+		}
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: underlying}
+		if err := wire.ReadProto(&ClientMessage{}); err == nil {
+			t.Errorf("size %d should have been rejected", size)
+		}
+	}
+
+	wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: &chunkingReadWriteCloser{chunkSize: 1024}}
+	if _, err := wire.Write(make([]byte, MaxAllowedSize+1)); err == nil {
+		t.Error("too big message should not be sent")
+	}
+}
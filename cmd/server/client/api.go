@@ -0,0 +1,161 @@
+package client
+
+import (
+	"errors"
+	"log"
+
+	"github.com/milanaleksic/clici/cmd/server"
+	"github.com/milanaleksic/clici/jenkins"
+)
+
+var errNotRelayed = errors.New("not available through Clici server, job states are pushed by the server")
+
+// RemoteAPI is a jenkins.API which executes all calls on the Clici server, using server's credentials.
+// Listing of all jobs is not relayed since the server pushes job states to the client anyway
+type RemoteAPI struct {
+	client         *Client
+	serverLocation string
+}
+
+// API gives the jenkins.API of a Jenkins server, as seen through the Clici server
+func (c *Client) API(serverLocation string) jenkins.API {
+	return &RemoteAPI{
+		client:         c,
+		serverLocation: serverLocation,
+	}
+}
+
+func (api *RemoteAPI) execute(action, job, buildID string, lineCount int) (*server.ActionResponse, error) {
+	response, err := api.client.Execute(&server.ActionRequest{
+		Action:         action,
+		ServerLocation: api.serverLocation,
+		JobName:        job,
+		BuildId:        buildID,
+		LineCount:      uint32(lineCount),
+	})
+	if err != nil {
+		log.Printf("Action %v on job %v failed: %v", action, job, err)
+	}
+	return response, err
+}
+
+// GetKnownJobs is not relayed
+func (api *RemoteAPI) GetKnownJobs() (*jenkins.Status, error) {
+	return nil, errNotRelayed
+}
+
+// GetCurrentStatus asks the server for the status of the last build
+func (api *RemoteAPI) GetCurrentStatus(job string) (*jenkins.JobStatus, error) {
+	return api.GetStatusForJob(job, "")
+}
+
+// GetStatusForJob asks the server for the status of a certain build
+func (api *RemoteAPI) GetStatusForJob(job string, jobID string) (*jenkins.JobStatus, error) {
+	response, err := api.execute(server.ActionBuildStatus, job, jobID, 0)
+	if err != nil {
+		return nil, err
+	}
+	status := response.GetStatus()
+	if status == nil {
+		return nil, errors.New("server did not give back the build status")
+	}
+	result := &jenkins.JobStatus{
+		ID:                status.Id,
+		Result:            status.Result,
+		Building:          status.Building,
+		Timestamp:         status.Timestamp,
+		Duration:          status.Duration,
+		EstimatedDuration: status.EstimatedDuration,
+		Actions:           []jenkins.Action{{}},
+		ChangeSets:        []jenkins.ChangeSet{{}},
+	}
+	for _, culprit := range status.Culprits {
+		result.Culprits = append(result.Culprits, jenkins.Culprit{FullName: culprit})
+	}
+	for _, author := range status.Authors {
+		result.ChangeSets[0].Items = append(result.ChangeSets[0].Items, jenkins.ChangeSetItem{Author: jenkins.Culprit{FullName: author}})
+	}
+	for _, cause := range status.Causes {
+		result.Actions[0].Causes = append(result.Actions[0].Causes, jenkins.Cause{
+			UserID:           cause.UserId,
+			ShortDescription: cause.ShortDescription,
+			UpstreamProject:  cause.UpstreamProject,
+			UpstreamBuild:    int(cause.UpstreamBuild),
+		})
+	}
+	return result, nil
+}
+
+// Causes is not relayed, causes are part of the pushed job states
+func (api *RemoteAPI) Causes(status *jenkins.JobStatus) []string {
+	return nil
+}
+
+// CausesOfFailures asks the server who caused failure of a certain build
+func (api *RemoteAPI) CausesOfFailures(name, id string) []string {
+	if response, err := api.execute(server.ActionPreviousFailureCauses, name, id, 0); err == nil {
+		return response.Lines
+	}
+	return nil
+}
+
+// CausesOfPreviousFailures asks the server who caused the previous failures of a job
+func (api *RemoteAPI) CausesOfPreviousFailures(job string) []string {
+	return api.CausesOfFailures(job, "")
+}
+
+// GetLastBuildURLForJob asks the server for the URL of the last build
+func (api *RemoteAPI) GetLastBuildURLForJob(job string) string {
+	if response, err := api.execute(server.ActionLastBuildURL, job, "", 0); err == nil {
+		return response.Url
+	}
+	return ""
+}
+
+// GetLastCompletedBuildURLForJob asks the server for the URL of the last completed build
+func (api *RemoteAPI) GetLastCompletedBuildURLForJob(job string) string {
+	if response, err := api.execute(server.ActionLastCompletedBuildURL, job, "", 0); err == nil {
+		return response.Url
+	}
+	return ""
+}
+
+// GetFailedTestList asks the server for the failed tests of the last failed build
+func (api *RemoteAPI) GetFailedTestList(job string) ([]jenkins.TestCase, error) {
+	return api.GetFailedTestListFor(job, "")
+}
+
+// GetFailedTestListFor asks the server for the failed tests of a certain build
+func (api *RemoteAPI) GetFailedTestListFor(job, id string) (testCaseResult []jenkins.TestCase, err error) {
+	response, err := api.execute(server.ActionFailedTests, job, id, 0)
+	if err != nil {
+		return
+	}
+	testCaseResult = make([]jenkins.TestCase, 0, len(response.TestCases))
+	for _, testCase := range response.TestCases {
+		testCaseResult = append(testCaseResult, jenkins.TestCase{
+			ClassName:       testCase.ClassName,
+			Name:            testCase.Name,
+			Status:          testCase.Status,
+			ErrorStackTrace: testCase.ErrorStackTrace,
+			Age:             int(testCase.Age),
+			FailedSince:     int(testCase.FailedSince),
+		})
+	}
+	return
+}
+
+// GetLastLogLines asks the server for the end of console output of a certain build
+func (api *RemoteAPI) GetLastLogLines(job, id string, lineCount int) ([]string, error) {
+	response, err := api.execute(server.ActionLastLogLines, job, id, lineCount)
+	if err != nil {
+		return nil, err
+	}
+	return response.Lines, nil
+}
+
+// RunJob asks the server to run the job
+func (api *RemoteAPI) RunJob(job string) error {
+	_, err := api.execute(server.ActionRunJob, job, "", 0)
+	return err
+}
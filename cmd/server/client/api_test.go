@@ -0,0 +1,98 @@
+package client
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/main/controller"
+	"github.com/milanaleksic/clici/cmd/server"
+	"github.com/milanaleksic/clici/model"
+	"golang.org/x/net/websocket"
+)
+
+// answerAction answers actions towards job1, whose last build #5 was started by build #3 of the upstream job
+func answerAction(request *server.ActionRequest) *server.ActionResponse {
+	response := &server.ActionResponse{RequestId: request.RequestId, Success: true}
+	switch action := request.Action + " " + request.JobName + " " + request.BuildId; action {
+	case server.ActionBuildStatus + " job1 ":
+		response.Status = &server.BuildStatus{
+			Id:        "5",
+			Result:    "FAILURE",
+			Timestamp: 1500026400000,
+			Duration:  60000,
+			Culprits:  []string{"Alice"},
+			Authors:   []string{"Bob"},
+			Causes:    []*server.Cause{{ShortDescription: "Started by upstream project", UpstreamProject: "upstream", UpstreamBuild: 3}},
+		}
+	case server.ActionBuildStatus + " upstream 3":
+		response.Status = &server.BuildStatus{Id: "3", Causes: []*server.Cause{{UserId: "carol"}}}
+	case server.ActionFailedTests + " job1 5":
+		response.TestCases = []*server.TestCase{{ClassName: "SomeTest", Name: "test1"}, {ClassName: "SomeTest", Name: "test2"}}
+	case server.ActionLastLogLines + " job1 5":
+		response.Lines = []string{"line1", "line2"}
+	default:
+		response.Success = false
+		response.Error = "unexpected action " + action
+	}
+	return response
+}
+
+func TestDetailsAreFetchedThroughServer(t *testing.T) {
+	fake := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		wire := &server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		for {
+			message := &server.ClientMessage{}
+			if err := wire.ReadProto(message); err != nil {
+				return
+			}
+			if message.GetHello() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{HelloResponse: &server.HelloResponse{Accepted: true}})
+			}
+			if message.GetRegister() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{RegisterResponse: &server.RegisterResponse{Success: true}})
+			}
+			if request := message.GetActionRequest(); request != nil {
+				_ = wire.WriteProto(&server.ServerMessage{ActionResponse: answerAction(request)})
+			}
+		}
+	}))
+	defer fake.Close()
+
+	client := New(Config{Location: "ws" + strings.TrimPrefix(fake.URL, "http") + "/"})
+	defer client.Close()
+	go client.Run()
+	go func() {
+		for range client.Events() {
+		}
+	}()
+
+	ctrl := &controller.Controller{APIs: []controller.JenkinsAPIRoot{{API: client.API("jenkins1"), Server: "jenkins1"}}}
+	ctrl.ApplyRemoteUpdate([]model.JobState{{Server: "jenkins1", JobName: "job1"}}, true)
+	request, ok := ctrl.RequestDetails(0)
+	if !ok {
+		t.Fatal("details of job1 should be requested")
+	}
+	details, err := controller.FetchDetails(request)
+	if err != nil {
+		t.Fatalf("details should be fetched through the server, got %v", err)
+	}
+	expected := &model.JobDetails{
+		JobName:         "job1",
+		Server:          "jenkins1",
+		BuildID:         "5",
+		Result:          "FAILURE",
+		Started:         time.Unix(1500026400, 0),
+		Duration:        time.Minute,
+		Causes:          []string{"Started by upstream project", "  Started by carol"},
+		Culprits:        []string{"Alice"},
+		Authors:         []string{"Bob"},
+		FailedTestCount: 2,
+		LogLines:        []string{"line1", "line2"},
+	}
+	if !reflect.DeepEqual(details, expected) {
+		t.Fatalf("expected details %+v, got %+v", expected, details)
+	}
+}
@@ -0,0 +1,412 @@
+/*
+Package client connects to the Clici server and keeps the connection alive: it sends heartbeats,
+reconnects with exponential backoff when connection is lost and re-sends its subscriptions,
+resuming from the last state it has seen.
+*/
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/milanaleksic/clici/cmd/server"
+	"github.com/milanaleksic/clici/model"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultHeartbeatInterval is how often Ping is sent to the server if nothing else is set.
+	// It must be shorter than the server idle timeout
+	DefaultHeartbeatInterval = 15 * time.Second
+	// DefaultMinBackoff is the wait before the first reconnect attempt
+	DefaultMinBackoff = 1 * time.Second
+	// DefaultMaxBackoff is the longest wait between two reconnect attempts
+	DefaultMaxBackoff = 2 * time.Minute
+	// DefaultActionTimeout is how long to wait for the server to execute an action
+	DefaultActionTimeout = 30 * time.Second
+)
+
+// Config describes where to connect and what to subscribe to
+type Config struct {
+	// Location is the websocket URL of the server, for example ws://clici:8080/ws or wss://clici:8443/ws
+	Location string
+	// TLSConfig is used for wss:// locations, for example to trust a self-signed server certificate.
+	// System defaults are used if not set
+	TLSConfig *tls.Config
+	// Token is sent to the server if set, when server has authentication enabled
+	Token    string
+	Jobs     []*server.Register_Job
+	Patterns []*server.Register_Pattern
+	// ClientVersion is reported to the server during the handshake
+	ClientVersion     string
+	HeartbeatInterval time.Duration
+	MinBackoff        time.Duration
+	MaxBackoff        time.Duration
+	ActionTimeout     time.Duration
+}
+
+// Event is either a state update received from the server or a change of the connection status
+type Event struct {
+	Update *server.StateUpdate
+	Status *ConnectionStatus
+}
+
+// ConnectionStatus tells if client is connected. When it's not, Since is the moment connection was lost
+// and Err is the reason of the last failure
+type ConnectionStatus struct {
+	Connected bool
+	Since     time.Time
+	Err       error
+}
+
+// Client is a connection towards a Clici server that survives network failures
+type Client struct {
+	config Config
+	events chan Event
+	// published events wait in a queue until they are taken from events, see forwardEvents
+	published chan Event
+	closed    chan struct{}
+	once      sync.Once
+	sequence  uint64
+	epoch     uint64
+	nonce     uint64
+	requests  chan pendingAction
+	// pending are actions sent to the server in the current session, waiting for the response
+	pending   map[uint64]pendingAction
+	requestID uint64
+}
+
+type pendingAction struct {
+	request  *server.ActionRequest
+	response chan *server.ActionResponse
+}
+
+// New creates a client. Nothing happens until Run is called
+func New(config Config) *Client {
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.ActionTimeout == 0 {
+		config.ActionTimeout = DefaultActionTimeout
+	}
+	return &Client{
+		config:    config,
+		events:    make(chan Event),
+		published: make(chan Event),
+		closed:    make(chan struct{}),
+		requests:  make(chan pendingAction),
+		pending:   make(map[uint64]pendingAction),
+	}
+}
+
+// Events gives the channel on which state updates and connection status changes are published
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Close stops the client, Run will return soon after
+func (c *Client) Close() {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+}
+
+// Run connects to the server and keeps reconnecting until Close is called
+func (c *Client) Run() {
+	go c.forwardEvents()
+	backoff := c.config.MinBackoff
+	var disconnectedSince time.Time
+	for {
+		err := c.session(func() {
+			backoff = c.config.MinBackoff
+			disconnectedSince = time.Time{}
+			c.publish(Event{Status: &ConnectionStatus{Connected: true, Since: time.Now()}})
+		})
+		if c.isClosed() {
+			return
+		}
+		log.Printf("Connection to %v failed: %v, reconnecting in %v", c.config.Location, err, backoff)
+		if disconnectedSince.IsZero() {
+			disconnectedSince = time.Now()
+		}
+		c.publish(Event{Status: &ConnectionStatus{Since: disconnectedSince, Err: err}})
+		select {
+		case <-time.After(backoff):
+		case <-c.closed:
+			return
+		}
+		backoff = nextBackoff(backoff, c.config.MaxBackoff)
+	}
+}
+
+func nextBackoff(current, max time.Duration) time.Duration {
+	next := current * 2
+	if next > max {
+		return max
+	}
+	return next
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) publish(event Event) {
+	select {
+	case c.published <- event:
+	case <-c.closed:
+	}
+}
+
+// forwardEvents queues published events until they are taken from the events channel. Session never waits for
+// the receiver that way, so the receiver can wait for an action while new states are arriving
+func (c *Client) forwardEvents() {
+	var queue []Event
+	for {
+		var events chan<- Event
+		var next Event
+		if len(queue) != 0 {
+			events, next = c.events, queue[0]
+		}
+		select {
+		case event := <-c.published:
+			queue = append(queue, event)
+		case events <- next:
+			queue = queue[1:]
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// session is a single connection to the server, from dialing until the connection fails
+func (c *Client) session(connected func()) (err error) {
+	wsConfig, err := websocket.NewConfig(c.config.Location, "http://localhost/")
+	if err != nil {
+		return
+	}
+	wsConfig.Protocol = []string{"ws"}
+	wsConfig.TlsConfig = c.config.TLSConfig
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = ws.Close()
+	}()
+	wire := &server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+
+	if err = c.handshake(wire); err != nil {
+		return
+	}
+
+	defer c.failPendingActions()
+
+	incoming := make(chan *server.ServerMessage)
+	failed := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			message := &server.ServerMessage{}
+			if err := wire.ReadProto(message); err != nil {
+				failed <- err
+				return
+			}
+			select {
+			case incoming <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err = wire.WriteProto(c.registration()); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(c.config.HeartbeatInterval)
+	defer heartbeat.Stop()
+	lastHeard := time.Now()
+	for {
+		select {
+		case message := <-incoming:
+			lastHeard = time.Now()
+			if err = c.process(message, connected); err != nil {
+				return
+			}
+		case err = <-failed:
+			return
+		case <-heartbeat.C:
+			if time.Since(lastHeard) > 2*c.config.HeartbeatInterval {
+				return fmt.Errorf("no answer from server since %v", lastHeard.Format("15:04:05"))
+			}
+			c.nonce++
+			if err = wire.WriteProto(&server.ClientMessage{Ping: &server.Ping{Nonce: c.nonce}}); err != nil {
+				return
+			}
+		case action := <-c.requests:
+			c.requestID++
+			action.request.RequestId = c.requestID
+			c.pending[c.requestID] = action
+			if err = wire.WriteProto(&server.ClientMessage{ActionRequest: action.request}); err != nil {
+				return
+			}
+		case <-c.closed:
+			return errors.New("client closed")
+		}
+	}
+}
+
+func (c *Client) failPendingActions() {
+	for id, action := range c.pending {
+		action.response <- &server.ActionResponse{RequestId: id, Error: "connection to server lost"}
+		delete(c.pending, id)
+	}
+}
+
+// Execute asks the server to execute the action and waits for the response. Request id is set by the client
+func (c *Client) Execute(request *server.ActionRequest) (*server.ActionResponse, error) {
+	action := pendingAction{
+		request:  request,
+		response: make(chan *server.ActionResponse, 1),
+	}
+	timeout := time.After(c.config.ActionTimeout)
+	select {
+	case c.requests <- action:
+	case <-timeout:
+		return nil, errors.New("not connected to server")
+	case <-c.closed:
+		return nil, errors.New("client closed")
+	}
+	select {
+	case response := <-action.response:
+		if !response.Success {
+			return response, errors.New(response.Error)
+		}
+		return response, nil
+	case <-timeout:
+		return nil, fmt.Errorf("server did not execute action %v in %v", request.Action, c.config.ActionTimeout)
+	case <-c.closed:
+		return nil, errors.New("client closed")
+	}
+}
+
+func (c *Client) handshake(wire *server.LengthEncodedProtoReaderWriter) (err error) {
+	hello := &server.ClientMessage{
+		Hello: &server.Hello{
+			ProtocolVersion: server.ProtocolVersion,
+			Capabilities:    []string{server.CapabilityPatterns, server.CapabilityResume, server.CapabilityAuth, server.CapabilityActions},
+			ClientVersion:   c.config.ClientVersion,
+		},
+	}
+	if err = wire.WriteProto(hello); err != nil {
+		return
+	}
+	data, err := wire.ReadMessage()
+	if err != nil {
+		return
+	}
+	message := &server.ServerMessage{}
+	if err = proto.Unmarshal(data, message); err != nil || message.GetHelloResponse() == nil {
+		// protocol version 1 servers ignore Hello and answer it as if it were an empty Register
+		legacy := &server.RegisterResponse{}
+		if proto.Unmarshal(data, legacy) == nil && legacy.Version != "" {
+			err = fmt.Errorf("server (version %v) speaks protocol version 1 which this client doesn't support, it needs to be upgraded", legacy.Version)
+		} else {
+			err = errors.New("server did not answer the handshake")
+		}
+		return
+	}
+	if response := message.GetHelloResponse(); !response.Accepted {
+		err = fmt.Errorf("server rejected the handshake: %v", response.Error)
+	}
+	return
+}
+
+// registration re-sends all subscriptions, asking only for states newer than the ones already seen
+func (c *Client) registration() *server.ClientMessage {
+	message := &server.ClientMessage{
+		Register: &server.Register{
+			Jobs:        c.config.Jobs,
+			Patterns:    c.config.Patterns,
+			ResumeFrom:  c.sequence,
+			ResumeEpoch: c.epoch,
+		},
+	}
+	if c.config.Token != "" {
+		message.Authenticate = &server.Authenticate{Token: c.config.Token}
+	}
+	return message
+}
+
+func (c *Client) process(message *server.ServerMessage, connected func()) error {
+	if response := message.GetRegisterResponse(); response != nil {
+		if !response.Success {
+			return fmt.Errorf("server refused the subscriptions: %v", response.Error)
+		}
+		connected()
+	}
+	if update := message.GetStateUpdate(); update != nil {
+		if update.Snapshot || (update.Epoch == c.epoch && update.Sequence > c.sequence) {
+			c.epoch, c.sequence = update.Epoch, update.Sequence
+		}
+		c.publish(Event{Update: update})
+	}
+	if response := message.GetActionResponse(); response != nil {
+		if action, ok := c.pending[response.RequestId]; ok {
+			delete(c.pending, response.RequestId)
+			action.response <- response
+		}
+	}
+	if goodbye := message.GetGoodbye(); goodbye != nil {
+		return fmt.Errorf("server closed the connection: %v", goodbye.Reason)
+	}
+	return nil
+}
+
+// ToModel converts a state received from the server into the application model
+func ToModel(state *server.JobState) model.JobState {
+	var err error
+	if state.Error != "" {
+		err = errors.New(state.Error)
+	}
+	return model.JobState{
+		Group:            state.Group,
+		JobName:          state.JobName,
+		Server:           state.ServerLocation,
+		CulpritsFriendly: state.CulpritsFriendly,
+		CausesFriendly:   state.CausesFriendly,
+		Time:             state.Time,
+		Error:            err,
+		PreviousState:    model.BuildStatus(state.PreviousState),
+		Building:         state.Building,
+		Started:          fromMillis(state.Started),
+		Duration:         time.Duration(state.Duration) * time.Millisecond,
+		Queued:           state.Queued,
+	}
+}
+
+// fromMillis converts milliseconds since the epoch into time, zero is not known
+func fromMillis(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/cmd/server"
+	"golang.org/x/net/websocket"
+)
+
+// fakeServerEpoch is the epoch of all sequences given out by the fake server
+const fakeServerEpoch = 7
+
+// fakeServer answers the handshake and registration, sends one state update (a snapshot on the first connection)
+// and then drops the connection
+func fakeServer(registrations chan<- *server.Register) *httptest.Server {
+	sequence := uint64(0)
+	return httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer func() {
+			_ = ws.Close()
+		}()
+		wire := &server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		for {
+			message := &server.ClientMessage{}
+			if err := wire.ReadProto(message); err != nil {
+				return
+			}
+			if message.GetHello() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{HelloResponse: &server.HelloResponse{
+					ProtocolVersion: server.ProtocolVersion,
+					Accepted:        true,
+				}})
+			}
+			if register := message.GetRegister(); register != nil {
+				registrations <- register
+				sequence++
+				_ = wire.WriteProto(&server.ServerMessage{RegisterResponse: &server.RegisterResponse{Success: true}})
+				_ = wire.WriteProto(&server.ServerMessage{StateUpdate: &server.StateUpdate{
+					States:   []*server.JobState{{ServerLocation: "jenkins1", JobName: "job1", Version: sequence}},
+					Sequence: sequence,
+					Snapshot: sequence == 1,
+					Epoch:    fakeServerEpoch,
+				}})
+				return
+			}
+		}
+	}))
+}
+
+func TestReconnectResumesFromLastSequence(t *testing.T) {
+	registrations := make(chan *server.Register, 10)
+	fake := fakeServer(registrations)
+	defer fake.Close()
+
+	client := New(Config{
+		Location:   "ws" + strings.TrimPrefix(fake.URL, "http") + "/",
+		Jobs:       []*server.Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+		MinBackoff: 10 * time.Millisecond,
+	})
+	defer client.Close()
+	go client.Run()
+
+	connections := 0
+	for connections < 2 {
+		select {
+		case event := <-client.Events():
+			if event.Status != nil && event.Status.Connected {
+				connections++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client did not reconnect")
+		}
+	}
+
+	if first := <-registrations; first.ResumeFrom != 0 {
+		t.Errorf("first registration should ask for a snapshot, got resumeFrom=%v", first.ResumeFrom)
+	}
+	if second := <-registrations; second.ResumeFrom != 1 || second.ResumeEpoch != fakeServerEpoch || len(second.Jobs) != 1 {
+		t.Errorf("subscriptions should be re-sent with the last seen sequence, got %v", second)
+	}
+}
+
+func TestStatesArriveWhileActionIsPending(t *testing.T) {
+	fake := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		wire := &server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		for {
+			message := &server.ClientMessage{}
+			if err := wire.ReadProto(message); err != nil {
+				return
+			}
+			if message.GetHello() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{HelloResponse: &server.HelloResponse{Accepted: true}})
+			}
+			if message.GetRegister() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{RegisterResponse: &server.RegisterResponse{Success: true}})
+			}
+			if request := message.GetActionRequest(); request != nil {
+				// job started building before the server answered
+				_ = wire.WriteProto(&server.ServerMessage{StateUpdate: &server.StateUpdate{
+					States:   []*server.JobState{{ServerLocation: "jenkins1", JobName: "job1", Building: true, Version: 1}},
+					Sequence: 1,
+				}})
+				_ = wire.WriteProto(&server.ServerMessage{ActionResponse: &server.ActionResponse{RequestId: request.RequestId, Success: true}})
+			}
+		}
+	}))
+	defer fake.Close()
+
+	client := New(Config{Location: "ws" + strings.TrimPrefix(fake.URL, "http") + "/", ActionTimeout: 5 * time.Second})
+	defer client.Close()
+	go client.Run()
+	for event := range client.Events() {
+		if event.Status != nil && event.Status.Connected {
+			break
+		}
+	}
+
+	// events are not taken while waiting for the action, like when the action is executed by the receiver itself
+	if err := client.API("jenkins1").RunJob("job1"); err != nil {
+		t.Fatalf("action should not wait for the state update to be taken, got %v", err)
+	}
+	select {
+	case event := <-client.Events():
+		if event.Update == nil || len(event.Update.States) != 1 || !event.Update.States[0].Building {
+			t.Fatalf("expected the update received while action was pending, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update received while action was pending is lost")
+	}
+}
+
+func TestMismatchedProtocolVersionIsReported(t *testing.T) {
+	cases := []struct {
+		name     string
+		answer   func(wire *server.LengthEncodedProtoReaderWriter)
+		expected string
+	}{
+		{
+			name: "protocol version 1 server",
+			answer: func(wire *server.LengthEncodedProtoReaderWriter) {
+				_ = wire.ReadProto(&server.Register{})
+				_ = wire.WriteProto(&server.RegisterResponse{Version: "1.0.0", Success: true, Connid: "conn1"})
+			},
+			expected: "speaks protocol version 1",
+		},
+		{
+			name: "server rejecting the version",
+			answer: func(wire *server.LengthEncodedProtoReaderWriter) {
+				_ = wire.ReadProto(&server.ClientMessage{})
+				_ = wire.WriteProto(&server.ServerMessage{HelloResponse: &server.HelloResponse{
+					Error: "protocol version 2 is not supported, server supports versions 3 to 3",
+				}})
+			},
+			expected: "protocol version 2 is not supported",
+		},
+	}
+	for _, c := range cases {
+		answer := c.answer
+		fake := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			defer func() {
+				_ = ws.Close()
+			}()
+			answer(&server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws})
+		}))
+
+		client := New(Config{Location: "ws" + strings.TrimPrefix(fake.URL, "http") + "/", MinBackoff: time.Minute})
+		go client.Run()
+		select {
+		case event := <-client.Events():
+			if event.Status == nil || event.Status.Connected || event.Status.Err == nil || !strings.Contains(event.Status.Err.Error(), c.expected) {
+				t.Errorf("%v: expected connection failure with %q, got %+v", c.name, c.expected, event.Status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%v: connection failure was not reported", c.name)
+		}
+		client.Close()
+		fake.Close()
+	}
+}
+
+func TestBackoffIsLimited(t *testing.T) {
+	backoff := time.Second
+	for i := 0; i < 10; i++ {
+		backoff = nextBackoff(backoff, time.Minute)
+	}
+	if backoff != time.Minute {
+		t.Errorf("backoff should stop growing at the maximum, got %v", backoff)
+	}
+}
+
+func TestActionsAreExecutedOnServer(t *testing.T) {
+	fake := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		wire := &server.LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		for {
+			message := &server.ClientMessage{}
+			if err := wire.ReadProto(message); err != nil {
+				return
+			}
+			if message.GetHello() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{HelloResponse: &server.HelloResponse{Accepted: true}})
+			}
+			if message.GetRegister() != nil {
+				_ = wire.WriteProto(&server.ServerMessage{RegisterResponse: &server.RegisterResponse{Success: true}})
+			}
+			if request := message.GetActionRequest(); request != nil {
+				_ = wire.WriteProto(&server.ServerMessage{ActionResponse: &server.ActionResponse{
+					RequestId: request.RequestId,
+					Success:   true,
+					Lines:     []string{request.Action, request.JobName},
+				}})
+			}
+		}
+	}))
+	defer fake.Close()
+
+	client := New(Config{Location: "ws" + strings.TrimPrefix(fake.URL, "http") + "/"})
+	defer client.Close()
+	go client.Run()
+	go func() {
+		for range client.Events() {
+		}
+	}()
+
+	lines, err := client.API("jenkins1").GetLastLogLines("job1", "", 10)
+	if err != nil {
+		t.Fatalf("action failed: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != server.ActionLastLogLines || lines[1] != "job1" {
+		t.Fatalf("unexpected response: %v", lines)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Configuration is the server configuration, usually loaded when the server starts from the JSON file set as
+// CliciServer.ConfigurationFile, or passed to CliciServer.Configure. Jenkins credentials are only known to the server, clients identify themselves only via tokens
+type Configuration struct {
+	// Tokens are static client tokens with their permissions. If none are set, authentication is disabled
+	Tokens []TokenPolicy `json:"tokens"`
+	// AnonymousActions are relayed actions (like ActionRunJob) clients may execute while authentication is disabled.
+	// Anonymous clients can't execute any of them unless listed here
+	AnonymousActions []string `json:"anonymousActions"`
+	// Jenkins holds the credentials server will use towards each Jenkins server. Clients can use only Jenkins servers
+	// listed here or in Budgets; a server accessed anonymously can be listed without username and password
+	Jenkins []JenkinsCredentials `json:"jenkins"`
+	// Budgets limit requests towards each Jenkins server. Servers not listed get the default budget
+	Budgets []RequestBudget `json:"budgets"`
+}
+
+// JenkinsCredentials are used by the server when communicating with a Jenkins server behind Location
+type JenkinsCredentials struct {
+	Location string `json:"location"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoadConfiguration reads server configuration from a JSON file
+func LoadConfiguration(path string) (configuration *Configuration, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	configuration = &Configuration{}
+	if err = json.NewDecoder(file).Decode(configuration); err != nil {
+		return nil, fmt.Errorf("Could not parse server configuration %v: %v", path, err)
+	}
+	return
+}
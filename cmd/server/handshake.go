@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+)
+
+const (
+	// ProtocolVersion is the version of the wire protocol this server speaks.
+	// Version 1 was the initial protocol without the handshake
+	ProtocolVersion = 2
+	// MinimumProtocolVersion is the oldest protocol version a client can speak towards this server
+	MinimumProtocolVersion = 2
+
+	// CapabilityPatterns means job globs are supported in subscriptions
+	CapabilityPatterns = "patterns"
+	// CapabilityResume means client can resume from the last seen sequence after reconnecting
+	CapabilityResume = "resume"
+	// CapabilityAuth means token authentication is supported
+	CapabilityAuth = "auth"
+	// CapabilityActions means server can call Jenkins on behalf of the client, see ActionRequest
+	CapabilityActions = "actions"
+)
+
+var serverCapabilities = []string{
+	CapabilityPatterns,
+	CapabilityResume,
+	CapabilityAuth,
+	CapabilityActions,
+}
+
+// negotiate picks the protocol version and capabilities both sides support.
+// If hello is nil the client didn't start with the handshake, so it speaks protocol version 1 and is rejected
+func negotiate(hello *Hello) *HelloResponse {
+	response := &HelloResponse{
+		ServerVersion: Version,
+	}
+	if hello == nil {
+		response.Error = fmt.Sprintf("protocol version 1 (without the handshake) is not supported, server supports versions %d to %d",
+			MinimumProtocolVersion, ProtocolVersion)
+		return response
+	}
+	response.ProtocolVersion = hello.ProtocolVersion
+	if response.ProtocolVersion > ProtocolVersion {
+		response.ProtocolVersion = ProtocolVersion
+	}
+	if response.ProtocolVersion < MinimumProtocolVersion {
+		response.Error = fmt.Sprintf("protocol version %d is not supported, server supports versions %d to %d",
+			hello.ProtocolVersion, MinimumProtocolVersion, ProtocolVersion)
+		return response
+	}
+	clientCapabilities := make(map[string]bool)
+	for _, capability := range hello.Capabilities {
+		clientCapabilities[capability] = true
+	}
+	for _, capability := range serverCapabilities {
+		if clientCapabilities[capability] {
+			response.Capabilities = append(response.Capabilities, capability)
+		}
+	}
+	response.Accepted = true
+	return response
+}
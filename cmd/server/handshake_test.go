@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestHandshakeNegotiatesCapabilities(t *testing.T) {
+	response := negotiate(&Hello{
+		ProtocolVersion: ProtocolVersion + 1,
+		Capabilities:    []string{CapabilityResume, "unknown"},
+	})
+	if !response.Accepted {
+		t.Fatalf("newer client should be accepted, got error %v", response.Error)
+	}
+	if response.ProtocolVersion != ProtocolVersion {
+		t.Errorf("expected protocol version %d, got %d", ProtocolVersion, response.ProtocolVersion)
+	}
+	if len(response.Capabilities) != 1 || response.Capabilities[0] != CapabilityResume {
+		t.Errorf("expected only common capabilities, got %v", response.Capabilities)
+	}
+}
+
+func TestProtocolVersion1ClientIsRejected(t *testing.T) {
+	// protocol version 1 clients send a bare Register, without the handshake
+	for _, location := range []string{"jenkins1", "http://jenkins1"} {
+		withRunningServer(t, func(clici *CliciServer, _ *websocket.Conn) {
+			ws := dialWithoutHandshake(t, clici.Port)
+			defer func() {
+				_ = ws.Close()
+			}()
+			wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+			if err := wire.WriteProto(&Register{Jobs: []*Register_Job{{ServerLocation: location, JobName: "job1"}}}); err != nil {
+				t.Fatalf("request failed while writing: %v", err)
+			}
+			response := &RegisterResponse{}
+			if err := wire.ReadProto(response); err != nil {
+				t.Fatalf("expected response understood by protocol version 1 clients, got error %v", err)
+			}
+			if response.Success || response.Version != Version || !strings.Contains(response.Error, "protocol version 1") {
+				t.Fatalf("expected protocol version 1 to be rejected, got %v", response)
+			}
+			if err := wire.ReadProto(&ServerMessage{}); err == nil {
+				t.Fatal("connection should have been closed by the server")
+			}
+		})
+	}
+}
+
+func TestOldProtocolVersionIsRejected(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, _ *websocket.Conn) {
+		ws := dialWithoutHandshake(t, clici.Port)
+		defer func() {
+			_ = ws.Close()
+		}()
+		response := handshake(t, ws, MinimumProtocolVersion-1)
+		if response.Accepted || !strings.Contains(response.Error, "not supported") {
+			t.Fatalf("expected old protocol version to be rejected, got %v", response)
+		}
+	})
+}
@@ -2,12 +2,15 @@ package server
 
 import (
 	"log"
+	"path"
+	"sort"
 
 	"github.com/hashicorp/go-memdb"
 )
 
 const (
 	registrationTable = "registration"
+	patternTable      = "pattern"
 )
 
 // ConnectionID is a simple wrapper around a generated ID for incoming client connection
@@ -37,19 +40,75 @@ func (mapping *Mapping) RegisterClient(id ConnectionID, reg registration) {
 	log.Println("Connection registered")
 }
 
+// RegisterPattern allows registering a certain connection with all jobs on a server which match a glob
+func (mapping *Mapping) RegisterPattern(id ConnectionID, pattern patternRegistration) {
+	txn := mapping.db.Txn(true)
+
+	if err := txn.Insert(patternTable, pattern); err != nil {
+		panic(err)
+	}
+
+	txn.Commit()
+	log.Println("Pattern registered")
+}
+
+// UnRegisterJobs removes only the given job and pattern mappings of a connection, leaving all others intact.
+// Mappings which are not known are ignored
+func (mapping *Mapping) UnRegisterJobs(id ConnectionID, regs []registration, patterns []patternRegistration) {
+	txn := mapping.db.Txn(true)
+	for _, reg := range regs {
+		deleteIfExists(txn, registrationTable, reg)
+	}
+	for _, pattern := range patterns {
+		deleteIfExists(txn, patternTable, pattern)
+	}
+	txn.Commit()
+	log.Printf("Client %v unregistered %d jobs and %d patterns", id, len(regs), len(patterns))
+}
+
+// ReplaceSubscriptions atomically replaces all job and pattern mappings of a connection with the given ones
+func (mapping *Mapping) ReplaceSubscriptions(id ConnectionID, regs []registration, patterns []patternRegistration) {
+	txn := mapping.db.Txn(true)
+	deleteAllForConnection(txn, id)
+	for _, reg := range regs {
+		if err := txn.Insert(registrationTable, reg); err != nil {
+			panic(err)
+		}
+	}
+	for _, pattern := range patterns {
+		if err := txn.Insert(patternTable, pattern); err != nil {
+			panic(err)
+		}
+	}
+	txn.Commit()
+	log.Printf("Client %v replaced subscriptions with %d jobs and %d patterns", id, len(regs), len(patterns))
+}
+
 // UnRegisterClient will remove all mappings from the in-memory DB for a certain connection id
 func (mapping *Mapping) UnRegisterClient(id ConnectionID) {
 	txn := mapping.db.Txn(true)
-	n, err := txn.DeleteAll(registrationTable, "connid", id.AsString())
-	if err != nil {
-		log.Printf("Failed when deleting connection records from in-memory DB: %v", err)
-	} else {
-		log.Printf("Client unregistered, jobs deleted: %d", n)
-	}
+	deleteAllForConnection(txn, id)
 	txn.Commit()
 	log.Println("Connection removed")
 }
 
+func deleteAllForConnection(txn *memdb.Txn, id ConnectionID) {
+	for _, table := range []string{registrationTable, patternTable} {
+		n, err := txn.DeleteAll(table, "connid", id.AsString())
+		if err != nil {
+			log.Printf("Failed when deleting connection records from in-memory DB table %v: %v", table, err)
+		} else {
+			log.Printf("Client unregistered, %v records deleted: %d", table, n)
+		}
+	}
+}
+
+func deleteIfExists(txn *memdb.Txn, table string, obj interface{}) {
+	if err := txn.Delete(table, obj); err != nil && err != memdb.ErrNotFound {
+		log.Printf("Failed when deleting record %v from in-memory DB: %v", obj, err)
+	}
+}
+
 // GetAllUniqueJobs will give all server->jobs mappings
 func (mapping *Mapping) GetAllUniqueJobs() (serverToJobRegistrations map[string][]string) {
 	txn := mapping.db.Txn(false)
@@ -58,6 +117,8 @@ func (mapping *Mapping) GetAllUniqueJobs() (serverToJobRegistrations map[string]
 		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
 	}
 	serverToJobRegistrations = make(map[string][]string, 0)
+	// jobs registered by many connections are listed only once, so they are polled only once
+	seen := make(map[jobKey]bool, 0)
 	var iter interface{}
 	for {
 		iter = iterator.Next()
@@ -65,15 +126,46 @@ func (mapping *Mapping) GetAllUniqueJobs() (serverToJobRegistrations map[string]
 			break
 		}
 		reg := iter.(registration)
+		key := jobKey{server: reg.ServerLocation, jobName: reg.JobName}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
 		serverToJobRegistrations[reg.ServerLocation] = append(serverToJobRegistrations[reg.ServerLocation], reg.JobName)
 	}
 	return
 }
 
+// GetAllPatterns will give all server->job globs mappings
+func (mapping *Mapping) GetAllPatterns() (serverToPatterns map[string][]string) {
+	txn := mapping.db.Txn(false)
+	iterator, err := txn.Get(patternTable, "servers")
+	if err != nil {
+		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
+	}
+	serverToPatterns = make(map[string][]string, 0)
+	seen := make(map[jobKey]bool, 0)
+	var iter interface{}
+	for {
+		iter = iterator.Next()
+		if iter == nil {
+			break
+		}
+		pattern := iter.(patternRegistration)
+		key := jobKey{server: pattern.ServerLocation, jobName: pattern.JobGlob}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		serverToPatterns[pattern.ServerLocation] = append(serverToPatterns[pattern.ServerLocation], pattern.JobGlob)
+	}
+	return
+}
+
 // FindAllRegisteredConnectionsForServerAndJob will find which connections are interested in particular server+job combination
 func (mapping *Mapping) FindAllRegisteredConnectionsForServerAndJob(server string, jobName string) (connIds []ConnectionID) {
 	txn := mapping.db.Txn(false)
-	iterator, err := txn.Get(registrationTable, "jobs")
+	iterator, err := txn.Get(registrationTable, "jobs", server, jobName)
 	if err != nil {
 		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
 	}
@@ -87,6 +179,20 @@ func (mapping *Mapping) FindAllRegisteredConnectionsForServerAndJob(server strin
 		reg := iter.(registration)
 		connIdsSet[reg.ConnectionID] = true
 	}
+	iterator, err = txn.Get(patternTable, "servers", server)
+	if err != nil {
+		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
+	}
+	for {
+		iter = iterator.Next()
+		if iter == nil {
+			break
+		}
+		pattern := iter.(patternRegistration)
+		if pattern.matches(jobName) {
+			connIdsSet[pattern.ConnectionID] = true
+		}
+	}
 	connIds = make([]ConnectionID, 0)
 	for connID := range connIdsSet {
 		connIds = append(connIds, connID)
@@ -94,6 +200,83 @@ func (mapping *Mapping) FindAllRegisteredConnectionsForServerAndJob(server strin
 	return
 }
 
+// IsConnectionRegisteredForServerAndJob checks if a connection is interested in a particular server+job combination,
+// either directly or via one of its patterns
+func (mapping *Mapping) IsConnectionRegisteredForServerAndJob(id ConnectionID, server string, jobName string) bool {
+	txn := mapping.db.Txn(false)
+	reg, err := txn.First(registrationTable, "id", id.AsString(), server, jobName)
+	if err != nil {
+		log.Fatalf("Failed when searching records in in-memory DB: %v", err)
+	}
+	if reg != nil {
+		return true
+	}
+	iterator, err := txn.Get(patternTable, "connid", id.AsString())
+	if err != nil {
+		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
+	}
+	for iter := iterator.Next(); iter != nil; iter = iterator.Next() {
+		pattern := iter.(patternRegistration)
+		if pattern.ServerLocation == server && pattern.matches(jobName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription is a single job (or a glob of jobs) on a server a connection is registered for
+type Subscription struct {
+	Server  string `json:"server"`
+	Job     string `json:"job,omitempty"`
+	JobGlob string `json:"jobGlob,omitempty"`
+}
+
+// ConnectionSubscriptions lists everything a single connection is registered for
+type ConnectionSubscriptions struct {
+	ConnectionID  ConnectionID   `json:"connectionId"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	// QueueDepth is the number of updates waiting to be sent to the connection
+	QueueDepth int `json:"queueDepth"`
+}
+
+type byConnectionID []ConnectionSubscriptions
+
+func (connections byConnectionID) Len() int { return len(connections) }
+func (connections byConnectionID) Swap(i, j int) {
+	connections[i], connections[j] = connections[j], connections[i]
+}
+func (connections byConnectionID) Less(i, j int) bool {
+	return connections[i].ConnectionID < connections[j].ConnectionID
+}
+
+// GetAllSubscriptions gives jobs and patterns of all connections which have at least one subscription
+func (mapping *Mapping) GetAllSubscriptions() []ConnectionSubscriptions {
+	txn := mapping.db.Txn(false)
+	perConnection := make(map[ConnectionID][]Subscription)
+	iterator, err := txn.Get(registrationTable, "id")
+	if err != nil {
+		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
+	}
+	for iter := iterator.Next(); iter != nil; iter = iterator.Next() {
+		reg := iter.(registration)
+		perConnection[reg.ConnectionID] = append(perConnection[reg.ConnectionID], Subscription{Server: reg.ServerLocation, Job: reg.JobName})
+	}
+	iterator, err = txn.Get(patternTable, "id")
+	if err != nil {
+		log.Fatalf("Failed when listing records from in-memory DB: %v", err)
+	}
+	for iter := iterator.Next(); iter != nil; iter = iterator.Next() {
+		pattern := iter.(patternRegistration)
+		perConnection[pattern.ConnectionID] = append(perConnection[pattern.ConnectionID], Subscription{Server: pattern.ServerLocation, JobGlob: pattern.JobGlob})
+	}
+	result := make([]ConnectionSubscriptions, 0, len(perConnection))
+	for id, subscriptions := range perConnection {
+		result = append(result, ConnectionSubscriptions{ConnectionID: id, Subscriptions: subscriptions})
+	}
+	sort.Sort(byConnectionID(result))
+	return result
+}
+
 type registration struct {
 	// ConnectionID is a unique string identifying an active connection from clici client
 	ConnectionID ConnectionID
@@ -103,6 +286,24 @@ type registration struct {
 	JobName string
 }
 
+type patternRegistration struct {
+	// ConnectionID is a unique string identifying an active connection from clici client
+	ConnectionID ConnectionID
+	// ServerLocation is a location of a Jenkins server some connection is interested in
+	ServerLocation string
+	// JobGlob is a shell-like pattern (as understood by path.Match) that job names need to match
+	JobGlob string
+}
+
+func (pattern *patternRegistration) matches(jobName string) bool {
+	matched, err := path.Match(pattern.JobGlob, jobName)
+	if err != nil {
+		log.Printf("Invalid pattern %v: %v", pattern.JobGlob, err)
+		return false
+	}
+	return matched
+}
+
 // NewMapping creates a single empty Mapping abstraction with ready-for-usage in-memory DB
 func NewMapping() *Mapping {
 	schema := &memdb.DBSchema{
@@ -142,6 +343,40 @@ func NewMapping() *Mapping {
 					},
 				},
 			},
+			patternTable: &memdb.TableSchema{
+				Name: patternTable,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:   "id",
+						Unique: true,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "ConnectionID"},
+								&memdb.StringFieldIndex{Field: "ServerLocation"},
+								&memdb.StringFieldIndex{Field: "JobGlob"},
+							},
+						},
+					},
+					"connid": &memdb.IndexSchema{
+						Name:   "connid",
+						Unique: false,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "ConnectionID"},
+							},
+						},
+					},
+					"servers": &memdb.IndexSchema{
+						Name:   "servers",
+						Unique: false,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "ServerLocation"},
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 	db, err := memdb.NewMemDB(schema)
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestMappingPatterns(t *testing.T) {
+	mapping := NewMapping()
+	mapping.RegisterClient("conn1", registration{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "build"})
+	mapping.RegisterPattern("conn2", patternRegistration{ConnectionID: "conn2", ServerLocation: "jenkins", JobGlob: "deploy-*"})
+	mapping.RegisterPattern("conn3", patternRegistration{ConnectionID: "conn3", ServerLocation: "other", JobGlob: "*"})
+
+	if found := mapping.FindAllRegisteredConnectionsForServerAndJob("jenkins", "build"); len(found) != 1 || found[0] != "conn1" {
+		t.Fatalf("Only exact registration expected for build, got: %v", found)
+	}
+	if found := mapping.FindAllRegisteredConnectionsForServerAndJob("jenkins", "deploy-prod"); len(found) != 1 || found[0] != "conn2" {
+		t.Fatalf("Only pattern registration expected for deploy-prod, got: %v", found)
+	}
+	if found := mapping.FindAllRegisteredConnectionsForServerAndJob("jenkins", "deploy"); len(found) != 0 {
+		t.Fatalf("No registrations expected for deploy, got: %v", found)
+	}
+	if patterns := mapping.GetAllPatterns(); len(patterns["jenkins"]) != 1 || len(patterns["other"]) != 1 {
+		t.Fatalf("Unexpected patterns: %v", patterns)
+	}
+
+	mapping.UnRegisterClient("conn2")
+	if found := mapping.FindAllRegisteredConnectionsForServerAndJob("jenkins", "deploy-prod"); len(found) != 0 {
+		t.Fatalf("Pattern should have been removed with the client, got: %v", found)
+	}
+}
+
+func TestMappingReplaceSubscriptions(t *testing.T) {
+	mapping := NewMapping()
+	mapping.RegisterClient("conn1", registration{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "job1"})
+	mapping.RegisterClient("conn1", registration{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "job2"})
+	mapping.RegisterClient("conn2", registration{ConnectionID: "conn2", ServerLocation: "jenkins", JobName: "job1"})
+
+	mapping.ReplaceSubscriptions("conn1", []registration{
+		{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "job3"},
+	}, nil)
+
+	jobs := mapping.GetAllUniqueJobs()["jenkins"]
+	sort.Strings(jobs)
+	if len(jobs) != 2 || jobs[0] != "job1" || jobs[1] != "job3" {
+		t.Fatalf("Unexpected jobs after replace: %v", jobs)
+	}
+	if found := mapping.FindAllRegisteredConnectionsForServerAndJob("jenkins", "job1"); len(found) != 1 || found[0] != "conn2" {
+		t.Fatalf("Other connections must not be touched by replace, got: %v", found)
+	}
+
+	mapping.UnRegisterJobs("conn1", []registration{
+		{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "job3"},
+		{ConnectionID: "conn1", ServerLocation: "jenkins", JobName: "unknown"},
+	}, nil)
+	if jobs := mapping.GetAllUniqueJobs()["jenkins"]; len(jobs) != 1 || jobs[0] != "job1" {
+		t.Fatalf("Unexpected jobs after unregister: %v", jobs)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+)
+
+type requestKey struct {
+	server string
+	method string
+}
+
+type durationStats struct {
+	count   uint64
+	errors  uint64
+	seconds float64
+}
+
+func (stats *durationStats) observe(started time.Time, failed bool) {
+	stats.count++
+	stats.seconds += time.Since(started).Seconds()
+	if failed {
+		stats.errors++
+	}
+}
+
+// metrics collects counters which are not available elsewhere, like durations of calls towards Jenkins
+type metrics struct {
+	sync.Mutex
+	requests map[requestKey]*durationStats
+	polls    map[string]*durationStats
+	// budgetWaits are requests per server which waited because request budget was exhausted
+	budgetWaits map[string]*durationStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestKey]*durationStats),
+		polls:    make(map[string]*durationStats),
+
+		budgetWaits: make(map[string]*durationStats),
+	}
+}
+
+func (m *metrics) observeRequest(server, method string, started time.Time, failed bool) {
+	m.Lock()
+	defer m.Unlock()
+	key := requestKey{server: server, method: method}
+	stats, ok := m.requests[key]
+	if !ok {
+		stats = &durationStats{}
+		m.requests[key] = stats
+	}
+	stats.observe(started, failed)
+}
+
+func (m *metrics) observePoll(server string, started time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	observeServer(m.polls, server, started)
+}
+
+func (m *metrics) observeBudgetWait(server string, started time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	observeServer(m.budgetWaits, server, started)
+}
+
+func observeServer(statsPerServer map[string]*durationStats, server string, started time.Time) {
+	stats, ok := statsPerServer[server]
+	if !ok {
+		stats = &durationStats{}
+		statsPerServer[server] = stats
+	}
+	stats.observe(started, false)
+}
+
+// writeTo writes all metrics in Prometheus text exposition format
+func (m *metrics) writeTo(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Sort(byServerAndMethod(requestKeys))
+	writeHeader(w, "clici_jenkins_requests_total", "counter", "Calls towards Jenkins API")
+	for _, key := range requestKeys {
+		fmt.Fprintf(w, "clici_jenkins_requests_total{server=%s,method=%s} %d\n", labelValue(key.server), labelValue(key.method), m.requests[key].count)
+	}
+	writeHeader(w, "clici_jenkins_request_errors_total", "counter", "Calls towards Jenkins API which failed")
+	for _, key := range requestKeys {
+		fmt.Fprintf(w, "clici_jenkins_request_errors_total{server=%s,method=%s} %d\n", labelValue(key.server), labelValue(key.method), m.requests[key].errors)
+	}
+	writeHeader(w, "clici_jenkins_request_duration_seconds", "summary", "Duration of calls towards Jenkins API")
+	for _, key := range requestKeys {
+		stats := m.requests[key]
+		fmt.Fprintf(w, "clici_jenkins_request_duration_seconds_sum{server=%s,method=%s} %g\n", labelValue(key.server), labelValue(key.method), stats.seconds)
+		fmt.Fprintf(w, "clici_jenkins_request_duration_seconds_count{server=%s,method=%s} %d\n", labelValue(key.server), labelValue(key.method), stats.count)
+	}
+
+	writeHeader(w, "clici_poll_duration_seconds", "summary", "Duration of refreshing all registered jobs of a Jenkins server")
+	for _, server := range sortedServers(m.polls) {
+		stats := m.polls[server]
+		fmt.Fprintf(w, "clici_poll_duration_seconds_sum{server=%s} %g\n", labelValue(server), stats.seconds)
+		fmt.Fprintf(w, "clici_poll_duration_seconds_count{server=%s} %d\n", labelValue(server), stats.count)
+	}
+
+	waitingServers := sortedServers(m.budgetWaits)
+	writeHeader(w, "clici_jenkins_budget_exhausted_total", "counter", "Calls towards Jenkins API which had to wait because request budget was exhausted")
+	for _, server := range waitingServers {
+		fmt.Fprintf(w, "clici_jenkins_budget_exhausted_total{server=%s} %d\n", labelValue(server), m.budgetWaits[server].count)
+	}
+	writeHeader(w, "clici_jenkins_budget_wait_seconds_total", "counter", "Time calls towards Jenkins API spent waiting for request budget")
+	for _, server := range waitingServers {
+		fmt.Fprintf(w, "clici_jenkins_budget_wait_seconds_total{server=%s} %g\n", labelValue(server), m.budgetWaits[server].seconds)
+	}
+}
+
+func sortedServers(statsPerServer map[string]*durationStats) []string {
+	servers := make([]string, 0, len(statsPerServer))
+	for server := range statsPerServer {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+type byServerAndMethod []requestKey
+
+func (keys byServerAndMethod) Len() int      { return len(keys) }
+func (keys byServerAndMethod) Swap(i, j int) { keys[i], keys[j] = keys[j], keys[i] }
+func (keys byServerAndMethod) Less(i, j int) bool {
+	if keys[i].server != keys[j].server {
+		return keys[i].server < keys[j].server
+	}
+	return keys[i].method < keys[j].method
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeGauge(w io.Writer, name, help string, value interface{}) {
+	writeHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	writeHeader(w, name, "counter", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// instrumentedAPI is a jenkins.API which keeps all calls that go towards the Jenkins server within
+// the request budget of the server, and measures them
+type instrumentedAPI struct {
+	jenkins.API
+	server  string
+	budget  *requestBudget
+	metrics *metrics
+}
+
+// start waits for the request budget and gives the moment the call towards Jenkins can start
+func (api *instrumentedAPI) start() time.Time {
+	waitStarted := time.Now()
+	if api.budget.take() {
+		api.metrics.observeBudgetWait(api.server, waitStarted)
+	}
+	return time.Now()
+}
+
+func (api *instrumentedAPI) observe(method string, started time.Time, err *error) {
+	api.budget.release()
+	api.metrics.observeRequest(api.server, method, started, err != nil && *err != nil)
+}
+
+func (api *instrumentedAPI) GetKnownJobs() (status *jenkins.Status, err error) {
+	defer api.observe("GetKnownJobs", api.start(), &err)
+	return api.API.GetKnownJobs()
+}
+
+func (api *instrumentedAPI) GetCurrentStatus(job string) (status *jenkins.JobStatus, err error) {
+	defer api.observe("GetCurrentStatus", api.start(), &err)
+	return api.API.GetCurrentStatus(job)
+}
+
+func (api *instrumentedAPI) GetStatusForJob(job string, jobID string) (status *jenkins.JobStatus, err error) {
+	defer api.observe("GetStatusForJob", api.start(), &err)
+	return api.API.GetStatusForJob(job, jobID)
+}
+
+func (api *instrumentedAPI) Causes(status *jenkins.JobStatus) []string {
+	defer api.observe("Causes", api.start(), nil)
+	return api.API.Causes(status)
+}
+
+func (api *instrumentedAPI) CausesOfFailures(name, id string) []string {
+	defer api.observe("CausesOfFailures", api.start(), nil)
+	return api.API.CausesOfFailures(name, id)
+}
+
+func (api *instrumentedAPI) CausesOfPreviousFailures(job string) []string {
+	defer api.observe("CausesOfPreviousFailures", api.start(), nil)
+	return api.API.CausesOfPreviousFailures(job)
+}
+
+func (api *instrumentedAPI) GetFailedTestList(job string) (testCaseResult []jenkins.TestCase, err error) {
+	defer api.observe("GetFailedTestList", api.start(), &err)
+	return api.API.GetFailedTestList(job)
+}
+
+func (api *instrumentedAPI) GetFailedTestListFor(job, id string) (testCaseResult []jenkins.TestCase, err error) {
+	defer api.observe("GetFailedTestListFor", api.start(), &err)
+	return api.API.GetFailedTestListFor(job, id)
+}
+
+func (api *instrumentedAPI) GetLastLogLines(job, id string, lineCount int) (lines []string, err error) {
+	defer api.observe("GetLastLogLines", api.start(), &err)
+	return api.API.GetLastLogLines(job, id, lineCount)
+}
+
+func (api *instrumentedAPI) RunJob(job string) (err error) {
+	defer api.observe("RunJob", api.start(), &err)
+	return api.API.RunJob(job)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// HealthPath answers with 200 while server is running and with 503 once it is shutting down
+	HealthPath = "/healthz"
+	// RegistrationsPath on the admin listener lists all connected clients and their subscriptions
+	RegistrationsPath = "/debug/registrations"
+	// MetricsPath on the admin listener gives metrics in Prometheus text format
+	MetricsPath = "/metrics"
+)
+
+type health struct {
+	Status           string  `json:"status"`
+	Version          string  `json:"version"`
+	UptimeSeconds    float64 `json:"uptimeSeconds"`
+	ConnectedClients int     `json:"connectedClients"`
+}
+
+func (h *CliciServer) registerHealthEndpoint() {
+	h.ServeMux.HandleFunc(HealthPath, h.healthHandler)
+}
+
+func (h *CliciServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := health{
+		Status:           "ok",
+		Version:          Version,
+		UptimeSeconds:    time.Since(h.started).Seconds(),
+		ConnectedClients: h.processor.ConnectedClients(),
+	}
+	select {
+	case <-h.stopPolling:
+		status.Status = "shutting down"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+	}
+	writeJSON(w, status)
+}
+
+func (h *CliciServer) registrationsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.processor.Registrations())
+}
+
+func (h *CliciServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	registrations := h.processor.Registrations()
+	subscriptions, queueDepth, maxQueueDepth := 0, 0, 0
+	for _, connection := range registrations {
+		subscriptions += len(connection.Subscriptions)
+		queueDepth += connection.QueueDepth
+		if connection.QueueDepth > maxQueueDepth {
+			maxQueueDepth = connection.QueueDepth
+		}
+	}
+	writeGauge(w, "clici_connected_clients", "Clients connected over websocket or events stream", len(registrations))
+	writeGauge(w, "clici_subscriptions", "Jobs and patterns clients are registered for", subscriptions)
+	writeGauge(w, "clici_push_queue_depth", "Updates waiting to be sent, summed over all clients", queueDepth)
+	writeGauge(w, "clici_push_queue_depth_max", "Updates waiting to be sent to the slowest client", maxQueueDepth)
+	queueStats := h.processor.QueueStats()
+	writeCounter(w, "clici_push_coalesced_total", "Updates replaced by a newer update of the same job before being sent", queueStats.Coalesced)
+	writeCounter(w, "clici_push_dropped_total", "Updates dropped because client queue was full", queueStats.Dropped)
+	writeCounter(w, "clici_push_evicted_total", "Clients disconnected because they were too slow", queueStats.Evicted)
+	writeGauge(w, "clici_job_state_sequence", "Latest version given to any job state", h.processor.states.currentSequence())
+	inFlight := h.processor.requestsInFlight()
+	servers := make([]string, 0, len(inFlight))
+	for server := range inFlight {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	writeHeader(w, "clici_jenkins_requests_in_flight", "gauge", "Calls towards Jenkins API waiting for an answer")
+	for _, server := range servers {
+		fmt.Fprintf(w, "clici_jenkins_requests_in_flight{server=%s} %d\n", labelValue(server), inFlight[server])
+	}
+	h.processor.metrics.writeTo(w)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestHealthEndpoint(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		var status health
+		getJSON(t, fmt.Sprintf("http://localhost:%d%s", clici.Port, HealthPath), http.StatusOK, &status)
+		if status.Status != "ok" || status.ConnectedClients != 1 {
+			t.Fatalf("unexpected health status: %v", status)
+		}
+	})
+}
+
+func TestRegistrationsEndpoint(t *testing.T) {
+	withRunningConfiguredServer(t, withTestConfiguration, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{Authenticate: &Authenticate{Token: "viewer-token"}})
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+			},
+		})
+
+		getJSON(t, fmt.Sprintf("http://localhost:%d%s?token=viewer-token", clici.Port, RegistrationsPath), http.StatusNotFound, nil)
+
+		var registrations []ConnectionSubscriptions
+		getJSON(t, fmt.Sprintf("http://%v%s", clici.AdminAddr(), RegistrationsPath), http.StatusOK, &registrations)
+		if len(registrations) != 1 || len(registrations[0].Subscriptions) != 1 {
+			t.Fatalf("expected one client registered for one job, got %v", registrations)
+		}
+		if subscription := registrations[0].Subscriptions[0]; subscription.Server != "jenkins1" || subscription.Job != "job1" {
+			t.Fatalf("unexpected subscription: %v", subscription)
+		}
+	})
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "http://jenkins1", JobName: "job1"}},
+			},
+		})
+		clici.processor.ProcessMappings()
+
+		resp, err := noKeepAliveClient.Get(fmt.Sprintf("http://%v%s", clici.AdminAddr(), MetricsPath))
+		if err != nil {
+			t.Fatalf("could not get metrics: %v", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("could not read metrics: %v", err)
+		}
+		for _, expected := range []string{
+			"clici_connected_clients 1\n",
+			"clici_subscriptions 1\n",
+			"clici_push_queue_depth ",
+			`clici_jenkins_requests_total{server="http://jenkins1",method="GetCurrentStatus"} 1`,
+			`clici_poll_duration_seconds_count{server="http://jenkins1"} 1`,
+			`clici_jenkins_requests_in_flight{server="http://jenkins1"} 0`,
+			"# TYPE clici_jenkins_budget_exhausted_total counter",
+		} {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("metrics should contain %q, got:\n%s", expected, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+	"github.com/milanaleksic/clici/model"
+)
+
+// persistedJobState is how a job state is kept in the state file
+type persistedJobState struct {
+	Server   string            `json:"server"`
+	Job      string            `json:"job"`
+	Group    string            `json:"group,omitempty"`
+	Status   model.BuildStatus `json:"status"`
+	Building bool              `json:"building"`
+	Queued   bool              `json:"queued,omitempty"`
+	Causes   string            `json:"causes,omitempty"`
+	Culprits string            `json:"culprits,omitempty"`
+	Time     string            `json:"time,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Version  uint64            `json:"version"`
+	// Started is in milliseconds since the epoch and DurationMs in milliseconds, as on the wire
+	Started    int64 `json:"started,omitempty"`
+	DurationMs int64 `json:"durationMs,omitempty"`
+}
+
+// persistedState is the content of the state file: last known job states and completed builds per server
+type persistedState struct {
+	Epoch    uint64                                   `json:"epoch,omitempty"`
+	Sequence uint64                                   `json:"sequence"`
+	Jobs     []persistedJobState                      `json:"jobs"`
+	Builds   map[string]map[string]*jenkins.JobStatus `json:"builds"`
+}
+
+func toPersistedJobState(state VersionedJobState) persistedJobState {
+	return persistedJobState{
+		Server:     state.Server,
+		Job:        state.JobName,
+		Group:      state.Group,
+		Status:     state.PreviousState,
+		Building:   state.Building,
+		Queued:     state.Queued,
+		Causes:     state.CausesFriendly,
+		Culprits:   state.CulpritsFriendly,
+		Time:       state.Time,
+		Error:      errorMessage(state.Error),
+		Version:    state.Version,
+		Started:    toMillis(state.Started),
+		DurationMs: int64(state.Duration / time.Millisecond),
+	}
+}
+
+func fromPersistedJobState(state persistedJobState) VersionedJobState {
+	var err error
+	if state.Error != "" {
+		err = errors.New(state.Error)
+	}
+	return VersionedJobState{
+		JobState: model.JobState{
+			Server:           state.Server,
+			JobName:          state.Job,
+			Group:            state.Group,
+			PreviousState:    state.Status,
+			Building:         state.Building,
+			Queued:           state.Queued,
+			Started:          fromMillis(state.Started),
+			Duration:         time.Duration(state.DurationMs) * time.Millisecond,
+			CausesFriendly:   state.Causes,
+			CulpritsFriendly: state.Culprits,
+			Time:             state.Time,
+			Error:            err,
+		},
+		Version: state.Version,
+	}
+}
+
+// buildCache keeps statuses of completed job runs per server, shared by all APIs towards the same server
+type buildCache struct {
+	sync.RWMutex
+	builds  map[string]map[string]*jenkins.JobStatus
+	changed bool
+}
+
+func newBuildCache() *buildCache {
+	return &buildCache{
+		builds: make(map[string]map[string]*jenkins.JobStatus),
+	}
+}
+
+func (cache *buildCache) forServer(server string) jenkins.StatusCache {
+	return &serverBuildCache{cache: cache, server: server}
+}
+
+// takeChanged tells if anything was added since the last call
+func (cache *buildCache) takeChanged() bool {
+	cache.Lock()
+	defer cache.Unlock()
+	changed := cache.changed
+	cache.changed = false
+	return changed
+}
+
+// copy gives a copy of all builds which can be used without holding the lock
+func (cache *buildCache) copy() map[string]map[string]*jenkins.JobStatus {
+	cache.RLock()
+	defer cache.RUnlock()
+	result := make(map[string]map[string]*jenkins.JobStatus, len(cache.builds))
+	for server, builds := range cache.builds {
+		result[server] = make(map[string]*jenkins.JobStatus, len(builds))
+		for key, status := range builds {
+			result[server][key] = status
+		}
+	}
+	return result
+}
+
+func (cache *buildCache) restore(builds map[string]map[string]*jenkins.JobStatus) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.builds = builds
+	if cache.builds == nil {
+		cache.builds = make(map[string]map[string]*jenkins.JobStatus)
+	}
+	cache.changed = false
+}
+
+// serverBuildCache is a jenkins.StatusCache for a single server
+type serverBuildCache struct {
+	cache  *buildCache
+	server string
+}
+
+func (serverCache *serverBuildCache) Get(key string) (status *jenkins.JobStatus, ok bool) {
+	serverCache.cache.RLock()
+	defer serverCache.cache.RUnlock()
+	status, ok = serverCache.cache.builds[serverCache.server][key]
+	return
+}
+
+func (serverCache *serverBuildCache) Put(key string, status *jenkins.JobStatus) {
+	serverCache.cache.Lock()
+	defer serverCache.cache.Unlock()
+	builds, ok := serverCache.cache.builds[serverCache.server]
+	if !ok {
+		builds = make(map[string]*jenkins.JobStatus)
+		serverCache.cache.builds[serverCache.server] = builds
+	}
+	builds[key] = status
+	serverCache.cache.changed = true
+}
+
+// LoadState restores job states and completed builds saved by SaveState. Missing file is not an error,
+// it just means there is nothing to restore
+func (processor *Processor) LoadState(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	persisted := persistedState{}
+	if err := json.NewDecoder(file).Decode(&persisted); err != nil {
+		return fmt.Errorf("Could not parse server state %v: %v", path, err)
+	}
+	states := make([]VersionedJobState, 0, len(persisted.Jobs))
+	for _, job := range persisted.Jobs {
+		states = append(states, fromPersistedJobState(job))
+	}
+	processor.saveLock.Lock()
+	defer processor.saveLock.Unlock()
+	processor.states.restore(states, persisted.Epoch, persisted.Sequence)
+	processor.builds.restore(persisted.Builds)
+	processor.savedSequence = persisted.Sequence
+	return nil
+}
+
+// SaveState writes job states and completed builds to the file, if anything has changed since the last save.
+// File is replaced atomically, so a crash while saving leaves the previous state intact
+func (processor *Processor) SaveState(path string) error {
+	processor.saveLock.Lock()
+	defer processor.saveLock.Unlock()
+	states, epoch, sequence := processor.states.all()
+	buildsChanged := processor.builds.takeChanged()
+	if sequence == processor.savedSequence && !buildsChanged {
+		return nil
+	}
+	sort.Sort(byServerAndJob(states))
+	persisted := persistedState{
+		Epoch:    epoch,
+		Sequence: sequence,
+		Jobs:     make([]persistedJobState, 0, len(states)),
+		Builds:   processor.builds.copy(),
+	}
+	for _, state := range states {
+		persisted.Jobs = append(persisted.Jobs, toPersistedJobState(state))
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(path, data); err != nil {
+		if buildsChanged {
+			processor.builds.Lock()
+			processor.builds.changed = true
+			processor.builds.Unlock()
+		}
+		return err
+	}
+	processor.savedSequence = sequence
+	return nil
+}
+
+// writeFileAtomically writes data to a temporary file in the same directory and renames it over the target
+func writeFileAtomically(path string, data []byte) (err error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	return os.Rename(file.Name(), path)
+}
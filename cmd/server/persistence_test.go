@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/jenkins"
+	"github.com/milanaleksic/clici/model"
+)
+
+func TestStateSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clici")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	stateFile := filepath.Join(dir, "state.json")
+
+	processor := NewProcessorWithSupplier(func(serverLocation string, username, password string) jenkins.API {
+		return &testAPI{color: "blue"}
+	})
+	processor.RegisterClient(ConnectionID("1"), "jenkins1", "job1")
+	processor.ProcessMappings()
+	processor.builds.forServer("jenkins1").Put("job1-10", &jenkins.JobStatus{ID: "10", Result: "SUCCESS"})
+	if err := processor.SaveState(stateFile); err != nil {
+		t.Fatalf("could not save state: %v", err)
+	}
+
+	restarted := NewProcessorWithSupplier(func(serverLocation string, username, password string) jenkins.API {
+		return &testAPI{color: "blue"}
+	})
+	if err := restarted.LoadState(stateFile); err != nil {
+		t.Fatalf("could not load state: %v", err)
+	}
+	states := restarted.KnownStates(func(server, jobName string) bool { return true })
+	if len(states) != 1 || states[0].Server != "jenkins1" || states[0].JobName != "job1" {
+		t.Fatalf("expected job1 state to be restored, got %v", states)
+	}
+	if sequence := restarted.states.currentSequence(); sequence != processor.states.currentSequence() {
+		t.Fatalf("sequence should continue from %v, got %v", processor.states.currentSequence(), sequence)
+	}
+	if status, ok := restarted.builds.forServer("jenkins1").Get("job1-10"); !ok || status.Result != "SUCCESS" {
+		t.Fatalf("completed build should be restored, got %v", status)
+	}
+}
+
+func TestBuildTimingAndQueueSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clici")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	stateFile := filepath.Join(dir, "state.json")
+
+	processor := NewProcessorWithSupplier(jenkins.NewAPI)
+	saved, _ := processor.states.update("jenkins1", model.JobState{
+		Server:   "jenkins1",
+		JobName:  "job1",
+		Queued:   true,
+		Started:  time.Unix(1500000000, 0),
+		Duration: 90 * time.Second,
+	})
+	if err := processor.SaveState(stateFile); err != nil {
+		t.Fatalf("could not save state: %v", err)
+	}
+
+	restarted := NewProcessorWithSupplier(jenkins.NewAPI)
+	if err := restarted.LoadState(stateFile); err != nil {
+		t.Fatalf("could not load state: %v", err)
+	}
+	states, epoch, _ := restarted.states.all()
+	if len(states) != 1 || !sameJobState(states[0].JobState, saved.JobState) {
+		t.Fatalf("expected %v to be restored, got %v", saved, states)
+	}
+	if epoch != processor.states.currentEpoch() {
+		t.Fatalf("epoch should be restored as %v, got %v", processor.states.currentEpoch(), epoch)
+	}
+}
+
+func TestLoadingMissingStateIsNotAnError(t *testing.T) {
+	processor := NewProcessorWithSupplier(jenkins.NewAPI)
+	if err := processor.LoadState(filepath.Join(os.TempDir(), "clici-missing-state.json")); err != nil {
+		t.Fatalf("missing state file should be ignored, got %v", err)
+	}
+}
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/milanaleksic/clici/cmd/main/controller"
 	"github.com/milanaleksic/clici/cmd/main/view"
@@ -17,7 +22,27 @@ type Processor struct {
 	mapping     *Mapping
 	controllers map[string](*controller.Controller)
 	apiSupplier APISupplier
-	listeners   map[ConnectionID](chan<- model.JobState)
+	listeners   map[ConnectionID]*UpdateQueue
+	states      *jobStateStore
+	credentials map[string]JenkinsCredentials
+	budgets     map[string]*requestBudget
+	// configured are the Jenkins servers having credentials or a budget set; clients can't make server call any other
+	configured map[string]bool
+	// credentialsLock guards credentials, budgets and configured servers, separately from refreshLock so relayed actions don't wait for refreshes
+	credentialsLock sync.RWMutex
+	// refreshLock makes sure periodic polling and push-driven refreshes don't use the same controller at the same time
+	refreshLock   sync.Mutex
+	listenersLock sync.RWMutex
+	queueStats    QueueStats
+	metrics       *metrics
+	builds        *buildCache
+	// saveLock serializes saving and loading of the state file, savedSequence is the sequence last saved
+	saveLock      sync.Mutex
+	savedSequence uint64
+	// QueueCapacity is the maximum number of distinct jobs waiting to be sent to a single client
+	QueueCapacity int
+	// SlowConsumerTimeout is how long a client can have updates waiting before it gets disconnected
+	SlowConsumerTimeout time.Duration
 }
 
 // NewProcessorWithSupplier is able to create Processor with the custom supplier
@@ -26,77 +51,370 @@ func NewProcessorWithSupplier(apiSupplier APISupplier) *Processor {
 		apiSupplier: apiSupplier,
 		mapping:     NewMapping(),
 		controllers: make(map[string](*controller.Controller)),
-		listeners:   make(map[ConnectionID](chan<- model.JobState)),
+		listeners:   make(map[ConnectionID]*UpdateQueue),
+		states:      newJobStateStore(),
+		credentials: make(map[string]JenkinsCredentials),
+		budgets:     make(map[string]*requestBudget),
+		configured:  make(map[string]bool),
+		metrics:     newMetrics(),
+		builds:      newBuildCache(),
+
+		QueueCapacity:       DefaultQueueCapacity,
+		SlowConsumerTimeout: DefaultSlowConsumerTimeout,
 	}
 }
 
 // ProcessMappings is the main call for processor which executes a blocking call on all controllers and updates
 // which connections need to be updated with which states
 func (processor *Processor) ProcessMappings() {
-	registrationsPerServer := processor.mapping.GetAllUniqueJobs()
+	registrationsPerServer := processor.registrationsPerServer()
 	log.Printf("Known Registrations: %v", registrationsPerServer)
-	for server, registrations := range registrationsPerServer {
-		cont, ok := processor.controllers[server]
-		if !ok {
-			cont = &controller.Controller{
-				APIs: []controller.JenkinsAPIRoot{
-					{
-						API:    processor.apiSupplier(server, "", ""),
-						Server: server,
-						Jobs:   registrations,
-					},
+	for server := range registrationsPerServer {
+		processor.pollServer(server)
+	}
+	return
+}
+
+// PollSpread refreshes one server at a time, spreading the servers evenly over the interval instead of
+// polling all of them at once. It gives back false if it was interrupted by stop
+func (processor *Processor) PollSpread(interval time.Duration, stop <-chan struct{}) bool {
+	registrationsPerServer := processor.registrationsPerServer()
+	servers := make([]string, 0, len(registrationsPerServer))
+	for server := range registrationsPerServer {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	if len(servers) == 0 {
+		select {
+		case <-time.After(interval):
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	slot := interval / time.Duration(len(servers))
+	for _, server := range servers {
+		select {
+		case <-time.After(slot):
+		case <-stop:
+			return false
+		}
+		processor.pollServer(server)
+	}
+	return true
+}
+
+func (processor *Processor) registrationsPerServer() map[string][]string {
+	registrationsPerServer := processor.mapping.GetAllUniqueJobs()
+	for server, patterns := range processor.mapping.GetAllPatterns() {
+		registrationsPerServer[server] = append(registrationsPerServer[server], patterns...)
+	}
+	return registrationsPerServer
+}
+
+// pollServer refreshes all jobs registered for the server, as registered at the moment of the call
+func (processor *Processor) pollServer(server string) {
+	registrations := processor.registrationsPerServer()[server]
+	if len(registrations) == 0 {
+		return
+	}
+	processor.refreshLock.Lock()
+	defer processor.refreshLock.Unlock()
+	started := time.Now()
+	processor.controllerForServer(server).RefreshNodeInformation(registrations)
+	processor.metrics.observePoll(server, started)
+}
+
+// RefreshJob executes a blocking refresh of a single job and updates connections interested in it,
+// without waiting for the next ProcessMappings pass. Jobs nobody is interested in are ignored
+func (processor *Processor) RefreshJob(server string, jobName string) {
+	if len(processor.mapping.FindAllRegisteredConnectionsForServerAndJob(server, jobName)) == 0 {
+		log.Printf("Nobody is registered for job %v on server %v, ignoring refresh", jobName, server)
+		return
+	}
+	processor.refreshLock.Lock()
+	defer processor.refreshLock.Unlock()
+	processor.controllerForServer(server).RefreshNodeInformation([]string{jobName})
+}
+
+// SetCredentials sets credentials to be used for Jenkins servers. Servers without credentials are accessed anonymously
+func (processor *Processor) SetCredentials(credentials []JenkinsCredentials) {
+	processor.credentialsLock.Lock()
+	defer processor.credentialsLock.Unlock()
+	for _, credential := range credentials {
+		processor.credentials[credential.Location] = credential
+		processor.configured[credential.Location] = true
+	}
+}
+
+func (processor *Processor) controllerForServer(server string) *controller.Controller {
+	cont, ok := processor.controllers[server]
+	if !ok {
+		cont = &controller.Controller{
+			APIs: []controller.JenkinsAPIRoot{
+				{
+					API:    processor.newAPI(server),
+					Server: server,
 				},
-				View: view.CallbackAsView(processor.processState(server)),
-			}
-			processor.controllers[server] = cont
+			},
+			View: view.CallbackAsView(processor.processState(server)),
 		}
-		cont.RefreshNodeInformation(registrations)
+		processor.controllers[server] = cont
+	}
+	return cont
+}
+
+// SetBudgets limits requests towards Jenkins servers. Servers without a budget get the default one
+func (processor *Processor) SetBudgets(budgets []RequestBudget) {
+	processor.credentialsLock.Lock()
+	defer processor.credentialsLock.Unlock()
+	for _, budget := range budgets {
+		processor.budgets[budget.Location] = newRequestBudget(budget)
+		processor.configured[budget.Location] = true
+	}
+}
+
+// checkServer gives back an error if the Jenkins server is not configured. Clients may not make the server
+// call arbitrary locations (possibly with credentials of another server)
+func (processor *Processor) checkServer(server string) error {
+	processor.credentialsLock.RLock()
+	defer processor.credentialsLock.RUnlock()
+	if !processor.configured[server] {
+		return fmt.Errorf("Jenkins server %v is not configured", server)
+	}
+	return nil
+}
+
+// checkServers gives back an error for the first job or pattern on a Jenkins server which is not configured
+func (processor *Processor) checkServers(jobs []*Register_Job, patterns []*Register_Pattern) error {
+	for _, job := range jobs {
+		if err := processor.checkServer(job.ServerLocation); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range patterns {
+		if err := processor.checkServer(pattern.ServerLocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// budgetFor gives the request budget shared by all APIs towards the server
+func (processor *Processor) budgetFor(server string) *requestBudget {
+	processor.credentialsLock.Lock()
+	defer processor.credentialsLock.Unlock()
+	budget, ok := processor.budgets[server]
+	if !ok {
+		budget = newRequestBudget(RequestBudget{Location: server})
+		processor.budgets[server] = budget
+	}
+	return budget
+}
+
+// requestsInFlight gives the number of requests waiting for an answer, per Jenkins server
+func (processor *Processor) requestsInFlight() map[string]int {
+	processor.credentialsLock.RLock()
+	defer processor.credentialsLock.RUnlock()
+	result := make(map[string]int, len(processor.budgets))
+	for server, budget := range processor.budgets {
+		result[server] = budget.current()
+	}
+	return result
+}
+
+// newAPI creates an API towards the server using configured credentials and request budget. Each caller gets its own API
+func (processor *Processor) newAPI(server string) jenkins.API {
+	budget := processor.budgetFor(server)
+	processor.credentialsLock.RLock()
+	credentials := processor.credentials[server]
+	processor.credentialsLock.RUnlock()
+	api := processor.apiSupplier(server, credentials.Username, credentials.Password)
+	if serverAPI, ok := api.(*jenkins.ServerAPI); ok {
+		serverAPI.Cache = processor.builds.forServer(server)
+	}
+	return &instrumentedAPI{
+		API:     api,
+		server:  server,
+		budget:  budget,
+		metrics: processor.metrics,
 	}
-	return
 }
 
 func (processor *Processor) processState(server string) func(state *model.State) {
 	return func(state *model.State) {
-		resp := make(map[ConnectionID][]model.JobState)
+		resp := make(map[ConnectionID][]VersionedJobState)
 		log.Printf("State received: %v", state)
 		for _, jobState := range state.JobStates {
+			versioned, changed := processor.states.update(server, jobState)
+			if !changed {
+				continue
+			}
 			connectionIds := processor.mapping.FindAllRegisteredConnectionsForServerAndJob(server, jobState.JobName)
 			for _, connectionID := range connectionIds {
-				knownJobStatesPerConnID, ok := resp[connectionID]
-				if !ok {
-					knownJobStatesPerConnID = make([]model.JobState, 0)
-				}
-				resp[connectionID] = append(knownJobStatesPerConnID, jobState)
+				resp[connectionID] = append(resp[connectionID], versioned)
 			}
 		}
+		processor.listenersLock.RLock()
+		defer processor.listenersLock.RUnlock()
 		for id, models := range resp {
 			listener, ok := processor.listeners[id]
 			if !ok {
-				log.Printf("No listener found for id: %v; all known listeners: %v", id, processor.listeners)
+				log.Printf("No listener found for id: %v", id)
 				continue
 			}
 			for _, m := range models {
-				listener <- m
+				listener.push(m)
 			}
 		}
 	}
 }
 
-// RegisterClient will register client in the in-memory database and will register the channel as recipient of state changes
-func (processor *Processor) RegisterClient(id ConnectionID, serverLocation string, jobName string, outputChannel chan<- model.JobState) {
+// StatesFor gives the states of all jobs a connection is registered for, which have changed after resumeFrom.
+// If resumeFrom is zero or not known to this server (it is newer than the current sequence, or was given out
+// in another epoch) all states are given back and snapshot is set
+func (processor *Processor) StatesFor(id ConnectionID, resumeEpoch, resumeFrom uint64) (states []VersionedJobState, snapshot bool, epoch, sequence uint64) {
+	epoch, sequence = processor.states.position()
+	if resumeFrom == 0 || resumeFrom > sequence || resumeEpoch != epoch {
+		resumeFrom = 0
+		snapshot = true
+	}
+	states = processor.states.changedSince(resumeFrom, func(server, jobName string) bool {
+		return processor.mapping.IsConnectionRegisteredForServerAndJob(id, server, jobName)
+	})
+	return
+}
+
+// ConnectedClients gives the number of clients currently connected
+func (processor *Processor) ConnectedClients() int {
+	processor.listenersLock.RLock()
+	defer processor.listenersLock.RUnlock()
+	return len(processor.listeners)
+}
+
+// Registrations lists all connected clients with their subscriptions and number of updates waiting to be sent
+func (processor *Processor) Registrations() []ConnectionSubscriptions {
+	subscriptions := make(map[ConnectionID]*ConnectionSubscriptions)
+	processor.listenersLock.RLock()
+	for id, queue := range processor.listeners {
+		subscriptions[id] = &ConnectionSubscriptions{ConnectionID: id, QueueDepth: queue.Len()}
+	}
+	processor.listenersLock.RUnlock()
+	for _, known := range processor.mapping.GetAllSubscriptions() {
+		if connection, ok := subscriptions[known.ConnectionID]; ok {
+			connection.Subscriptions = known.Subscriptions
+		}
+	}
+	result := make([]ConnectionSubscriptions, 0, len(subscriptions))
+	for _, connection := range subscriptions {
+		result = append(result, *connection)
+	}
+	sort.Sort(byConnectionID(result))
+	return result
+}
+
+// KnownStates gives the last known states of all jobs accepted by the filter, sorted by server and job name
+func (processor *Processor) KnownStates(accept func(server, jobName string) bool) []VersionedJobState {
+	states := processor.states.changedSince(0, accept)
+	sort.Sort(byServerAndJob(states))
+	return states
+}
+
+// Connect creates the queue in which state changes for a connection will be placed
+func (processor *Processor) Connect(id ConnectionID) *UpdateQueue {
+	queue := newUpdateQueue(processor.QueueCapacity, processor.SlowConsumerTimeout, &processor.queueStats)
+	processor.listenersLock.Lock()
+	defer processor.listenersLock.Unlock()
+	processor.listeners[id] = queue
+	return queue
+}
+
+// QueueStats gives a snapshot of counters of all client queues
+func (processor *Processor) QueueStats() QueueStats {
+	return QueueStats{
+		Coalesced: atomic.LoadUint64(&processor.queueStats.Coalesced),
+		Dropped:   atomic.LoadUint64(&processor.queueStats.Dropped),
+		Evicted:   atomic.LoadUint64(&processor.queueStats.Evicted),
+	}
+}
+
+// RegisterClient will register client in the in-memory database, state changes will be sent to its queue
+func (processor *Processor) RegisterClient(id ConnectionID, serverLocation string, jobName string) {
 	processor.mapping.RegisterClient(id, registration{
 		ConnectionID:   id,
 		ServerLocation: serverLocation,
 		JobName:        jobName,
 	})
-	processor.listeners[id] = outputChannel
+}
+
+// RegisterPattern will register client in the in-memory database for all jobs on a server matching a glob,
+// state changes will be sent to its queue
+func (processor *Processor) RegisterPattern(id ConnectionID, serverLocation string, jobGlob string) {
+	processor.mapping.RegisterPattern(id, patternRegistration{
+		ConnectionID:   id,
+		ServerLocation: serverLocation,
+		JobGlob:        jobGlob,
+	})
+}
+
+// UnRegisterJobs will remove only the given jobs and patterns of a client, the client stays connected
+func (processor *Processor) UnRegisterJobs(id ConnectionID, jobs []*Register_Job, patterns []*Register_Pattern) {
+	processor.mapping.UnRegisterJobs(id, toRegistrations(id, jobs), toPatternRegistrations(id, patterns))
+	processor.pruneUnsubscribed()
+}
+
+// ReplaceSubscriptions will swap all jobs and patterns of a client with the given ones
+func (processor *Processor) ReplaceSubscriptions(id ConnectionID, jobs []*Register_Job, patterns []*Register_Pattern) {
+	processor.mapping.ReplaceSubscriptions(id, toRegistrations(id, jobs), toPatternRegistrations(id, patterns))
+	processor.pruneUnsubscribed()
+}
+
+// pruneUnsubscribed forgets states of the jobs nobody is registered for anymore, together with controllers
+// of the servers nobody is interested in, so they don't keep every job that was ever subscribed to
+func (processor *Processor) pruneUnsubscribed() {
+	subscribed := func(server, jobName string) bool {
+		return len(processor.mapping.FindAllRegisteredConnectionsForServerAndJob(server, jobName)) != 0
+	}
+	processor.refreshLock.Lock()
+	defer processor.refreshLock.Unlock()
+	for server, cont := range processor.controllers {
+		left := cont.ForgetJobs(func(jobName string) bool {
+			return subscribed(server, jobName)
+		})
+		if left == 0 {
+			delete(processor.controllers, server)
+		}
+	}
+	processor.states.forget(subscribed)
+}
+
+func toRegistrations(id ConnectionID, jobs []*Register_Job) (regs []registration) {
+	for _, job := range jobs {
+		regs = append(regs, registration{
+			ConnectionID:   id,
+			ServerLocation: job.ServerLocation,
+			JobName:        job.JobName,
+		})
+	}
+	return
+}
+
+func toPatternRegistrations(id ConnectionID, patterns []*Register_Pattern) (patternRegs []patternRegistration) {
+	for _, pattern := range patterns {
+		patternRegs = append(patternRegs, patternRegistration{
+			ConnectionID:   id,
+			ServerLocation: pattern.ServerLocation,
+			JobGlob:        pattern.JobGlob,
+		})
+	}
+	return
 }
 
 // UnRegisterClient will remove all mappings
 func (processor *Processor) UnRegisterClient(id ConnectionID) {
 	processor.mapping.UnRegisterClient(id)
-	if mapping, ok := processor.listeners[id]; ok {
-		close(mapping)
-	}
-	processor.listeners[id] = nil
+	processor.listenersLock.Lock()
+	delete(processor.listeners, id)
+	processor.listenersLock.Unlock()
+	processor.pruneUnsubscribed()
 }
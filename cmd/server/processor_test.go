@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"log"
-	"sync"
 
 	"github.com/milanaleksic/clici/jenkins"
 	"github.com/milanaleksic/clici/model"
@@ -91,22 +90,13 @@ func (api *testAPI) GetFailedTestListFor(job, id string) (testCaseResult []jenki
 }
 
 func (api *testAPI) GetFailedTestList(job string) (testCaseResult []jenkins.TestCase, err error) {
-	var set []jenkins.TestCase
-	var randomTests = []string{
-		"test1",
-		"test2",
-		"test3",
-		"test4",
-	}
-	for i := 0; i < rand.Intn(5); i++ {
-		aCase := jenkins.TestCase{
-			ClassName: randomTests[rand.Intn(len(randomTests))],
-			Name:      randomTests[rand.Intn(len(randomTests))],
-			Status:    "FAILED",
-		}
-		set = append(set, aCase)
-	}
-	return
+	return []jenkins.TestCase{{
+		ClassName:   "test1",
+		Name:        "test2",
+		Status:      "FAILED",
+		Age:         3,
+		FailedSince: 40,
+	}}, nil
 }
 
 // RunJob will execute a job (expected - without parameters)
@@ -115,34 +105,82 @@ func (api *testAPI) RunJob(job string) error {
 }
 
 func TestProcessor(t *testing.T) {
-	var wg sync.WaitGroup
-	wg.Add(1)
-
 	api := testAPI{color: "blue"}
-	outputChannel := make(chan model.JobState)
 	processor := NewProcessorWithSupplier(
 		func(serverLocation string, username, server string) jenkins.API {
 			return &api
 		},
 	)
 
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-		select {
-		case jobState := <-outputChannel:
-			log.Printf("jobState=%v", jobState)
-			wg.Done()
-		case <-ticker.C:
-			wg.Done()
-			t.Fatal("Timed out waiting for the response from processor")
+	queue := processor.Connect("12345")
+	processor.RegisterClient("12345", "localhost", "job1")
+	defer processor.UnRegisterClient("12345")
+
+	processor.ProcessMappings()
+
+	select {
+	case <-queue.Ready():
+		states, _ := queue.Take()
+		log.Printf("jobStates=%v", states)
+		if len(states) != 1 || states[0].JobName != "job1" {
+			t.Fatalf("Expected state of job1, got: %v", states)
 		}
-	}()
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Timed out waiting for the response from processor")
+	}
+}
+
+func TestOnlyChangedStatesAreStored(t *testing.T) {
+	store := newJobStateStore()
+	state := model.JobState{JobName: "job1", Server: "jenkins", PreviousState: model.Success}
 
-	processor.RegisterClient("12345", "localhost", "job1", outputChannel)
-	defer processor.mapping.UnRegisterClient("12345")
+	first, changed := store.update("jenkins", state)
+	if !changed || first.Version != 1 {
+		t.Fatalf("First state must be a change with version 1, got: %v", first)
+	}
+	if _, changed = store.update("jenkins", state); changed {
+		t.Fatal("Same state must not be a change")
+	}
+	state.PreviousState = model.Failure
+	if second, changed := store.update("jenkins", state); !changed || second.Version != 2 {
+		t.Fatalf("Failure must be a change with version 2, got: %v", second)
+	}
+	state.Time = "1 minute ago"
+	if _, changed = store.update("jenkins", state); changed {
+		t.Fatal("Relative time text alone must not be a change")
+	}
+	state.Started = time.Unix(1500000000, 0)
+	if third, changed := store.update("jenkins", state); !changed || third.Version != 3 {
+		t.Fatalf("New build start must be a change with version 3, got: %v", third)
+	}
+	if states := store.changedSince(2, func(server, jobName string) bool { return true }); len(states) != 1 || states[0].PreviousState != model.Failure {
+		t.Fatalf("Only the latest state expected, got: %v", states)
+	}
+}
 
+func TestStatesOfUnsubscribedJobsAreForgotten(t *testing.T) {
+	processor := NewProcessorWithSupplier(func(serverLocation string, username, password string) jenkins.API {
+		return &testAPI{color: "blue"}
+	})
+	processor.RegisterClient("1", "jenkins1", "job1")
+	processor.RegisterClient("2", "jenkins1", "job1")
+	processor.RegisterClient("2", "jenkins2", "job1")
 	processor.ProcessMappings()
+	everything := func(server, jobName string) bool { return true }
+	if states := processor.KnownStates(everything); len(states) != 2 {
+		t.Fatalf("Expected states of job1 on both servers, got: %v", states)
+	}
+
+	processor.UnRegisterClient("2")
+	if states := processor.KnownStates(everything); len(states) != 1 || states[0].Server != "jenkins1" {
+		t.Fatalf("Only job1 on jenkins1 is still subscribed to, got: %v", states)
+	}
+	if _, ok := processor.controllers["jenkins2"]; ok {
+		t.Fatal("Controller of a server nobody is interested in should be removed")
+	}
 
-	wg.Wait()
+	processor.UnRegisterJobs("1", []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}}, nil)
+	if states := processor.KnownStates(everything); len(states) != 0 || len(processor.controllers) != 0 {
+		t.Fatalf("Nothing is subscribed to, got states %v and controllers %v", states, processor.controllers)
+	}
 }
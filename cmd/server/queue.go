@@ -0,0 +1,119 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQueueCapacity is the maximum number of distinct jobs waiting to be sent to a single client
+	DefaultQueueCapacity = 512
+	// DefaultSlowConsumerTimeout is how long a client can have updates waiting before it gets disconnected
+	DefaultSlowConsumerTimeout = 30 * time.Second
+)
+
+// QueueStats are counters shared by all client queues of a processor
+type QueueStats struct {
+	// Coalesced counts updates which replaced a not yet sent update of the same job
+	Coalesced uint64
+	// Dropped counts updates which were not queued because the queue was full
+	Dropped uint64
+	// Evicted counts clients disconnected because they stayed behind for too long
+	Evicted uint64
+}
+
+// UpdateQueue is a bounded queue of job state updates for a single client. Pushing never blocks:
+// a newer update of a job replaces the one that wasn't sent yet, and when the queue is full updates are dropped
+// (and consumer is asked to resync). If updates are waiting for longer than the slow consumer timeout
+// the queue gets evicted
+type UpdateQueue struct {
+	lock         sync.Mutex
+	pending      map[jobKey]VersionedJobState
+	order        []jobKey
+	pendingSince time.Time
+	resync       bool
+	capacity     int
+	timeout      time.Duration
+	stats        *QueueStats
+	ready        chan struct{}
+	evicted      chan struct{}
+	isEvicted    bool
+}
+
+func newUpdateQueue(capacity int, timeout time.Duration, stats *QueueStats) *UpdateQueue {
+	return &UpdateQueue{
+		pending:  make(map[jobKey]VersionedJobState),
+		capacity: capacity,
+		timeout:  timeout,
+		stats:    stats,
+		ready:    make(chan struct{}, 1),
+		evicted:  make(chan struct{}),
+	}
+}
+
+// Ready gets a signal whenever there are updates to be taken
+func (queue *UpdateQueue) Ready() <-chan struct{} {
+	return queue.ready
+}
+
+// Evicted is closed when the consumer has been too slow and should be disconnected
+func (queue *UpdateQueue) Evicted() <-chan struct{} {
+	return queue.evicted
+}
+
+// Len gives the number of updates waiting to be taken
+func (queue *UpdateQueue) Len() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.order)
+}
+
+// Take gives back all waiting updates in the order they were first queued and empties the queue.
+// If some updates were dropped since the last call, resync is set and consumer should fetch all states again
+func (queue *UpdateQueue) Take() (states []VersionedJobState, resync bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	for _, key := range queue.order {
+		states = append(states, queue.pending[key])
+	}
+	resync = queue.resync
+	queue.pending = make(map[jobKey]VersionedJobState)
+	queue.order = nil
+	queue.pendingSince = time.Time{}
+	queue.resync = false
+	return
+}
+
+func (queue *UpdateQueue) push(state VersionedJobState) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if queue.isEvicted {
+		return
+	}
+	now := time.Now()
+	if !queue.pendingSince.IsZero() && now.Sub(queue.pendingSince) > queue.timeout {
+		queue.isEvicted = true
+		atomic.AddUint64(&queue.stats.Evicted, 1)
+		close(queue.evicted)
+		return
+	}
+	key := jobKey{server: state.Server, jobName: state.JobName}
+	if _, ok := queue.pending[key]; ok {
+		atomic.AddUint64(&queue.stats.Coalesced, 1)
+	} else if len(queue.order) >= queue.capacity {
+		atomic.AddUint64(&queue.stats.Dropped, 1)
+		queue.resync = true
+		return
+	} else {
+		queue.order = append(queue.order, key)
+	}
+	queue.pending[key] = state
+	if queue.pendingSince.IsZero() {
+		queue.pendingSince = now
+	}
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+func versionedState(jobName string, version uint64) VersionedJobState {
+	return VersionedJobState{
+		JobState: model.JobState{Server: "jenkins", JobName: jobName},
+		Version:  version,
+	}
+}
+
+func TestQueueCoalescesUpdatesOfTheSameJob(t *testing.T) {
+	stats := &QueueStats{}
+	queue := newUpdateQueue(10, time.Minute, stats)
+	queue.push(versionedState("job1", 1))
+	queue.push(versionedState("job2", 2))
+	queue.push(versionedState("job1", 3))
+
+	states, resync := queue.Take()
+	if resync || len(states) != 2 {
+		t.Fatalf("Expected 2 coalesced states without resync, got: %v (resync=%v)", states, resync)
+	}
+	if states[0].JobName != "job1" || states[0].Version != 3 || states[1].JobName != "job2" {
+		t.Fatalf("Expected latest job1 first, then job2, got: %v", states)
+	}
+	if stats.Coalesced != 1 || stats.Dropped != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if queue.Len() != 0 {
+		t.Fatal("Queue should be empty after taking all updates")
+	}
+}
+
+func TestFullQueueDropsUpdatesAndAsksForResync(t *testing.T) {
+	stats := &QueueStats{}
+	queue := newUpdateQueue(1, time.Minute, stats)
+	queue.push(versionedState("job1", 1))
+	queue.push(versionedState("job2", 2))
+
+	states, resync := queue.Take()
+	if !resync || len(states) != 1 {
+		t.Fatalf("Expected 1 state and resync, got: %v (resync=%v)", states, resync)
+	}
+	if stats.Dropped != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSlowConsumerGetsEvicted(t *testing.T) {
+	stats := &QueueStats{}
+	queue := newUpdateQueue(10, time.Millisecond, stats)
+	queue.push(versionedState("job1", 1))
+	time.Sleep(5 * time.Millisecond)
+	queue.push(versionedState("job1", 2))
+
+	select {
+	case <-queue.Evicted():
+	default:
+		t.Fatal("Queue with updates waiting longer than timeout should have been evicted")
+	}
+	if stats.Evicted != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	queue.push(versionedState("job1", 3))
+	if stats.Evicted != 1 {
+		t.Fatal("Queue must be evicted only once")
+	}
+}
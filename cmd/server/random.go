@@ -7,12 +7,12 @@ import (
 )
 
 // RandomString is far from perfect random string generator,
-// it is based on underlying len number of bytes which are then base64 encoded
+// it is based on underlying len number of bytes which are then base64 encoded (URL-safe, so it can be used as a path)
 func randomStringFromBytes(len int) string {
 	randData := make([]byte, len)
 	_, err := rand.Read(randData)
 	if err != nil {
 		log.Fatalf("Could not generate random secret: %v", err)
 	}
-	return base64.StdEncoding.EncodeToString(randData)
+	return base64.RawURLEncoding.EncodeToString(randData)
 }
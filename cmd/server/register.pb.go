@@ -10,7 +10,24 @@ It is generated from these files:
 
 It has these top-level messages:
 	Register
+	Unregister
+	ReplaceSubscriptions
+	Hello
+	HelloResponse
+	Authenticate
+	Ping
+	Pong
+	ActionRequest
+	ClientMessage
 	RegisterResponse
+	JobState
+	StateUpdate
+	TestCase
+	Cause
+	BuildStatus
+	ActionResponse
+	Goodbye
+	ServerMessage
 */
 package server
 
@@ -24,7 +41,14 @@ var _ = fmt.Errorf
 var _ = math.Inf
 
 type Register struct {
-	Jobs []*Register_Job `protobuf:"bytes,1,rep,name=jobs" json:"jobs,omitempty"`
+	Jobs     []*Register_Job     `protobuf:"bytes,1,rep,name=jobs" json:"jobs,omitempty"`
+	Patterns []*Register_Pattern `protobuf:"bytes,2,rep,name=patterns" json:"patterns,omitempty"`
+	// resumeFrom is the last sequence client has seen before reconnecting, only newer states will be sent.
+	// Zero (or a sequence unknown to the server) means a full snapshot is needed
+	ResumeFrom uint64 `protobuf:"varint,3,opt,name=resumeFrom" json:"resumeFrom,omitempty"`
+	// resumeEpoch is the epoch of the server which has given out resumeFrom, if it does not match the current
+	// epoch of the server (e.g. it was restarted without its state) a full snapshot is sent
+	ResumeEpoch uint64 `protobuf:"varint,4,opt,name=resumeEpoch" json:"resumeEpoch,omitempty"`
 }
 
 func (m *Register) Reset()         { *m = Register{} }
@@ -38,6 +62,13 @@ func (m *Register) GetJobs() []*Register_Job {
 	return nil
 }
 
+func (m *Register) GetPatterns() []*Register_Pattern {
+	if m != nil {
+		return m.Patterns
+	}
+	return nil
+}
+
 type Register_Job struct {
 	ServerLocation string `protobuf:"bytes,1,opt,name=serverLocation" json:"serverLocation,omitempty"`
 	JobName        string `protobuf:"bytes,2,opt,name=jobName" json:"jobName,omitempty"`
@@ -47,18 +78,420 @@ func (m *Register_Job) Reset()         { *m = Register_Job{} }
 func (m *Register_Job) String() string { return proto.CompactTextString(m) }
 func (*Register_Job) ProtoMessage()    {}
 
+// Pattern subscribes to all jobs on a server whose name matches a glob (e.g. "deploy-*")
+type Register_Pattern struct {
+	ServerLocation string `protobuf:"bytes,1,opt,name=serverLocation" json:"serverLocation,omitempty"`
+	JobGlob        string `protobuf:"bytes,2,opt,name=jobGlob" json:"jobGlob,omitempty"`
+}
+
+func (m *Register_Pattern) Reset()         { *m = Register_Pattern{} }
+func (m *Register_Pattern) String() string { return proto.CompactTextString(m) }
+func (*Register_Pattern) ProtoMessage()    {}
+
+type Unregister struct {
+	Jobs     []*Register_Job     `protobuf:"bytes,1,rep,name=jobs" json:"jobs,omitempty"`
+	Patterns []*Register_Pattern `protobuf:"bytes,2,rep,name=patterns" json:"patterns,omitempty"`
+}
+
+func (m *Unregister) Reset()         { *m = Unregister{} }
+func (m *Unregister) String() string { return proto.CompactTextString(m) }
+func (*Unregister) ProtoMessage()    {}
+
+func (m *Unregister) GetJobs() []*Register_Job {
+	if m != nil {
+		return m.Jobs
+	}
+	return nil
+}
+
+func (m *Unregister) GetPatterns() []*Register_Pattern {
+	if m != nil {
+		return m.Patterns
+	}
+	return nil
+}
+
+type ReplaceSubscriptions struct {
+	Jobs     []*Register_Job     `protobuf:"bytes,1,rep,name=jobs" json:"jobs,omitempty"`
+	Patterns []*Register_Pattern `protobuf:"bytes,2,rep,name=patterns" json:"patterns,omitempty"`
+}
+
+func (m *ReplaceSubscriptions) Reset()         { *m = ReplaceSubscriptions{} }
+func (m *ReplaceSubscriptions) String() string { return proto.CompactTextString(m) }
+func (*ReplaceSubscriptions) ProtoMessage()    {}
+
+func (m *ReplaceSubscriptions) GetJobs() []*Register_Job {
+	if m != nil {
+		return m.Jobs
+	}
+	return nil
+}
+
+func (m *ReplaceSubscriptions) GetPatterns() []*Register_Pattern {
+	if m != nil {
+		return m.Patterns
+	}
+	return nil
+}
+
+// Hello must be the first message client sends, so that both sides know which protocol version is spoken
+type Hello struct {
+	ProtocolVersion uint32   `protobuf:"varint,1,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	Capabilities    []string `protobuf:"bytes,2,rep,name=capabilities" json:"capabilities,omitempty"`
+	ClientVersion   string   `protobuf:"bytes,3,opt,name=clientVersion" json:"clientVersion,omitempty"`
+}
+
+func (m *Hello) Reset()         { *m = Hello{} }
+func (m *Hello) String() string { return proto.CompactTextString(m) }
+func (*Hello) ProtoMessage()    {}
+
+type HelloResponse struct {
+	// protocolVersion is the version both sides will speak, the lower one of the two
+	ProtocolVersion uint32 `protobuf:"varint,1,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	// capabilities are the ones supported by both client and server
+	Capabilities  []string `protobuf:"bytes,2,rep,name=capabilities" json:"capabilities,omitempty"`
+	ServerVersion string   `protobuf:"bytes,3,opt,name=serverVersion" json:"serverVersion,omitempty"`
+	Accepted      bool     `protobuf:"varint,4,opt,name=accepted" json:"accepted,omitempty"`
+	Error         string   `protobuf:"bytes,5,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *HelloResponse) Reset()         { *m = HelloResponse{} }
+func (m *HelloResponse) String() string { return proto.CompactTextString(m) }
+func (*HelloResponse) ProtoMessage()    {}
+
+// Authenticate must be sent (after Hello) before any other message when server has authentication enabled
+type Authenticate struct {
+	Token string `protobuf:"bytes,1,opt,name=token" json:"token,omitempty"`
+}
+
+func (m *Authenticate) Reset()         { *m = Authenticate{} }
+func (m *Authenticate) String() string { return proto.CompactTextString(m) }
+func (*Authenticate) ProtoMessage()    {}
+
+// Ping is sent periodically by the client, server answers with a Pong carrying the same nonce.
+// Server disconnects clients it didn't hear from for too long
+type Ping struct {
+	Nonce uint64 `protobuf:"varint,1,opt,name=nonce" json:"nonce,omitempty"`
+}
+
+func (m *Ping) Reset()         { *m = Ping{} }
+func (m *Ping) String() string { return proto.CompactTextString(m) }
+func (*Ping) ProtoMessage()    {}
+
+type Pong struct {
+	Nonce uint64 `protobuf:"varint,1,opt,name=nonce" json:"nonce,omitempty"`
+}
+
+func (m *Pong) Reset()         { *m = Pong{} }
+func (m *Pong) String() string { return proto.CompactTextString(m) }
+func (*Pong) ProtoMessage()    {}
+
+// ActionRequest asks server to call Jenkins on behalf of the client, with server's credentials.
+// Server answers with an ActionResponse carrying the same requestId
+type ActionRequest struct {
+	RequestId uint64 `protobuf:"varint,1,opt,name=requestId" json:"requestId,omitempty"`
+	// action is one of: runJob, failedTests, lastLogLines, previousFailureCauses, lastBuildURL, lastCompletedBuildURL
+	Action         string `protobuf:"bytes,2,opt,name=action" json:"action,omitempty"`
+	ServerLocation string `protobuf:"bytes,3,opt,name=serverLocation" json:"serverLocation,omitempty"`
+	JobName        string `protobuf:"bytes,4,opt,name=jobName" json:"jobName,omitempty"`
+	// buildId selects a specific build; if empty, the build the action usually works with is used
+	BuildId string `protobuf:"bytes,5,opt,name=buildId" json:"buildId,omitempty"`
+	// lineCount is used only by lastLogLines
+	LineCount uint32 `protobuf:"varint,6,opt,name=lineCount" json:"lineCount,omitempty"`
+}
+
+func (m *ActionRequest) Reset()         { *m = ActionRequest{} }
+func (m *ActionRequest) String() string { return proto.CompactTextString(m) }
+func (*ActionRequest) ProtoMessage()    {}
+
+// ClientMessage is the envelope of everything a client sends, only one of the fields is expected to be set
+type ClientMessage struct {
+	Register             *Register             `protobuf:"bytes,1,opt,name=register" json:"register,omitempty"`
+	Unregister           *Unregister           `protobuf:"bytes,2,opt,name=unregister" json:"unregister,omitempty"`
+	ReplaceSubscriptions *ReplaceSubscriptions `protobuf:"bytes,3,opt,name=replaceSubscriptions" json:"replaceSubscriptions,omitempty"`
+	Authenticate         *Authenticate         `protobuf:"bytes,4,opt,name=authenticate" json:"authenticate,omitempty"`
+	Hello                *Hello                `protobuf:"bytes,5,opt,name=hello" json:"hello,omitempty"`
+	Ping                 *Ping                 `protobuf:"bytes,6,opt,name=ping" json:"ping,omitempty"`
+	ActionRequest        *ActionRequest        `protobuf:"bytes,7,opt,name=actionRequest" json:"actionRequest,omitempty"`
+}
+
+func (m *ClientMessage) Reset()         { *m = ClientMessage{} }
+func (m *ClientMessage) String() string { return proto.CompactTextString(m) }
+func (*ClientMessage) ProtoMessage()    {}
+
+func (m *ClientMessage) GetRegister() *Register {
+	if m != nil {
+		return m.Register
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetUnregister() *Unregister {
+	if m != nil {
+		return m.Unregister
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetReplaceSubscriptions() *ReplaceSubscriptions {
+	if m != nil {
+		return m.ReplaceSubscriptions
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetAuthenticate() *Authenticate {
+	if m != nil {
+		return m.Authenticate
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetHello() *Hello {
+	if m != nil {
+		return m.Hello
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetPing() *Ping {
+	if m != nil {
+		return m.Ping
+	}
+	return nil
+}
+
+func (m *ClientMessage) GetActionRequest() *ActionRequest {
+	if m != nil {
+		return m.ActionRequest
+	}
+	return nil
+}
+
 type RegisterResponse struct {
 	Version string `protobuf:"bytes,1,opt,name=version" json:"version,omitempty"`
 	Success bool   `protobuf:"varint,2,opt,name=success" json:"success,omitempty"`
 	Connid  string `protobuf:"bytes,3,opt,name=connid" json:"connid,omitempty"`
+	// error explains why request was not successful
+	Error string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
 }
 
 func (m *RegisterResponse) Reset()         { *m = RegisterResponse{} }
 func (m *RegisterResponse) String() string { return proto.CompactTextString(m) }
 func (*RegisterResponse) ProtoMessage()    {}
 
+type JobState struct {
+	ServerLocation   string `protobuf:"bytes,1,opt,name=serverLocation" json:"serverLocation,omitempty"`
+	JobName          string `protobuf:"bytes,2,opt,name=jobName" json:"jobName,omitempty"`
+	Group            string `protobuf:"bytes,3,opt,name=group" json:"group,omitempty"`
+	CulpritsFriendly string `protobuf:"bytes,4,opt,name=culpritsFriendly" json:"culpritsFriendly,omitempty"`
+	CausesFriendly   string `protobuf:"bytes,5,opt,name=causesFriendly" json:"causesFriendly,omitempty"`
+	Time             string `protobuf:"bytes,6,opt,name=time" json:"time,omitempty"`
+	Error            string `protobuf:"bytes,7,opt,name=error" json:"error,omitempty"`
+	PreviousState    uint32 `protobuf:"varint,8,opt,name=previousState" json:"previousState,omitempty"`
+	Building         bool   `protobuf:"varint,9,opt,name=building" json:"building,omitempty"`
+	// version is the server sequence at which this state was last changed
+	Version uint64 `protobuf:"varint,10,opt,name=version" json:"version,omitempty"`
+	// started is the start of the last build in milliseconds since the epoch, zero if not known
+	Started int64 `protobuf:"varint,11,opt,name=started" json:"started,omitempty"`
+	// duration of the last build in milliseconds, estimated duration if it is still building
+	Duration int64 `protobuf:"varint,12,opt,name=duration" json:"duration,omitempty"`
+	// queued is set while a build of the job waits in the Jenkins queue
+	Queued bool `protobuf:"varint,13,opt,name=queued" json:"queued,omitempty"`
+}
+
+func (m *JobState) Reset()         { *m = JobState{} }
+func (m *JobState) String() string { return proto.CompactTextString(m) }
+func (*JobState) ProtoMessage()    {}
+
+type StateUpdate struct {
+	States []*JobState `protobuf:"bytes,1,rep,name=states" json:"states,omitempty"`
+	// snapshot is set when states contain everything client is registered for, not only the changes
+	Snapshot bool `protobuf:"varint,2,opt,name=snapshot" json:"snapshot,omitempty"`
+	// sequence is the highest version server knows about at the time of sending
+	Sequence uint64 `protobuf:"varint,3,opt,name=sequence" json:"sequence,omitempty"`
+	// epoch identifies the sequence, it changes when the server starts counting versions from scratch
+	Epoch uint64 `protobuf:"varint,4,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *StateUpdate) Reset()         { *m = StateUpdate{} }
+func (m *StateUpdate) String() string { return proto.CompactTextString(m) }
+func (*StateUpdate) ProtoMessage()    {}
+
+func (m *StateUpdate) GetStates() []*JobState {
+	if m != nil {
+		return m.States
+	}
+	return nil
+}
+
+type TestCase struct {
+	ClassName       string `protobuf:"bytes,1,opt,name=className" json:"className,omitempty"`
+	Name            string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Status          string `protobuf:"bytes,3,opt,name=status" json:"status,omitempty"`
+	ErrorStackTrace string `protobuf:"bytes,4,opt,name=errorStackTrace" json:"errorStackTrace,omitempty"`
+	Age             int32  `protobuf:"varint,5,opt,name=age" json:"age,omitempty"`
+	FailedSince     int32  `protobuf:"varint,6,opt,name=failedSince" json:"failedSince,omitempty"`
+}
+
+func (m *TestCase) Reset()         { *m = TestCase{} }
+func (m *TestCase) String() string { return proto.CompactTextString(m) }
+func (*TestCase) ProtoMessage()    {}
+
+// Cause explains why a build started, upstream build is set when it was started by another job
+type Cause struct {
+	UserId           string `protobuf:"bytes,1,opt,name=userId" json:"userId,omitempty"`
+	ShortDescription string `protobuf:"bytes,2,opt,name=shortDescription" json:"shortDescription,omitempty"`
+	UpstreamProject  string `protobuf:"bytes,3,opt,name=upstreamProject" json:"upstreamProject,omitempty"`
+	UpstreamBuild    int32  `protobuf:"varint,4,opt,name=upstreamBuild" json:"upstreamBuild,omitempty"`
+}
+
+func (m *Cause) Reset()         { *m = Cause{} }
+func (m *Cause) String() string { return proto.CompactTextString(m) }
+func (*Cause) ProtoMessage()    {}
+
+// BuildStatus is the status of a single build as given by Jenkins
+type BuildStatus struct {
+	Id       string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Result   string `protobuf:"bytes,2,opt,name=result" json:"result,omitempty"`
+	Building bool   `protobuf:"varint,3,opt,name=building" json:"building,omitempty"`
+	// timestamp is when the build started, in milliseconds since epoch
+	Timestamp int64 `protobuf:"varint,4,opt,name=timestamp" json:"timestamp,omitempty"`
+	// duration and estimatedDuration are in milliseconds
+	Duration          int64    `protobuf:"varint,5,opt,name=duration" json:"duration,omitempty"`
+	EstimatedDuration int64    `protobuf:"varint,6,opt,name=estimatedDuration" json:"estimatedDuration,omitempty"`
+	Culprits          []string `protobuf:"bytes,7,rep,name=culprits" json:"culprits,omitempty"`
+	// authors of changes included in the build
+	Authors []string `protobuf:"bytes,8,rep,name=authors" json:"authors,omitempty"`
+	Causes  []*Cause `protobuf:"bytes,9,rep,name=causes" json:"causes,omitempty"`
+}
+
+func (m *BuildStatus) Reset()         { *m = BuildStatus{} }
+func (m *BuildStatus) String() string { return proto.CompactTextString(m) }
+func (*BuildStatus) ProtoMessage()    {}
+
+func (m *BuildStatus) GetCauses() []*Cause {
+	if m != nil {
+		return m.Causes
+	}
+	return nil
+}
+
+type ActionResponse struct {
+	RequestId uint64 `protobuf:"varint,1,opt,name=requestId" json:"requestId,omitempty"`
+	Success   bool   `protobuf:"varint,2,opt,name=success" json:"success,omitempty"`
+	Error     string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	// lines are log lines or failure causes, depending on the action
+	Lines     []string     `protobuf:"bytes,4,rep,name=lines" json:"lines,omitempty"`
+	TestCases []*TestCase  `protobuf:"bytes,5,rep,name=testCases" json:"testCases,omitempty"`
+	Url       string       `protobuf:"bytes,6,opt,name=url" json:"url,omitempty"`
+	Status    *BuildStatus `protobuf:"bytes,7,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *ActionResponse) Reset()         { *m = ActionResponse{} }
+func (m *ActionResponse) String() string { return proto.CompactTextString(m) }
+func (*ActionResponse) ProtoMessage()    {}
+
+func (m *ActionResponse) GetTestCases() []*TestCase {
+	if m != nil {
+		return m.TestCases
+	}
+	return nil
+}
+
+func (m *ActionResponse) GetStatus() *BuildStatus {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+// Goodbye is sent before server closes the connection because it is shutting down.
+// Client should reconnect later, not immediately
+type Goodbye struct {
+	Reason string `protobuf:"bytes,1,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *Goodbye) Reset()         { *m = Goodbye{} }
+func (m *Goodbye) String() string { return proto.CompactTextString(m) }
+func (*Goodbye) ProtoMessage()    {}
+
+// ServerMessage is the envelope of everything a server sends, only one of the fields is expected to be set
+type ServerMessage struct {
+	RegisterResponse *RegisterResponse `protobuf:"bytes,1,opt,name=registerResponse" json:"registerResponse,omitempty"`
+	StateUpdate      *StateUpdate      `protobuf:"bytes,2,opt,name=stateUpdate" json:"stateUpdate,omitempty"`
+	HelloResponse    *HelloResponse    `protobuf:"bytes,3,opt,name=helloResponse" json:"helloResponse,omitempty"`
+	Pong             *Pong             `protobuf:"bytes,4,opt,name=pong" json:"pong,omitempty"`
+	ActionResponse   *ActionResponse   `protobuf:"bytes,5,opt,name=actionResponse" json:"actionResponse,omitempty"`
+	Goodbye          *Goodbye          `protobuf:"bytes,6,opt,name=goodbye" json:"goodbye,omitempty"`
+}
+
+func (m *ServerMessage) Reset()         { *m = ServerMessage{} }
+func (m *ServerMessage) String() string { return proto.CompactTextString(m) }
+func (*ServerMessage) ProtoMessage()    {}
+
+func (m *ServerMessage) GetRegisterResponse() *RegisterResponse {
+	if m != nil {
+		return m.RegisterResponse
+	}
+	return nil
+}
+
+func (m *ServerMessage) GetStateUpdate() *StateUpdate {
+	if m != nil {
+		return m.StateUpdate
+	}
+	return nil
+}
+
+func (m *ServerMessage) GetHelloResponse() *HelloResponse {
+	if m != nil {
+		return m.HelloResponse
+	}
+	return nil
+}
+
+func (m *ServerMessage) GetPong() *Pong {
+	if m != nil {
+		return m.Pong
+	}
+	return nil
+}
+
+func (m *ServerMessage) GetActionResponse() *ActionResponse {
+	if m != nil {
+		return m.ActionResponse
+	}
+	return nil
+}
+
+func (m *ServerMessage) GetGoodbye() *Goodbye {
+	if m != nil {
+		return m.Goodbye
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Register)(nil), "server.Register")
 	proto.RegisterType((*Register_Job)(nil), "server.Register.Job")
+	proto.RegisterType((*Register_Pattern)(nil), "server.Register.Pattern")
+	proto.RegisterType((*Unregister)(nil), "server.Unregister")
+	proto.RegisterType((*ReplaceSubscriptions)(nil), "server.ReplaceSubscriptions")
+	proto.RegisterType((*Hello)(nil), "server.Hello")
+	proto.RegisterType((*HelloResponse)(nil), "server.HelloResponse")
+	proto.RegisterType((*Authenticate)(nil), "server.Authenticate")
+	proto.RegisterType((*Ping)(nil), "server.Ping")
+	proto.RegisterType((*Pong)(nil), "server.Pong")
+	proto.RegisterType((*ActionRequest)(nil), "server.ActionRequest")
+	proto.RegisterType((*ClientMessage)(nil), "server.ClientMessage")
 	proto.RegisterType((*RegisterResponse)(nil), "server.RegisterResponse")
+	proto.RegisterType((*JobState)(nil), "server.JobState")
+	proto.RegisterType((*StateUpdate)(nil), "server.StateUpdate")
+	proto.RegisterType((*TestCase)(nil), "server.TestCase")
+	proto.RegisterType((*Cause)(nil), "server.Cause")
+	proto.RegisterType((*BuildStatus)(nil), "server.BuildStatus")
+	proto.RegisterType((*ActionResponse)(nil), "server.ActionResponse")
+	proto.RegisterType((*Goodbye)(nil), "server.Goodbye")
+	proto.RegisterType((*ServerMessage)(nil), "server.ServerMessage")
 }
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+const (
+	// JobsPath gives all known job states as JSON. A single job is available under
+	// JobsPath/{server}/{job}, where both server location and job name are escaped (as url.QueryEscape does it)
+	JobsPath = "/api/jobs"
+	// EventsPath is a Server-Sent Events stream of job state changes. It expects "server" query parameter
+	// and any number of "job" query parameters (job names or globs, all jobs of the server if none is given)
+	EventsPath = "/events"
+	// EventsHeartbeatInterval is how often a comment is sent on the events stream so that proxies keep it open
+	EventsHeartbeatInterval = 15 * time.Second
+)
+
+// jobStateJSON is how a job state is represented on the JSON endpoints
+type jobStateJSON struct {
+	Server   string `json:"server"`
+	Job      string `json:"job"`
+	Group    string `json:"group,omitempty"`
+	Status   string `json:"status"`
+	Building bool   `json:"building"`
+	Queued   bool   `json:"queued,omitempty"`
+	Causes   string `json:"causes,omitempty"`
+	Culprits string `json:"culprits,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Version  uint64 `json:"version"`
+	// Started is in milliseconds since the epoch and Duration in milliseconds, so that clients can tell
+	// the time relative to their own clock instead of relying on Time
+	Started  int64 `json:"started,omitempty"`
+	Duration int64 `json:"duration,omitempty"`
+}
+
+var statusNames = map[model.BuildStatus]string{
+	model.Success:   "success",
+	model.Failure:   "failure",
+	model.Undefined: "undefined",
+	model.Unknown:   "unknown",
+	model.Disabled:  "disabled",
+}
+
+func toJSONJobState(state VersionedJobState) jobStateJSON {
+	return jobStateJSON{
+		Server:   state.Server,
+		Job:      state.JobName,
+		Group:    state.Group,
+		Status:   statusNames[state.PreviousState],
+		Building: state.Building,
+		Queued:   state.Queued,
+		Causes:   state.CausesFriendly,
+		Culprits: state.CulpritsFriendly,
+		Time:     state.Time,
+		Error:    errorMessage(state.Error),
+		Version:  state.Version,
+		Started:  toMillis(state.Started),
+		Duration: int64(state.Duration / time.Millisecond),
+	}
+}
+
+func toJSONJobStates(states []VersionedJobState) []jobStateJSON {
+	result := make([]jobStateJSON, 0, len(states))
+	for _, state := range states {
+		result = append(result, toJSONJobState(state))
+	}
+	return result
+}
+
+// byVersion sorts states in the order they were changed
+type byVersion []VersionedJobState
+
+func (states byVersion) Len() int           { return len(states) }
+func (states byVersion) Swap(i, j int)      { states[i], states[j] = states[j], states[i] }
+func (states byVersion) Less(i, j int) bool { return states[i].Version < states[j].Version }
+
+func (h *CliciServer) registerJSONEndpoints() {
+	h.ServeMux.HandleFunc(JobsPath, h.jobsHandler)
+	h.ServeMux.HandleFunc(EventsPath, h.eventsHandler)
+}
+
+// ServeHTTP routes single job requests directly to their handler, since ServeMux would clean
+// the path with unescaped server location (which contains "//") and redirect
+func (h *CliciServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.EscapedPath(), JobsPath+"/") {
+		h.jobsHandler(w, r)
+		return
+	}
+	h.ServeMux.ServeHTTP(w, r)
+}
+
+// policyForRequest authenticates the request with the token from WebhookTokenHeader header or "token" query parameter.
+// If authentication fails, the response is already written
+func (h *CliciServer) policyForRequest(w http.ResponseWriter, r *http.Request) (*TokenPolicy, bool) {
+	if r.Method != "GET" {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	policy, err := h.auth.authenticate(tokenFromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return policy, true
+}
+
+func (h *CliciServer) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	policy, ok := h.policyForRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Path == JobsPath || r.URL.Path == JobsPath+"/" {
+		writeJSON(w, toJSONJobStates(h.processor.KnownStates(policy.allowsJob)))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), JobsPath+"/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, fmt.Sprintf("expected %v/{server}/{job}", JobsPath), http.StatusBadRequest)
+		return
+	}
+	server, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		http.Error(w, "server location is not escaped properly", http.StatusBadRequest)
+		return
+	}
+	job, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		http.Error(w, "job name is not escaped properly", http.StatusBadRequest)
+		return
+	}
+	if !policy.allowsJob(server, job) {
+		http.Error(w, fmt.Sprintf("not allowed to access job %v on %v", job, server), http.StatusForbidden)
+		return
+	}
+	states := h.processor.KnownStates(func(knownServer, knownJob string) bool {
+		return knownServer == server && knownJob == job
+	})
+	if len(states) == 0 {
+		http.Error(w, fmt.Sprintf("state of job %v on %v is not known", job, server), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, toJSONJobState(states[0]))
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Could not write JSON response: %v", err)
+	}
+}
+
+// eventsHandler subscribes to the jobs from the query, just like a websocket client does, and streams
+// a "snapshot" event followed by a "state" event for each change. Event ids are "epoch-sequence", so a reconnecting
+// EventSource gets (through Last-Event-ID header) only the changes it missed, or a new snapshot if the server
+// has started counting from scratch in the meantime
+func (h *CliciServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	policy, ok := h.policyForRequest(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	server := r.URL.Query().Get("server")
+	if server == "" {
+		http.Error(w, "server query parameter is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.processor.checkServer(server); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobGlobs := r.URL.Query()["job"]
+	if len(jobGlobs) == 0 {
+		jobGlobs = []string{"*"}
+	}
+	for _, jobGlob := range jobGlobs {
+		if !policy.allowsPattern(server, jobGlob) {
+			http.Error(w, fmt.Sprintf("not allowed to register for pattern %v on %v", jobGlob, server), http.StatusForbidden)
+			return
+		}
+	}
+	resume := parseEventID(r.Header.Get("Last-Event-ID"))
+
+	id := ConnectionID(randomStringFromBytes(8))
+	queue := h.processor.Connect(id)
+	defer h.processor.UnRegisterClient(id)
+	for _, jobGlob := range jobGlobs {
+		h.processor.RegisterPattern(id, server, jobGlob)
+	}
+	log.Printf("Events client %v registered for %v on %v", id, jobGlobs, server)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	states, snapshot, epoch, sequence := h.processor.StatesFor(id, resume.epoch, resume.sequence)
+	if err := writeEvents(w, states, snapshot, epoch, sequence); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(EventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-queue.Ready():
+			states, resync := queue.Take()
+			if resync {
+				states, _, epoch, sequence = h.processor.StatesFor(id, 0, 0)
+			}
+			err = writeEvents(w, states, resync, epoch, sequence)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-queue.Evicted():
+			log.Printf("Events client %v is too slow consuming updates, disconnecting", id)
+			return
+		case <-r.Context().Done():
+			return
+		case <-h.stopPolling:
+			if _, err := fmt.Fprintf(w, "event: goodbye\ndata: %q\n\n", goodbyeReason); err == nil {
+				flusher.Flush()
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Failure sending events to client %v: %v", id, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvents writes either a single snapshot event or one state event per state
+func writeEvents(w http.ResponseWriter, states []VersionedJobState, snapshot bool, epoch, sequence uint64) error {
+	if snapshot {
+		sort.Sort(byServerAndJob(states))
+		return writeEvent(w, "snapshot", eventID(epoch, sequence), toJSONJobStates(states))
+	}
+	sort.Sort(byVersion(states))
+	for _, state := range states {
+		if err := writeEvent(w, "state", eventID(epoch, state.Version), toJSONJobState(state)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEvent(w http.ResponseWriter, event string, id string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event, id, data)
+	return err
+}
+
+func eventID(epoch, sequence uint64) string {
+	return fmt.Sprintf("%d-%d", epoch, sequence)
+}
+
+// parseEventID reads the point from which to resume from the event id, anything unexpected asks for a snapshot
+func parseEventID(id string) (resume resumePoint) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return
+	}
+	epoch, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+	return resumePoint{epoch: epoch, sequence: sequence}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestJobsEndpoint(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "http://jenkins1", JobName: "job1"}},
+			},
+		})
+		clici.processor.ProcessMappings()
+
+		var states []jobStateJSON
+		getJSON(t, fmt.Sprintf("http://localhost:%d%s", clici.Port, JobsPath), http.StatusOK, &states)
+		if len(states) != 1 || states[0].Job != "job1" || states[0].Status != "success" {
+			t.Fatalf("expected job1 to be successful, got %v", states)
+		}
+
+		var state jobStateJSON
+		jobURL := fmt.Sprintf("http://localhost:%d%s/%s/%s", clici.Port, JobsPath, url.QueryEscape("http://jenkins1"), "job1")
+		getJSON(t, jobURL, http.StatusOK, &state)
+		if state.Server != "http://jenkins1" || state.Job != "job1" || state.Version == 0 {
+			t.Fatalf("unexpected job state: %v", state)
+		}
+
+		unknownURL := fmt.Sprintf("http://localhost:%d%s/%s/%s", clici.Port, JobsPath, url.QueryEscape("http://jenkins1"), "job2")
+		getJSON(t, unknownURL, http.StatusNotFound, nil)
+	})
+}
+
+func TestJobsEndpointRequiresToken(t *testing.T) {
+	withRunningConfiguredServer(t, withTestConfiguration, func(clici *CliciServer, ws *websocket.Conn) {
+		getJSON(t, fmt.Sprintf("http://localhost:%d%s", clici.Port, JobsPath), http.StatusUnauthorized, nil)
+		getJSON(t, fmt.Sprintf("http://localhost:%d%s?token=viewer-token", clici.Port, JobsPath), http.StatusOK, nil)
+	})
+}
+
+func TestEventsStream(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		eventsURL := fmt.Sprintf("http://localhost:%d%s?server=%s&job=%s", clici.Port, EventsPath, url.QueryEscape("http://jenkins1"), "job*")
+		resp, err := noKeepAliveClient.Get(eventsURL)
+		if err != nil {
+			t.Fatalf("could not open events stream: %v", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Fatalf("unexpected content type %v", contentType)
+		}
+		events := bufio.NewReader(resp.Body)
+
+		event, data := readEvent(t, events)
+		if event != "snapshot" || data != "[]" {
+			t.Fatalf("expected empty snapshot first, got %v: %v", event, data)
+		}
+
+		clici.processor.ProcessMappings()
+		event, data = readEvent(t, events)
+		state := jobStateJSON{}
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			t.Fatalf("could not parse event data %v: %v", data, err)
+		}
+		if event != "state" || state.Job != "job1" {
+			t.Fatalf("expected state of job1, got %v: %v", event, data)
+		}
+	})
+}
+
+func getJSON(t *testing.T, url string, expectedStatus int, value interface{}) {
+	resp, err := noKeepAliveClient.Get(url)
+	if err != nil {
+		t.Fatalf("request to %v failed: %v", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != expectedStatus {
+		t.Fatalf("expected status %d from %v, got %d", expectedStatus, url, resp.StatusCode)
+	}
+	if value != nil {
+		if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+			t.Fatalf("could not parse response from %v: %v", url, err)
+		}
+	}
+}
+
+// readEvent reads the next event from the stream, skipping comments
+func readEvent(t *testing.T, events *bufio.Reader) (event, data string) {
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
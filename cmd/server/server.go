@@ -1,22 +1,30 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/milanaleksic/clici/jenkins"
-	"github.com/milanaleksic/clici/model"
 	"golang.org/x/net/websocket"
 )
 
 const (
-	// ClosingSuccess is a response message received when close endpoint is called
+	// ClosingSuccess is a response message received when ShutdownPath is called
 	ClosingSuccess = "Closing..."
+	// DefaultPollInterval is how often Jenkins servers are polled if nothing else is set
+	DefaultPollInterval = 15 * time.Second
+	// DefaultIdleTimeout is how long a client can be silent before it is disconnected. Clients
+	// are expected to send a Ping more often than that
+	DefaultIdleTimeout = 60 * time.Second
 )
 
 // Version is declaration of the server protocol version that this server provides
@@ -31,25 +39,62 @@ type CliciServer struct {
 	*http.ServeMux
 	lis              net.Listener
 	closedGracefully bool
-	// Secret is the URL on this server that can be called to
-	// gracefully shutdown the server
-	Secret string
 	// Port is the port which will be occupied by the server
-	Port      int
-	processor *Processor
+	Port int
+	// CertFile and KeyFile are PEM encoded TLS certificate and its private key. When both are set
+	// server is available only over TLS, so clients need to use wss:// and https://
+	CertFile string
+	KeyFile  string
+	// AdminAddress is where shutdown, metrics and debug endpoints are served, separately from the clients.
+	// It is either a TCP address like localhost:8081 or a unix socket path prefixed with "unix:"
+	AdminAddress string
+	// PollInterval is how often all registered jobs are refreshed from Jenkins. When Jenkins
+	// sends notifications to the webhook, polling is just a safety net and this can be set to minutes
+	PollInterval time.Duration
+	// IdleTimeout is how long server waits for any message (like Ping) from the client before
+	// deciding the connection is dead and unregistering the client
+	IdleTimeout time.Duration
+	// WebhookSecret is the shared secret Jenkins needs to send to the WebhookPath.
+	// Webhook is not enabled if the secret is not set
+	WebhookSecret string
+	// StateFile is where job states and completed builds are saved after each poll, so they are available
+	// right after a restart. Nothing is saved if it is not set
+	StateFile string
+	// ConfigurationFile is a JSON file with client tokens, Jenkins credentials and request budgets,
+	// loaded by StartAndWait. Configure can be used instead to pass configuration directly
+	ConfigurationFile string
+	processor         *Processor
+	auth              *authenticator
+	stopPolling       chan struct{}
+	shutdown          *sync.Once
+	adminLis          net.Listener
+	started           time.Time
 }
 
 // New creates a new Clici server behind a certain port.
 // Nothing will be started until StartAndWait is called though.
 func New(port int) CliciServer {
 	clici := CliciServer{
-		ServeMux:  http.NewServeMux(),
-		Port:      port,
-		processor: NewProcessorWithSupplier(jenkins.NewAPI),
+		ServeMux:     http.NewServeMux(),
+		Port:         port,
+		PollInterval: DefaultPollInterval,
+		IdleTimeout:  DefaultIdleTimeout,
+		AdminAddress: DefaultAdminAddress,
+		processor:    NewProcessorWithSupplier(jenkins.NewAPI),
+		auth:         newAuthenticator(nil, nil),
+		stopPolling:  make(chan struct{}),
+		shutdown:     &sync.Once{},
 	}
 	return clici
 }
 
+// Configure applies client tokens, Jenkins credentials and request budgets. It should be called before StartAndWait
+func (h *CliciServer) Configure(configuration *Configuration) {
+	h.auth = newAuthenticator(configuration.Tokens, configuration.AnonymousActions)
+	h.processor.SetCredentials(configuration.Jenkins)
+	h.processor.SetBudgets(configuration.Budgets)
+}
+
 /*
 StartAndWait is the entry point after the server object has been initiated.
 It will block until shutdown call is executed or until program is interrupted
@@ -60,38 +105,86 @@ func (h *CliciServer) StartAndWait(started chan<- struct{}) {
 	if err != nil {
 		log.Fatalf("Could not listen: %v", err)
 	}
+	if h.CertFile != "" || h.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(h.CertFile, h.KeyFile)
+		if err != nil {
+			log.Fatalf("Could not load TLS certificate: %v", err)
+		}
+		lis = tls.NewListener(lis, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	}
 	h.lis = lis
 
-	h.registerRandomizedShutdownHook()
+	if h.adminLis, err = listenAdmin(h.AdminAddress); err != nil {
+		log.Fatalf("Could not listen for admin requests: %v", err)
+	}
+
+	if h.ConfigurationFile != "" {
+		configuration, err := LoadConfiguration(h.ConfigurationFile)
+		if err != nil {
+			log.Fatalf("Could not load configuration: %v", err)
+		}
+		h.Configure(configuration)
+	}
 
+	if h.StateFile != "" {
+		if err := h.processor.LoadState(h.StateFile); err != nil {
+			log.Printf("Could not load server state, starting without it: %v", err)
+		}
+	}
+
+	h.registerWebhook()
+	h.registerJSONEndpoints()
+	h.registerHealthEndpoint()
 	h.ServeMux.Handle("/ws", websocket.Handler(h.clientHandler))
 
+	go h.pollPeriodically()
+	go h.serveAdmin()
+
+	h.started = time.Now()
 	started <- struct{}{}
 	if err = http.Serve(lis, h); err != nil && !h.closedGracefully {
 		log.Fatalf("Could not start serving: %v", err)
 	}
 }
 
-func (h *CliciServer) registerRandomizedShutdownHook() {
-	h.Secret = randomStringFromBytes(48)
-	h.ServeMux.HandleFunc(fmt.Sprintf("/%v", h.Secret), func(w http.ResponseWriter, r *http.Request) {
-		h.closedGracefully = true
-		log.Println("Closing the listener")
-		if _, err := w.Write([]byte(ClosingSuccess)); err != nil {
-			log.Printf("Not able to send ClosingSuccess message to the client, %v", err)
-		}
-		if err := h.lis.Close(); err != nil {
-			log.Fatalf("Not able to shutdown server gracefully, %v", err)
-		}
-	})
+func (h *CliciServer) pollPeriodically() {
+	for h.processor.PollSpread(h.PollInterval, h.stopPolling) {
+		h.saveState()
+	}
+	h.saveState()
 }
 
-func (h *CliciServer) processRegistrationRequestsFromClient(id ConnectionID, newRegistrations chan<- Register, clientLeft chan<- bool, lepr *LengthEncodedProtoReaderWriter) {
-	defer func() { clientLeft <- true }()
+func (h *CliciServer) saveState() {
+	if h.StateFile == "" {
+		return
+	}
+	if err := h.processor.SaveState(h.StateFile); err != nil {
+		log.Printf("Could not save server state: %v", err)
+	}
+}
 
-	for {
-		register := Register{}
-		err := lepr.ReadProto(&register)
+// clientConnection holds what is known about a single connected client
+type clientConnection struct {
+	id ConnectionID
+	// protocolVersion is zero until the handshake is done
+	protocolVersion uint32
+	capabilities    map[string]bool
+	// policy is nil until client is authenticated
+	policy *TokenPolicy
+}
+
+// outgoingMessage is a message for the client, after which connection can be closed. It is a ServerMessage,
+// except for clients speaking protocol version 1
+type outgoingMessage struct {
+	message   proto.Message
+	thenClose bool
+}
+
+func (h *CliciServer) processRegistrationRequestsFromClient(newRegistrations chan<- ClientMessage, clientLeft chan<- bool, lepr *LengthEncodedProtoReaderWriter) {
+	defer close(clientLeft)
+
+	for first := true; ; first = false {
+		data, err := lepr.ReadMessage()
 		if err != nil {
 			if err.Error() == io.EOF.Error() {
 				//	 ignore
@@ -105,66 +198,273 @@ func (h *CliciServer) processRegistrationRequestsFromClient(id ConnectionID, new
 			_ = lepr.UnderlyingReadWriter.Close()
 			return
 		}
-
-		newRegistrations <- register
-
-		if !h.respondAllOk(lepr, id) {
-			return
+		message := ClientMessage{}
+		if err = proto.Unmarshal(data, &message); err != nil {
+			if !first {
+				log.Printf("Could not unmarshal message: %v, terminating connection", err)
+				_ = lepr.UnderlyingReadWriter.Close()
+				return
+			}
+			// protocol version 1 clients start with a bare Register, which is not always a valid ClientMessage.
+			// Message without Hello gets them the explanation why they are rejected
+			log.Printf("Could not unmarshal the first message (%v), assuming protocol version 1", err)
 		}
+
+		newRegistrations <- message
 	}
 }
 
-func (h *CliciServer) processOutgoingUpdates(id ConnectionID, outgoingJobStateChannel <-chan model.JobState, clientLeft <-chan bool, lepr io.Writer) {
+// processOutgoingUpdates is the only place where messages are written to the client, so that responses,
+// snapshots and pushed state changes can't interleave on the wire
+func (h *CliciServer) processOutgoingUpdates(id ConnectionID, queue *UpdateQueue, outgoingMessages <-chan outgoingMessage, done <-chan bool, lepr *LengthEncodedProtoReaderWriter) {
 	for {
+		var message proto.Message
+		thenClose := false
 		select {
-		case state := <-outgoingJobStateChannel:
-			log.Printf("Publishing state %v to client behind %v", state, id)
-			// TODO: just a POC, a DTO should be streamed
-			_, _ = lepr.Write([]byte(fmt.Sprintf("%v", state)))
-		case <-clientLeft:
+		case <-queue.Ready():
+			states, resync := queue.Take()
+			if resync {
+				log.Printf("Updates for client %v were dropped, sending all states again", id)
+				message = h.stateUpdateFor(id, resumePoint{})
+			} else if len(states) == 0 {
+				continue
+			} else {
+				log.Printf("Publishing %d states to client behind %v", len(states), id)
+				update := &StateUpdate{Epoch: h.processor.states.currentEpoch()}
+				for _, state := range states {
+					update.States = append(update.States, toWireJobState(state))
+					if state.Version > update.Sequence {
+						update.Sequence = state.Version
+					}
+				}
+				message = &ServerMessage{StateUpdate: update}
+			}
+		case outgoing := <-outgoingMessages:
+			message, thenClose = outgoing.message, outgoing.thenClose
+		case <-done:
 			log.Printf("Connect %v left", id)
 			return
 		}
+		if err := lepr.WriteProto(message); err != nil {
+			log.Printf("Failure sending to client %v: %v, terminating connection", id, err)
+			_ = lepr.UnderlyingReadWriter.Close()
+		} else if thenClose {
+			_ = lepr.UnderlyingReadWriter.Close()
+		}
 	}
 }
 
 func (h *CliciServer) clientHandler(ws *websocket.Conn) {
 	id := ConnectionID(randomStringFromBytes(8))
-	newRegistrations := make(chan Register)
+	newRegistrations := make(chan ClientMessage)
 	clientLeft := make(chan bool)
-	outputChannel := make(chan model.JobState)
-	defer close(clientLeft)
-	defer close(newRegistrations)
+	queue := h.processor.Connect(id)
+	outgoingMessages := make(chan outgoingMessage)
+	done := make(chan bool)
+	defer close(done)
 
 	lepr := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
 
-	go h.processRegistrationRequestsFromClient(id, newRegistrations, clientLeft, lepr)
-	go h.processOutgoingUpdates(id, outputChannel, clientLeft, lepr)
+	go h.processRegistrationRequestsFromClient(newRegistrations, clientLeft, lepr)
+	go h.processOutgoingUpdates(id, queue, outgoingMessages, done, lepr)
+
+	evicted := queue.Evicted()
+	sendThenClose := func(message proto.Message, thenClose bool) bool {
+		select {
+		case outgoingMessages <- outgoingMessage{message: message, thenClose: thenClose}:
+			return true
+		case <-evicted:
+			return false
+		case <-clientLeft:
+			return false
+		}
+	}
+	send := func(message *ServerMessage) bool {
+		return sendThenClose(message, false)
+	}
+
+	idle := time.NewTimer(h.IdleTimeout)
+	defer idle.Stop()
 
-outer:
+	stopping := h.stopPolling
+	conn := &clientConnection{id: id}
 	for {
 		select {
-		case requestedMappings := <-newRegistrations:
-			for _, job := range requestedMappings.GetJobs() {
-				h.processor.RegisterClient(id, job.ServerLocation, job.JobName, outputChannel)
+		case message := <-newRegistrations:
+			idle.Reset(h.IdleTimeout)
+			if hello := message.GetHello(); hello == nil && conn.protocolVersion == 0 {
+				// only protocol version 1 clients don't start with the handshake. They understand just a bare RegisterResponse
+				response := negotiate(nil)
+				log.Printf("Rejecting client %v: %v", id, response.Error)
+				sendThenClose(&RegisterResponse{Version: Version, Connid: string(id), Error: response.Error}, true)
+				continue
+			} else if hello != nil {
+				response := h.handshake(conn, hello)
+				sendThenClose(&ServerMessage{HelloResponse: response}, !response.Accepted)
+				continue
 			}
+			if ping := message.GetPing(); ping != nil {
+				send(&ServerMessage{Pong: &Pong{Nonce: ping.Nonce}})
+				continue
+			}
+			if request := message.GetActionRequest(); request != nil {
+				policy := conn.policy
+				go func() {
+					send(&ServerMessage{ActionResponse: h.relayAction(policy, request)})
+				}()
+				continue
+			}
+			resume, err := h.processClientMessage(conn, &message)
+			if err != nil {
+				log.Printf("Rejecting request from client %v: %v", id, err)
+				send(h.errorResponse(id, err))
+				continue
+			}
+			if !send(h.allOkResponse(id)) {
+				continue
+			}
+			if resume != nil {
+				send(h.stateUpdateFor(id, *resume))
+			}
+		case <-evicted:
+			log.Printf("Client %v is too slow consuming updates, disconnecting", id)
+			_ = ws.Close()
+			evicted = nil
+		case <-idle.C:
+			log.Printf("Client %v was silent for %v, disconnecting", id, h.IdleTimeout)
+			_ = ws.Close()
+		case <-stopping:
+			sendThenClose(&ServerMessage{Goodbye: &Goodbye{Reason: goodbyeReason}}, true)
+			stopping = nil
 		case <-clientLeft:
 			h.processor.UnRegisterClient(id)
-			break outer
+			return
+		}
+	}
+}
+
+func (h *CliciServer) handshake(conn *clientConnection, hello *Hello) *HelloResponse {
+	response := negotiate(hello)
+	if !response.Accepted {
+		log.Printf("Handshake with client %v failed: %v", conn.id, response.Error)
+		return response
+	}
+	conn.protocolVersion = response.ProtocolVersion
+	conn.capabilities = make(map[string]bool)
+	for _, capability := range response.Capabilities {
+		conn.capabilities[capability] = true
+	}
+	log.Printf("Client %v (version %v) speaks protocol version %d with capabilities %v", conn.id, hello.ClientVersion, conn.protocolVersion, response.Capabilities)
+	return response
+}
+
+// relayAction executes the action if the policy allows it. Policy is nil for clients which didn't authenticate
+func (h *CliciServer) relayAction(policy *TokenPolicy, request *ActionRequest) *ActionResponse {
+	if policy == nil {
+		var err error
+		if policy, err = h.auth.authenticate(""); err != nil {
+			return &ActionResponse{RequestId: request.RequestId, Error: "authentication required"}
+		}
+	}
+	if err := policy.checkAction(request); err != nil {
+		log.Printf("Rejecting action %v of %v: %v", request.Action, policy.Name, err)
+		return &ActionResponse{RequestId: request.RequestId, Error: err.Error()}
+	}
+	return h.processor.ExecuteAction(request)
+}
+
+// processClientMessage authenticates the client and applies subscription changes. It gives back the point from
+// which states should be sent to the client, nil if client should not receive any states as a result of this message
+func (h *CliciServer) processClientMessage(conn *clientConnection, message *ClientMessage) (resume *resumePoint, err error) {
+	if authenticate := message.GetAuthenticate(); authenticate != nil {
+		if conn.policy, err = h.auth.authenticate(authenticate.Token); err != nil {
+			return
+		}
+		log.Printf("Client %v authenticated as %v", conn.id, conn.policy.Name)
+	} else if conn.policy == nil {
+		if conn.policy, err = h.auth.authenticate(""); err != nil {
+			return nil, fmt.Errorf("authentication required")
+		}
+	}
+	if register := message.GetRegister(); register != nil {
+		if err = conn.policy.checkSubscriptions(register.GetJobs(), register.GetPatterns()); err != nil {
+			return
+		}
+		if err = h.processor.checkServers(register.GetJobs(), register.GetPatterns()); err != nil {
+			return
+		}
+	}
+	if replace := message.GetReplaceSubscriptions(); replace != nil {
+		if err = conn.policy.checkSubscriptions(replace.GetJobs(), replace.GetPatterns()); err != nil {
+			return
 		}
+		if err = h.processor.checkServers(replace.GetJobs(), replace.GetPatterns()); err != nil {
+			return
+		}
+	}
+	return h.processSubscriptionChange(conn, message), nil
+}
+
+// resumePoint is the last state client has seen: a sequence and the epoch in which it was given out.
+// Zero value asks for a full snapshot
+type resumePoint struct {
+	epoch    uint64
+	sequence uint64
+}
+
+// processSubscriptionChange applies the change and gives back the point from which states should be
+// sent to the client, nil if client should not receive any states as a result of this change
+func (h *CliciServer) processSubscriptionChange(conn *clientConnection, message *ClientMessage) (resume *resumePoint) {
+	id := conn.id
+	if register := message.GetRegister(); register != nil {
+		for _, job := range register.GetJobs() {
+			h.processor.RegisterClient(id, job.ServerLocation, job.JobName)
+		}
+		for _, pattern := range register.GetPatterns() {
+			h.processor.RegisterPattern(id, pattern.ServerLocation, pattern.JobGlob)
+		}
+		resume = &resumePoint{epoch: register.ResumeEpoch, sequence: register.ResumeFrom}
+	}
+	if unregister := message.GetUnregister(); unregister != nil {
+		h.processor.UnRegisterJobs(id, unregister.GetJobs(), unregister.GetPatterns())
+	}
+	if replace := message.GetReplaceSubscriptions(); replace != nil {
+		h.processor.ReplaceSubscriptions(id, replace.GetJobs(), replace.GetPatterns())
+		resume = &resumePoint{}
+	}
+	return
+}
+
+func (h *CliciServer) allOkResponse(id ConnectionID) *ServerMessage {
+	return &ServerMessage{
+		RegisterResponse: &RegisterResponse{
+			Version: Version,
+			Success: true,
+			Connid:  id.AsString(),
+		},
+	}
+}
+
+func (h *CliciServer) errorResponse(id ConnectionID, err error) *ServerMessage {
+	return &ServerMessage{
+		RegisterResponse: &RegisterResponse{
+			Version: Version,
+			Success: false,
+			Connid:  id.AsString(),
+			Error:   err.Error(),
+		},
 	}
 }
 
-func (h *CliciServer) respondAllOk(lepr *LengthEncodedProtoReaderWriter, id ConnectionID) bool {
-	response := RegisterResponse{
-		Version: Version,
-		Success: true,
-		Connid:  id.AsString(),
+func (h *CliciServer) stateUpdateFor(id ConnectionID, resume resumePoint) *ServerMessage {
+	states, snapshot, epoch, sequence := h.processor.StatesFor(id, resume.epoch, resume.sequence)
+	update := &StateUpdate{
+		Snapshot: snapshot,
+		Sequence: sequence,
+		Epoch:    epoch,
 	}
-	if err := lepr.WriteProto(&response); err != nil {
-		log.Printf("Failure responding to request: %v, terminating connection", err)
-		_ = lepr.UnderlyingReadWriter.Close()
-		return false
+	for _, state := range states {
+		update.States = append(update.States, toWireJobState(state))
 	}
-	return true
+	return &ServerMessage{StateUpdate: update}
 }
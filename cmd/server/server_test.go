@@ -10,36 +10,32 @@ import (
 	"log"
 	"net"
 
-	"io"
 	"time"
 
 	"github.com/milanaleksic/clici/jenkins"
 	"golang.org/x/net/websocket"
 )
 
+// keep-alive must be avoided, otherwise a connection towards server from the previous test could be reused
+var noKeepAliveClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
 func TestRegistration(t *testing.T) {
 	var mapping *Mapping
 	var connID string
 	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
 		mapping = clici.processor.mapping
-		request := &Register{
-			Jobs: []*Register_Job{
-				{
-					ServerLocation: "localhost:8101/jenkins/",
-					JobName:        "job1",
+		request := &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{
+					{
+						ServerLocation: "localhost:8101/jenkins/",
+						JobName:        "job1",
+					},
 				},
 			},
 		}
 		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
-		if err := wire.WriteProto(request); err != nil {
-			t.Fatalf("registration failed while writing request: %v", err)
-		}
-		response := RegisterResponse{}
-		if err := wire.ReadProto(&response); err != nil {
-			t.Fatalf("registration failed with error: %v", err)
-		} else if !response.Success {
-			t.Fatal("registration failed")
-		}
+		response := sendAndExpectSuccess(t, wire, request)
 		if err := assertConnectionRegisteredInMapping(clici.processor.mapping, response.Connid, true); err != nil {
 			t.Fatalf("registration did not create new record in memdb on server side: err=%v", err)
 		}
@@ -56,36 +52,153 @@ func TestRegistration(t *testing.T) {
 	}
 }
 
-func readStateFromWire(t *testing.T, ws *websocket.Conn) {
-	timer := time.NewTimer(100 * time.Millisecond)
-	defer timer.Stop()
-	doneChan := make(chan bool)
-	go func() {
-		msg := make([]byte, 1024)
-		for {
-			n, err := ws.Read(msg)
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					t.Fatalf("Error while reading response from server: %v", err)
-				}
-			}
-			log.Printf("[WIRE] %v", string(msg[:n]))
-			if n < 1024 {
-				break
+func TestSnapshotOnRegistration(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		request := &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{
+					{ServerLocation: "jenkins1", JobName: "job1"},
+				},
+			},
+		}
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, request)
+		clici.processor.ProcessMappings()
+		pushed := readStateFromWire(t, ws)
+		if pushed.Snapshot {
+			t.Fatal("State change should not be marked as a snapshot")
+		}
+
+		lateWS := dial(t, clici.Port)
+		defer func() { _ = lateWS.Close() }()
+		lateWire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: lateWS}
+		sendAndExpectSuccess(t, lateWire, request)
+		snapshot := readStateFromWire(t, lateWS)
+		if !snapshot.Snapshot || len(snapshot.States) != 1 || snapshot.States[0].JobName != "job1" {
+			t.Fatalf("Expected snapshot with job1 right after registration, got: %v", snapshot)
+		}
+		if snapshot.Sequence != pushed.Sequence || snapshot.States[0].Version != pushed.States[0].Version {
+			t.Fatalf("Snapshot %v does not have the same version as the pushed state %v", snapshot, pushed)
+		}
+
+		resumingWS := dial(t, clici.Port)
+		defer func() { _ = resumingWS.Close() }()
+		resumingWire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: resumingWS}
+		request.Register.ResumeFrom = pushed.Sequence
+		request.Register.ResumeEpoch = pushed.Epoch
+		sendAndExpectSuccess(t, resumingWire, request)
+		resumed := ServerMessage{}
+		if err := resumingWire.ReadProto(&resumed); err != nil {
+			t.Fatalf("Could not read resumed state: %v", err)
+		}
+		if update := resumed.GetStateUpdate(); update == nil || update.Snapshot || len(update.States) != 0 {
+			t.Fatalf("Client that has seen the latest sequence should not receive any states, got: %v", update)
+		}
+
+		restartedWS := dial(t, clici.Port)
+		defer func() { _ = restartedWS.Close() }()
+		restartedWire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: restartedWS}
+		request.Register.ResumeEpoch = pushed.Epoch + 1
+		sendAndExpectSuccess(t, restartedWire, request)
+		if resent := readStateFromWire(t, restartedWS); !resent.Snapshot || len(resent.States) != 1 {
+			t.Fatalf("Sequence from another epoch must be answered with a snapshot, got: %v", resent)
+		}
+	})
+}
+
+func TestUnregisterAndReplaceSubscriptions(t *testing.T) {
+	withRunningServer(t, func(clici *CliciServer, ws *websocket.Conn) {
+		mapping := clici.processor.mapping
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		response := sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{
+					{ServerLocation: "jenkins1", JobName: "job1"},
+					{ServerLocation: "jenkins1", JobName: "job2"},
+				},
+			},
+		})
+		connID := ConnectionID(response.Connid)
+
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Unregister: &Unregister{
+				Jobs: []*Register_Job{
+					{ServerLocation: "jenkins1", JobName: "job1"},
+				},
+			},
+		})
+		if err := assertConnectionsForJob(mapping, "jenkins1", "job1"); err != nil {
+			t.Fatalf("unregister did not remove job1: %v", err)
+		}
+		if err := assertConnectionsForJob(mapping, "jenkins1", "job2", connID); err != nil {
+			t.Fatalf("unregister removed job2 as well: %v", err)
+		}
+
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			ReplaceSubscriptions: &ReplaceSubscriptions{
+				Patterns: []*Register_Pattern{
+					{ServerLocation: "jenkins2", JobGlob: "deploy-*"},
+				},
+			},
+		})
+		if err := assertConnectionsForJob(mapping, "jenkins1", "job2"); err != nil {
+			t.Fatalf("replace did not remove job2: %v", err)
+		}
+		if err := assertConnectionsForJob(mapping, "jenkins2", "deploy-prod", connID); err != nil {
+			t.Fatalf("replace did not add the pattern: %v", err)
+		}
+	})
+}
+
+func sendAndExpectSuccess(t *testing.T, wire *LengthEncodedProtoReaderWriter, request *ClientMessage) RegisterResponse {
+	if err := wire.WriteProto(request); err != nil {
+		t.Fatalf("request failed while writing: %v", err)
+	}
+	for {
+		message := ServerMessage{}
+		if err := wire.ReadProto(&message); err != nil {
+			t.Fatalf("request failed with error: %v", err)
+		}
+		if response := message.GetRegisterResponse(); response == nil {
+			continue
+		} else if !response.Success {
+			t.Fatal("request failed")
+		} else {
+			return *response
+		}
+	}
+}
+
+func assertConnectionsForJob(mapping *Mapping, server, job string, expected ...ConnectionID) error {
+	return retry(func() (err error) {
+		found := mapping.FindAllRegisteredConnectionsForServerAndJob(server, job)
+		if len(found) != len(expected) {
+			return fmt.Errorf("Expected connections %v, got %v", expected, found)
+		}
+		for i := range expected {
+			if found[i] != expected[i] {
+				return fmt.Errorf("Expected connections %v, got %v", expected, found)
 			}
 		}
-		doneChan <- true
-	}()
+		return
+	})
+}
 
+// readStateFromWire waits for the first state update which carries at least one state
+func readStateFromWire(t *testing.T, ws *websocket.Conn) *StateUpdate {
+	if err := ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("Could not set read deadline: %v", err)
+	}
+	defer func() { _ = ws.SetReadDeadline(time.Time{}) }()
+	wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
 	for {
-		select {
-		case <-timer.C:
-			t.Fatal("Failed because timer expired!")
-		case <-doneChan:
-			fmt.Println("Done")
-			return
+		message := ServerMessage{}
+		if err := wire.ReadProto(&message); err != nil {
+			t.Fatalf("Error while reading state from server: %v", err)
+		}
+		log.Printf("[WIRE] %v", message.String())
+		if update := message.GetStateUpdate(); update != nil && len(update.GetStates()) > 0 {
+			return update
 		}
 	}
 }
@@ -105,7 +218,32 @@ func assertConnectionRegisteredInMapping(mapping *Mapping, connID string, should
 	})
 }
 
+// testServers are the Jenkins servers tests can register for, accessed anonymously
+var testServers = []JenkinsCredentials{
+	{Location: "jenkins1"},
+	{Location: "jenkins2"},
+	{Location: "http://jenkins1"},
+	{Location: "https://Jenkins1/"},
+	{Location: "localhost:8101/jenkins/"},
+}
+
 func withRunningServer(t *testing.T, callback func(clici *CliciServer, ws *websocket.Conn)) {
+	withRunningConfiguredServer(t, func(clici *CliciServer) {}, callback)
+}
+
+func withRunningConfiguredServer(t *testing.T, configure func(clici *CliciServer), callback func(clici *CliciServer, ws *websocket.Conn)) {
+	handler := startServer(t, configure)
+	defer stopServer(t, handler)
+
+	ws := dial(t, handler.Port)
+	defer func() {
+		_ = ws.Close()
+	}()
+
+	callback(handler, ws)
+}
+
+func startServer(t *testing.T, configure func(clici *CliciServer)) *CliciServer {
 	port := 8080
 	for ; port <= 8100; port++ {
 		lis, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
@@ -126,34 +264,43 @@ func withRunningServer(t *testing.T, callback func(clici *CliciServer, ws *webso
 	handler.processor.apiSupplier = func(serverLocation string, username, server string) jenkins.API {
 		return &api
 	}
+	handler.processor.SetCredentials(testServers)
+	configure(&handler)
 
 	started := make(chan struct{}, 0)
 	go handler.StartAndWait(started)
-	defer func() {
-		// FIXME: why is this sometimes returning 404?
-		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/%s", port, handler.Secret))
-		if err != nil {
-			t.Fatalf("Err: %v", err)
-		}
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			t.Errorf("Graceful server shutdown failed: %v", err)
-		} else if string(b) != ClosingSuccess {
-			t.Errorf("Graceful server shutdown failed: %v", string(b))
-		}
-	}()
-
 	<-started
+	return &handler
+}
 
-	ws := dial(t, port)
-	defer func() {
-		_ = ws.Close()
-	}()
-
-	callback(&handler, ws)
+func stopServer(t *testing.T, handler *CliciServer) {
+	resp, err := noKeepAliveClient.Post(fmt.Sprintf("http://%v%s", handler.AdminAddr(), ShutdownPath), "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("Graceful server shutdown failed: %v", err)
+	} else if string(b) != ClosingSuccess {
+		t.Errorf("Graceful server shutdown failed: %v", string(b))
+	}
 }
 
 func dial(t *testing.T, port int) (ws *websocket.Conn) {
+	origin := "http://ignored/"
+	url := fmt.Sprintf("ws://localhost:%d/ws", port)
+	ws, err := websocket.Dial(url, "ws", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := handshake(t, ws, ProtocolVersion)
+	if !response.Accepted {
+		t.Fatalf("handshake failed: %v", response.Error)
+	}
+	return
+}
+
+func dialWithoutHandshake(t *testing.T, port int) (ws *websocket.Conn) {
 	origin := "http://ignored/"
 	url := fmt.Sprintf("ws://localhost:%d/ws", port)
 	ws, err := websocket.Dial(url, "ws", origin)
@@ -163,6 +310,32 @@ func dial(t *testing.T, port int) (ws *websocket.Conn) {
 	return
 }
 
+func handshake(t *testing.T, ws *websocket.Conn, protocolVersion uint32) *HelloResponse {
+	wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+	return expectHelloResponse(t, wire, &ClientMessage{
+		Hello: &Hello{
+			ProtocolVersion: protocolVersion,
+			Capabilities:    []string{CapabilityPatterns, CapabilityResume, "unknown"},
+			ClientVersion:   "test",
+		},
+	})
+}
+
+func expectHelloResponse(t *testing.T, wire *LengthEncodedProtoReaderWriter, request *ClientMessage) *HelloResponse {
+	if err := wire.WriteProto(request); err != nil {
+		t.Fatalf("request failed while writing: %v", err)
+	}
+	message := ServerMessage{}
+	if err := wire.ReadProto(&message); err != nil {
+		t.Fatalf("request failed with error: %v", err)
+	}
+	response := message.GetHelloResponse()
+	if response == nil {
+		t.Fatalf("expected HelloResponse, got %v", message)
+	}
+	return response
+}
+
 func retry(closure func() error) (err error) {
 	for i := 0; i < 100; i++ {
 		err = closure()
@@ -173,3 +346,43 @@ func retry(closure func() error) (err error) {
 	}
 	return
 }
+
+func TestHeartbeatKeepsClientAndSilenceDisconnectsIt(t *testing.T) {
+	shortIdleTimeout := func(clici *CliciServer) {
+		clici.IdleTimeout = 200 * time.Millisecond
+	}
+	withRunningConfiguredServer(t, shortIdleTimeout, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		response := sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{{ServerLocation: "jenkins1", JobName: "job1"}},
+			},
+		})
+
+		for i := uint64(1); i <= 5; i++ {
+			time.Sleep(clici.IdleTimeout / 2)
+			if err := wire.WriteProto(&ClientMessage{Ping: &Ping{Nonce: i}}); err != nil {
+				t.Fatalf("could not send ping: %v", err)
+			}
+			message := ServerMessage{}
+			for message.GetPong() == nil {
+				if err := wire.ReadProto(&message); err != nil {
+					t.Fatalf("no pong received: %v", err)
+				}
+			}
+			if message.Pong.Nonce != i {
+				t.Fatalf("expected pong with nonce %d, got %v", i, message)
+			}
+		}
+		if err := assertConnectionRegisteredInMapping(clici.processor.mapping, response.Connid, true); err != nil {
+			t.Fatalf("client sending heartbeats should stay registered: %v", err)
+		}
+
+		if err := wire.ReadProto(&ServerMessage{}); err == nil {
+			t.Fatal("silent client should have been disconnected")
+		}
+		if err := assertConnectionRegisteredInMapping(clici.processor.mapping, response.Connid, false); err != nil {
+			t.Fatalf("silent client should have been unregistered: %v", err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/milanaleksic/clici/model"
+)
+
+// VersionedJobState is a job state together with the server sequence at which it was last changed
+type VersionedJobState struct {
+	model.JobState
+	Version uint64
+}
+
+type jobKey struct {
+	server  string
+	jobName string
+}
+
+// jobStateStore keeps the last known state of each job and a sequence that is incremented on each change,
+// so only the changes can be sent to the clients. Epoch identifies the sequence: it is chosen when the store
+// starts counting from scratch, so a sequence given out by another server instance is never taken for its own
+type jobStateStore struct {
+	sync.RWMutex
+	states   map[jobKey]VersionedJobState
+	epoch    uint64
+	sequence uint64
+}
+
+func newJobStateStore() *jobStateStore {
+	return &jobStateStore{
+		states: make(map[jobKey]VersionedJobState),
+		epoch:  uint64(time.Now().UnixNano()),
+	}
+}
+
+// update stores the state if it differs from the last known one, giving back the versioned state if it has changed
+func (store *jobStateStore) update(server string, state model.JobState) (versioned VersionedJobState, changed bool) {
+	store.Lock()
+	defer store.Unlock()
+	key := jobKey{server: server, jobName: state.JobName}
+	if known, ok := store.states[key]; ok && sameJobState(known.JobState, state) {
+		return known, false
+	}
+	store.sequence++
+	versioned = VersionedJobState{JobState: state, Version: store.sequence}
+	store.states[key] = versioned
+	return versioned, true
+}
+
+// currentSequence gives the latest version given to any job state
+func (store *jobStateStore) currentSequence() uint64 {
+	store.RLock()
+	defer store.RUnlock()
+	return store.sequence
+}
+
+// currentEpoch gives the epoch of the sequence
+func (store *jobStateStore) currentEpoch() uint64 {
+	store.RLock()
+	defer store.RUnlock()
+	return store.epoch
+}
+
+// position gives the epoch together with the latest version given to any job state
+func (store *jobStateStore) position() (epoch, sequence uint64) {
+	store.RLock()
+	defer store.RUnlock()
+	return store.epoch, store.sequence
+}
+
+// changedSince gives all states newer than the given version which are accepted by the filter
+func (store *jobStateStore) changedSince(version uint64, accept func(server, jobName string) bool) (states []VersionedJobState) {
+	store.RLock()
+	defer store.RUnlock()
+	for key, state := range store.states {
+		if state.Version > version && accept(key.server, key.jobName) {
+			states = append(states, state)
+		}
+	}
+	return
+}
+
+// forget removes states of the jobs not accepted by keep
+func (store *jobStateStore) forget(keep func(server, jobName string) bool) {
+	store.Lock()
+	defer store.Unlock()
+	for key := range store.states {
+		if !keep(key.server, key.jobName) {
+			delete(store.states, key)
+		}
+	}
+}
+
+// byServerAndJob sorts states by server location and then by job name
+type byServerAndJob []VersionedJobState
+
+func (states byServerAndJob) Len() int      { return len(states) }
+func (states byServerAndJob) Swap(i, j int) { states[i], states[j] = states[j], states[i] }
+func (states byServerAndJob) Less(i, j int) bool {
+	if states[i].Server != states[j].Server {
+		return states[i].Server < states[j].Server
+	}
+	return states[i].JobName < states[j].JobName
+}
+
+// sameJobState compares everything client is shown, except the relative time text which changes
+// on its own and is derived by the clients from the start and the duration of the build
+func sameJobState(first, second model.JobState) bool {
+	return first.Group == second.Group &&
+		first.JobName == second.JobName &&
+		first.Server == second.Server &&
+		first.CulpritsFriendly == second.CulpritsFriendly &&
+		first.CausesFriendly == second.CausesFriendly &&
+		first.Started.Equal(second.Started) &&
+		first.Duration == second.Duration &&
+		errorMessage(first.Error) == errorMessage(second.Error) &&
+		first.PreviousState == second.PreviousState &&
+		first.Building == second.Building &&
+		first.Queued == second.Queued
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func toWireJobState(state VersionedJobState) *JobState {
+	return &JobState{
+		ServerLocation:   state.Server,
+		JobName:          state.JobName,
+		Group:            state.Group,
+		CulpritsFriendly: state.CulpritsFriendly,
+		CausesFriendly:   state.CausesFriendly,
+		Time:             state.Time,
+		Error:            errorMessage(state.Error),
+		PreviousState:    uint32(state.PreviousState),
+		Building:         state.Building,
+		Version:          state.Version,
+		Started:          toMillis(state.Started),
+		Duration:         int64(state.Duration / time.Millisecond),
+		Queued:           state.Queued,
+	}
+}
+
+// toMillis gives milliseconds since the epoch, zero time is sent as zero
+func toMillis(moment time.Time) int64 {
+	if moment.IsZero() {
+		return 0
+	}
+	return moment.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis is the opposite of toMillis
+func fromMillis(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// all gives all known states together with the current epoch and sequence
+func (store *jobStateStore) all() (states []VersionedJobState, epoch, sequence uint64) {
+	store.RLock()
+	defer store.RUnlock()
+	for _, state := range store.states {
+		states = append(states, state)
+	}
+	return states, store.epoch, store.sequence
+}
+
+// restore replaces all known states, for example with the ones persisted before a restart.
+// Zero epoch (state saved by an older version) keeps the current one
+func (store *jobStateStore) restore(states []VersionedJobState, epoch, sequence uint64) {
+	store.Lock()
+	defer store.Unlock()
+	store.states = make(map[jobKey]VersionedJobState, len(states))
+	for _, state := range states {
+		store.states[jobKey{server: state.Server, jobName: state.JobName}] = state
+	}
+	store.sequence = sequence
+	if epoch != 0 {
+		store.epoch = epoch
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// WebhookPath is the URL on this server that accepts Jenkins Notification plugin build events
+	WebhookPath = "/jenkins/notification"
+	// WebhookTokenHeader is the header that carries the shared webhook secret. It also carries a client token
+	// on the JSON endpoints, where it can alternatively be sent as "token" query parameter
+	WebhookTokenHeader = "X-Clici-Token"
+	// maxNotificationSize limits the body of a Jenkins notification
+	maxNotificationSize = 1 << 20
+)
+
+// jenkinsNotification is the JSON payload sent by the Jenkins Notification plugin
+// (https://wiki.jenkins-ci.org/display/JENKINS/Notification+Plugin)
+type jenkinsNotification struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Build struct {
+		FullURL string `json:"full_url"`
+		Number  int    `json:"number"`
+		Phase   string `json:"phase"`
+		Status  string `json:"status"`
+		URL     string `json:"url"`
+	} `json:"build"`
+}
+
+// serverLocation deduces the Jenkins server location from the absolute build URL,
+// since that is the only absolute URL Notification plugin sends
+func (notification *jenkinsNotification) serverLocation() string {
+	if index := strings.Index(notification.Build.FullURL, "/job/"); index != -1 {
+		return notification.Build.FullURL[:index]
+	}
+	return ""
+}
+
+// locationKey normalizes a Jenkins server location, so that locations which differ only in scheme
+// (e.g. behind a TLS terminating proxy), case of the host, default port or trailing slash are the same
+func locationKey(location string) string {
+	parsed, err := url.Parse(strings.TrimSpace(location))
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSuffix(strings.ToLower(location), "/")
+	}
+	host := strings.ToLower(parsed.Host)
+	if hostname, port, err := net.SplitHostPort(host); err == nil && (port == "80" || port == "443") {
+		host = hostname
+	}
+	return host + strings.TrimSuffix(parsed.Path, "/")
+}
+
+// registeredServer finds the location under which clients have registered for the given Jenkins server
+func (processor *Processor) registeredServer(location string) (string, bool) {
+	key := locationKey(location)
+	for server := range processor.registrationsPerServer() {
+		if locationKey(server) == key {
+			return server, true
+		}
+	}
+	return "", false
+}
+
+func (h *CliciServer) registerWebhook() {
+	if h.WebhookSecret == "" {
+		log.Println("Webhook secret not set, Jenkins notifications will not be accepted")
+		return
+	}
+	h.ServeMux.HandleFunc(WebhookPath, h.webhookHandler)
+}
+
+func (h *CliciServer) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	// secret is accepted only in the header, so it doesn't end up in access logs together with the URL
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(WebhookTokenHeader)), []byte(h.WebhookSecret)) != 1 {
+		log.Printf("Rejecting Jenkins notification from %v: invalid token", r.RemoteAddr)
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+	notification := jenkinsNotification{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxNotificationSize)
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		http.Error(w, "could not parse notification", http.StatusBadRequest)
+		return
+	}
+	server := r.URL.Query().Get("server")
+	if server == "" {
+		server = notification.serverLocation()
+	}
+	if server == "" || notification.Name == "" {
+		http.Error(w, "server location or job name unknown", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Jenkins notification received: job=%v, server=%v, build=%d, phase=%v", notification.Name, server, notification.Build.Number, notification.Build.Phase)
+	registered, ok := h.processor.registeredServer(server)
+	if !ok {
+		log.Printf("Ignoring Jenkins notification for job %v: nobody is registered for server %v", notification.Name, server)
+		http.Error(w, "nobody is registered for server "+server, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	go h.processor.RefreshJob(registered, notification.Name)
+}
+
+// tokenFromRequest gives the client token sent to the JSON endpoints
+func tokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get(WebhookTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
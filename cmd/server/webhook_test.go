@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+const notificationPayload = `{
+  "name": "job1",
+  "url": "job/job1/",
+  "build": {
+    "full_url": "http://jenkins1/job/job1/5/",
+    "number": 5,
+    "phase": "COMPLETED",
+    "status": "FAILURE",
+    "url": "job/job1/5/"
+  }
+}`
+
+func TestWebhookRejectsInvalidToken(t *testing.T) {
+	withRunningConfiguredServer(t, withWebhookSecret, func(clici *CliciServer, ws *websocket.Conn) {
+		resp := postNotification(t, clici.Port, "", "wrong")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected notification to be forbidden, got: %v", resp.Status)
+		}
+		resp = postNotification(t, clici.Port, "?token=s3cret", "")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected notification with secret in the query to be forbidden, got: %v", resp.Status)
+		}
+	})
+}
+
+func TestWebhookPushesStateToRegisteredClients(t *testing.T) {
+	withRunningConfiguredServer(t, withWebhookSecret, func(clici *CliciServer, ws *websocket.Conn) {
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{
+					{ServerLocation: "http://jenkins1", JobName: "job1"},
+				},
+			},
+		})
+		if err := assertConnectionsForJobCount(clici.processor.mapping, "http://jenkins1", "job1", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := postNotification(t, clici.Port, "", "s3cret")
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Expected notification to be accepted, got: %v", resp.Status)
+		}
+
+		readStateFromWire(t, ws)
+	})
+}
+
+func TestWebhookMatchesNormalizedServerLocation(t *testing.T) {
+	withRunningConfiguredServer(t, withWebhookSecret, func(clici *CliciServer, ws *websocket.Conn) {
+		if resp := postNotification(t, clici.Port, "", "s3cret"); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected notification for a server nobody is registered for to be rejected, got: %v", resp.Status)
+		}
+
+		wire := &LengthEncodedProtoReaderWriter{UnderlyingReadWriter: ws}
+		sendAndExpectSuccess(t, wire, &ClientMessage{
+			Register: &Register{
+				Jobs: []*Register_Job{
+					{ServerLocation: "https://Jenkins1/", JobName: "job1"},
+				},
+			},
+		})
+		if err := assertConnectionsForJobCount(clici.processor.mapping, "https://Jenkins1/", "job1", 1); err != nil {
+			t.Fatal(err)
+		}
+		if resp := postNotification(t, clici.Port, "", "s3cret"); resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Expected notification to be accepted, got: %v", resp.Status)
+		}
+		if update := readStateFromWire(t, ws); update.States[0].ServerLocation != "https://Jenkins1/" {
+			t.Fatalf("State should be sent for the registered location, got: %v", update)
+		}
+	})
+}
+
+func TestLocationKey(t *testing.T) {
+	cases := []struct {
+		first  string
+		second string
+		same   bool
+	}{
+		{"http://ci", "http://ci/", true},
+		{"http://ci", "https://ci", true},
+		{"http://CI:80/", "https://ci:443", true},
+		{"http://ci/jenkins", "http://ci/jenkins/", true},
+		{"http://ci:8080", "http://ci", false},
+		{"http://ci/jenkins", "http://ci", false},
+		{"http://ci", "http://other", false},
+	}
+	for _, c := range cases {
+		if same := locationKey(c.first) == locationKey(c.second); same != c.same {
+			t.Errorf("%v and %v: expected same=%v, got %v", c.first, c.second, c.same, same)
+		}
+	}
+}
+
+func withWebhookSecret(clici *CliciServer) {
+	clici.WebhookSecret = "s3cret"
+}
+
+func postNotification(t *testing.T, port int, query string, token string) *http.Response {
+	url := fmt.Sprintf("http://localhost:%d%s%s", port, WebhookPath, query)
+	request, err := http.NewRequest("POST", url, strings.NewReader(notificationPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set(WebhookTokenHeader, token)
+	}
+	resp, err := noKeepAliveClient.Do(request)
+	if err != nil {
+		t.Fatalf("Could not send notification: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+func assertConnectionsForJobCount(mapping *Mapping, server, job string, count int) error {
+	return retry(func() error {
+		if found := mapping.FindAllRegisteredConnectionsForServerAndJob(server, job); len(found) != count {
+			return fmt.Errorf("Expected %d connections for %v/%v, got %v", count, server, job, found)
+		}
+		return nil
+	})
+}
@@ -27,6 +27,7 @@ func NewAPI(location, username, password string) API {
 		ServerLocation: location,
 		Username: username,
 		Password: password,
+		Cache: NewStatusCache(),
 	}
 }
 
@@ -37,8 +38,9 @@ type Status struct {
 
 // JobBuildStatus status for a single job
 type JobBuildStatus struct {
-	Name  string `json:"name"`
-	Color string `json:"color"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+	InQueue bool   `json:"inQueue"`
 }
 
 // JobStatus contains a parsed Jenkins server response about a single job result status
@@ -50,6 +52,7 @@ type JobStatus struct {
 	Culprits          []Culprit   `json:"culprits"`
 	ChangeSets        []ChangeSet `json:"changeSets"`
 	EstimatedDuration int64       `json:"estimatedDuration"`
+	Duration          int64       `json:"duration"`
 	Timestamp         int64       `json:"timestamp"`
 }
 
@@ -97,4 +100,8 @@ type TestCase struct {
 	Name            string `json:"name"`
 	Status          string `json:"status"`
 	ErrorStackTrace string `json:"errorStackTrace"`
+	// Age is the number of builds the test has been failing in, including this one
+	Age int `json:"age"`
+	// FailedSince is the number of the build in which the test started failing
+	FailedSince int `json:"failedSince"`
 }
@@ -18,6 +18,12 @@ var randomCauses = []string{
 	"unknown",
 }
 
+var randomResults = []string{
+	"SUCCESS",
+	"FAILURE",
+	"UNSTABLE",
+}
+
 // GetKnownJobs is a MOCK for call that represents API which gives back list of all known jobs
 func (api *MockAPI) GetKnownJobs() (resultFromJenkins *Status, err error) {
 	resultFromJenkins = &Status{}
@@ -33,8 +39,9 @@ func (api *MockAPI) GetKnownJobs() (resultFromJenkins *Status, err error) {
 			color = "aborted"
 		}
 		resultFromJenkins.JobBuildStatus = append(resultFromJenkins.JobBuildStatus, JobBuildStatus{
-			Name:  fmt.Sprintf("a_test_job_long_name%v", i),
-			Color: color,
+			Name:    fmt.Sprintf("a_test_job_long_name%v", i),
+			Color:   color,
+			InQueue: rand.Intn(10) == 0,
 		})
 	}
 	return resultFromJenkins, nil
@@ -56,8 +63,11 @@ func (api *MockAPI) GetCurrentStatus(job string) (status *JobStatus, err error)
 		})
 	}
 	result := &JobStatus{
+		ID:                fmt.Sprint(rand.Intn(1000)),
+		Result:            randomResults[rand.Intn(len(randomResults))],
 		Building:          rand.Intn(2) == 0,
 		EstimatedDuration: int64(rand.Intn(300000)),
+		Duration:          int64(rand.Intn(300000)),
 		Timestamp:         time.Now().UnixNano()/1000/1000 - int64(rand.Intn(300000)),
 		Culprits:          culprits,
 		Actions: []Action{
@@ -120,7 +130,9 @@ func (api *MockAPI) GetFailedTestList(job string) (testCaseResult []TestCase, er
 			ClassName: randomTests[rand.Intn(len(randomTests))],
 			Name:      randomTests[rand.Intn(len(randomTests))],
 			Status:    "FAILED",
+			Age:       1 + rand.Intn(3),
 		}
+		aCase.FailedSince = 100 - aCase.Age
 		set = append(set, aCase)
 	}
 	return
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
@@ -23,13 +24,70 @@ var (
 	matcherForHTMLAndWeirdCharacters = regexp.MustCompile(`(<[^>]+>)|(\r)`)
 )
 
+// StatusCache keeps statuses of completed job runs, since they don't change anymore.
+// Keys are made of job name and run id
+type StatusCache interface {
+	Get(key string) (*JobStatus, bool)
+	Put(key string, status *JobStatus)
+}
+
+// NewStatusCache creates a StatusCache in memory, safe for concurrent use
+func NewStatusCache() StatusCache {
+	return &mapStatusCache{statuses: make(map[string]*JobStatus)}
+}
+
+type mapStatusCache struct {
+	sync.RWMutex
+	statuses map[string]*JobStatus
+}
+
+func (cache *mapStatusCache) Get(key string) (status *JobStatus, ok bool) {
+	cache.RLock()
+	defer cache.RUnlock()
+	status, ok = cache.statuses[key]
+	return
+}
+
+func (cache *mapStatusCache) Put(key string, status *JobStatus) {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.statuses[key] = status
+}
+
 // ServerAPI is a real-life implementation of the API which connects to a real Jenkins server.
 // Use the given "ServerLocation" field to set the location of the server.
 type ServerAPI struct {
 	ServerLocation string
 	Username string
 	Password string
-	cachedStatuses map[string](*JobStatus)
+	// Cache keeps statuses of completed runs, it has to be safe for concurrent use. Nothing is cached if not set
+	Cache StatusCache
+}
+
+// get visits the link, see send
+func (api *ServerAPI) get(link string) (*http.Response, error) {
+	return api.send("GET", link)
+}
+
+// send makes a request towards Jenkins, authenticated if credentials are set. Jenkins refusing access
+// is reported as an error, since the answer would just be its login page
+func (api *ServerAPI) send(method string, link string) (*http.Response, error) {
+	req, err := http.NewRequest(method, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if api.Username != "" || api.Password != "" {
+		req.SetBasicAuth(api.Username, api.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("access to %v denied by Jenkins: %v", link, resp.Status)
+	}
+	return resp, nil
 }
 
 // GetLastBuildURLForJob will create URL towards a page with LAST job execution result for a particular job
@@ -54,19 +112,17 @@ func (api *ServerAPI) GetStatusForJob(job string, id string) (*JobStatus, error)
 		log.Println("Rejecting StatusForJob since folder structure has been detected and that's not supported: ", job)
 		return nil, errStatusPageNotFound
 	}
-	if id != lastBuild && id != lastCompletedBuild {
-		if api.cachedStatuses == nil {
-			api.cachedStatuses = make(map[string](*JobStatus), 0)
-		}
-		if cachedValue, ok := api.cachedStatuses[possibleCacheKey]; ok {
+	cacheable := api.Cache != nil && id != lastBuild && id != lastCompletedBuild
+	if cacheable {
+		if cachedValue, ok := api.Cache.Get(possibleCacheKey); ok {
 			log.Println("Using from cache: ", possibleCacheKey)
 			return cachedValue, nil
 		}
 	}
-	link := fmt.Sprintf("%v/job/%v/%v/api/json?tree=id,result,timestamp,estimatedDuration,building,culprits[fullName],actions[causes[userId,upstreamBuild,upstreamProject,shortDescription]],changeSets[items[author[fullName]]]",
+	link := fmt.Sprintf("%v/job/%v/%v/api/json?tree=id,result,timestamp,estimatedDuration,duration,building,culprits[fullName],actions[causes[userId,upstreamBuild,upstreamProject,shortDescription]],changeSets[items[author[fullName]]]",
 		api.ServerLocation, job, id)
 	log.Printf("Visiting %v", link)
-	resp, err := http.Get(link)
+	resp, err := api.get(link)
 	if err != nil {
 		return nil, err
 	}
@@ -76,8 +132,8 @@ func (api *ServerAPI) GetStatusForJob(job string, id string) (*JobStatus, error)
 	}
 	result := &JobStatus{}
 	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err == nil && id != lastBuild && id != lastCompletedBuild {
-		api.cachedStatuses[possibleCacheKey] = result
+	if err == nil && cacheable {
+		api.Cache.Put(possibleCacheKey, result)
 	}
 	return result, nil
 }
@@ -85,14 +141,14 @@ func (api *ServerAPI) GetStatusForJob(job string, id string) (*JobStatus, error)
 // GetKnownJobs represents API which gives back list of all known jobs in the Jenkins Server, and their last known
 // (or current, if job is running) state
 func (api *ServerAPI) GetKnownJobs() (resultFromJenkins *Status, err error) {
-	resp, err := http.Get(fmt.Sprintf("%v/api/json?tree=jobs[name,color]", api.ServerLocation))
+	resp, err := api.get(fmt.Sprintf("%v/api/json?tree=jobs[name,color,inQueue]", api.ServerLocation))
 	if err != nil {
 		return
 	}
 	defer func() { _ = resp.Body.Close() }()
 	resultFromJenkins = &Status{}
 	err = json.NewDecoder(resp.Body).Decode(&resultFromJenkins)
-	return resultFromJenkins, nil
+	return
 }
 
 // Causes takes a known job status and finds people ("causes") that caused it to start,
@@ -198,9 +254,9 @@ func (api *ServerAPI) addCauses(set map[string]bool, upstreamProject string, ups
 
 // GetFailedTestListFor will return list of test cases that failed in a particular job execution
 func (api *ServerAPI) GetFailedTestListFor(job, id string) (results []TestCase, err error) {
-	link := fmt.Sprintf("%v/job/%s/%s/testReport/api/json?tree=suites[cases[className,name,status,errorStackTrace]]", api.ServerLocation, job, id)
+	link := fmt.Sprintf("%v/job/%s/%s/testReport/api/json?tree=suites[cases[className,name,status,errorStackTrace,age,failedSince]]", api.ServerLocation, job, id)
 	log.Printf("Visiting %s\n", link)
-	resp, err := http.Get(link)
+	resp, err := api.get(link)
 	if err != nil {
 		return
 	}
@@ -230,8 +286,8 @@ func (api *ServerAPI) GetFailedTestList(job string) ([]TestCase, error) {
 	return api.GetFailedTestListFor(job, "lastFailedBuild")
 }
 
-func fetchSizeForLastLogLines(linkForSize string) (int, error) {
-	resp, err := http.Head(linkForSize)
+func (api *ServerAPI) fetchSizeForLastLogLines(linkForSize string) (int, error) {
+	resp, err := api.send("HEAD", linkForSize)
 	if err != nil {
 		return 0, err
 	}
@@ -247,8 +303,8 @@ func fetchSizeForLastLogLines(linkForSize string) (int, error) {
 	return strconv.Atoi(textSize)
 }
 
-func fetchLinesForLastLogLines(link string, lineCount int) ([]string, error) {
-	respData, err := http.Get(link)
+func (api *ServerAPI) fetchLinesForLastLogLines(link string, lineCount int) ([]string, error) {
+	respData, err := api.get(link)
 	if err != nil {
 		return nil, err
 	}
@@ -279,23 +335,18 @@ func fetchLinesForLastLogLines(link string, lineCount int) ([]string, error) {
 // GetLastLogLines returns lineCount lines from the console output of a job run
 func (api *ServerAPI) GetLastLogLines(job, id string, lineCount int) ([]string, error) {
 	linkForSize := fmt.Sprintf("%v/job/%s/%s/logText/progressiveHtml", api.ServerLocation, job, id)
-	size, err := fetchSizeForLastLogLines(linkForSize)
+	size, err := api.fetchSizeForLastLogLines(linkForSize)
 	if err != nil {
 		return nil, err
 	}
-	return fetchLinesForLastLogLines(fmt.Sprintf("%s?start=%d", linkForSize, size-sizeOfSuffix), lineCount)
+	return api.fetchLinesForLastLogLines(fmt.Sprintf("%s?start=%d", linkForSize, size-sizeOfSuffix), lineCount)
 }
 
 // RunJob will execute a job (expected - without parameters)
 func (api *ServerAPI) RunJob(job string) error {
 	linkForRun := fmt.Sprintf("%v/job/%s/build?delay=0sec", api.ServerLocation, job)
 	log.Printf("Visiting %s\n", linkForRun)
-	req, err := http.NewRequest("POST", linkForRun, nil)
-	req.SetBasicAuth(api.Username, api.Password)
-	if err != nil {
-		return err
-	}
-	respData, err := http.DefaultClient.Do(req)
+	respData, err := api.send("POST", linkForRun)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,9 @@ package jenkins
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -91,12 +94,16 @@ func TestParsingTestingReport(t *testing.T) {
         {
           "className" : "com.foobar.at.FailingTest",
           "name" : "testMethod2",
-          "status" : "REGRESSION"
+          "status" : "REGRESSION",
+          "age" : 1,
+          "failedSince" : 42
         },
         {
           "className" : "com.foobar.at.FailingTest",
           "name" : "testMethod3",
-          "status" : "FAILED"
+          "status" : "FAILED",
+          "age" : 5,
+          "failedSince" : 38
         }
       ]
     },
@@ -129,7 +136,72 @@ func TestParsingTestingReport(t *testing.T) {
 	if status.Suites[0].Cases[2].Status != "FAILED" {
 		t.Fatal("Did not parse ClassName")
 	}
+	if status.Suites[0].Cases[2].Age != 5 || status.Suites[0].Cases[2].FailedSince != 38 {
+		t.Fatal("Did not parse age of the failure")
+	}
 	if status.Suites[1].Cases[0].Name != "testMethodX" {
 		t.Fatal("Did not parse suite 2 case 1 method name")
 	}
 }
+
+// jenkinsRequiringAuth is a Jenkins which answers only to the user "clici" with the password "secret"
+func jenkinsRequiringAuth(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "clici" || password != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/api/json":
+			_, _ = w.Write([]byte(`{"jobs":[{"name":"job1","color":"red"}]}`))
+		case r.URL.Path == "/job/job1/lastBuild/api/json":
+			_, _ = w.Write([]byte(`{"id":"5","result":"FAILURE"}`))
+		case r.URL.Path == "/job/job1/5/testReport/api/json":
+			_, _ = w.Write([]byte(`{"suites":[{"cases":[{"className":"SomeTest","name":"test1","status":"FAILED"}]}]}`))
+		case r.URL.Path == "/job/job1/5/logText/progressiveHtml" && r.Method == "HEAD":
+			w.Header().Set("X-Text-Size", "16")
+		case r.URL.Path == "/job/job1/5/logText/progressiveHtml":
+			_, _ = w.Write([]byte("cut\nline1\nline2\n"))
+		case r.URL.Path == "/job/job1/build" && r.Method == "POST":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCredentialsAreSentWithEveryRequest(t *testing.T) {
+	server := jenkinsRequiringAuth(t)
+	defer server.Close()
+	api := &ServerAPI{ServerLocation: server.URL, Username: "clici", Password: "secret"}
+
+	if status, err := api.GetKnownJobs(); err != nil || len(status.JobBuildStatus) != 1 || status.JobBuildStatus[0].Name != "job1" {
+		t.Fatalf("Expected job1 to be known, got: %+v (%v)", status, err)
+	}
+	if status, err := api.GetCurrentStatus("job1"); err != nil || status.ID != "5" {
+		t.Fatalf("Expected current status of job1, got: %+v (%v)", status, err)
+	}
+	if tests, err := api.GetFailedTestListFor("job1", "5"); err != nil || len(tests) != 1 || tests[0].Name != "test1" {
+		t.Fatalf("Expected failed test1, got: %+v (%v)", tests, err)
+	}
+	if lines, err := api.GetLastLogLines("job1", "5", 10); err != nil || !reflect.DeepEqual(lines, []string{"line1", "line2"}) {
+		t.Fatalf("Expected the log lines, got: %v (%v)", lines, err)
+	}
+	if err := api.RunJob("job1"); err != nil {
+		t.Fatalf("Expected job1 to be started, got: %v", err)
+	}
+}
+
+func TestRefusedAccessIsAnError(t *testing.T) {
+	server := jenkinsRequiringAuth(t)
+	defer server.Close()
+	for _, api := range []*ServerAPI{
+		{ServerLocation: server.URL},
+		{ServerLocation: server.URL, Username: "clici", Password: "wrong"},
+	} {
+		if status, err := api.GetKnownJobs(); err == nil {
+			t.Fatalf("Expected access to be refused with credentials %q/%q, got: %+v", api.Username, api.Password, status)
+		}
+	}
+}
@@ -3,15 +3,63 @@ package model
 import (
 	"log"
 	"strings"
+	"time"
 )
 
 // State is the program state model that mutates based on the Jenkins server state
 // and based on human interaction with the view
 type State struct {
 	JobStates   []JobState
-	FailedTests []string
+	FailedTests []TestCase
 	Error       error
 	ShowHelp    bool
+	// DisconnectedSince is set when job states come from Clici server and connection towards it is lost,
+	// job states are then the last ones known before that moment
+	DisconnectedSince time.Time
+	// Refresh tells how far the refresh of Jenkins servers that is in progress has got
+	Refresh RefreshProgress
+	// Details, when set, asks view to show everything known about the last build of a single job
+	Details *JobDetails
+}
+
+// TestCase is a single test of a build, with the stack trace of its failure
+type TestCase struct {
+	ClassName  string
+	Name       string
+	Status     string
+	StackTrace string
+	// Age is the number of builds the test has been failing in (zero if not known), FailedSince is the
+	// number of the build in which it started failing
+	Age         int
+	FailedSince int
+}
+
+// JobDetails is everything known about the last build of a single job
+type JobDetails struct {
+	// Job is the index of the job in JobStates
+	Job               int
+	JobName           string
+	Server            string
+	BuildID           string
+	Result            string
+	Building          bool
+	Started           time.Time
+	Duration          time.Duration
+	EstimatedDuration time.Duration
+	// Causes explain why the build started, causes of upstream builds follow (indented) the cause they belong to
+	Causes   []string
+	Culprits []string
+	// Authors of changes included in the build
+	Authors []string
+	// FailedTestCount is negative when it is not known
+	FailedTestCount int
+	LogLines        []string
+}
+
+// RefreshProgress counts servers visited during a refresh. Refresh is not in progress when Total is zero
+type RefreshProgress struct {
+	Done  int
+	Total int
 }
 
 // BuildStatus is a model way of representing a status of a certain job in Jenkins
@@ -60,4 +108,9 @@ type JobState struct {
 	Error            error
 	PreviousState    BuildStatus
 	Building         bool
+	// Queued is set while a build of the job waits in the Jenkins queue
+	Queued bool
+	// Started is when the last build started and Duration how long it took (or is expected to take, while building)
+	Started  time.Time
+	Duration time.Duration
 }